@@ -0,0 +1,47 @@
+package main
+
+import (
+	"backend/internal/chat"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runBench measures hub throughput with synthetic in-process connections.
+// It needs neither a database nor a running server.
+func runBench(args []string) error {
+	fs := newFlagSet("bench")
+	connections := fs.Int("connections", 10000, "number of synthetic connections")
+	messages := fs.Int("messages", 100, "messages broadcast to every connection")
+	shardList := fs.String("shards", "1,0", "comma separated shard counts to compare (0 = one per CPU)")
+	slow := fs.String("slow-consumer", "disconnect", "slow consumer policy: disconnect, drop or coalesce")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	policy, err := chat.ParseSlowConsumerPolicy(*slow)
+	if err != nil {
+		return usageError{err}
+	}
+
+	var shards []int
+	for _, s := range strings.Split(*shardList, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 0 {
+			return usageErrorf("invalid -shards %q", *shardList)
+		}
+		shards = append(shards, n)
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "shards\tconnections\tconnect\tbroadcast\tmessages/s\tframes/s\tdisconnected\tframes dropped\tcoalesced\t")
+	for _, n := range shards {
+		r := chat.Benchmark(chat.BenchmarkConfig{Connections: *connections, Messages: *messages, Shards: n, SlowConsumer: policy})
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%.0f\t%.0f\t%d\t%d\t%d\t\n", r.Shards, r.Connections,
+			r.ConnectTime.Round(time.Millisecond), r.BroadcastTime.Round(time.Millisecond), r.MessagesPerSecond(), r.FramesPerSecond(),
+			r.Dropped, r.SlowConsumers["frames_dropped"], r.SlowConsumers["presence_coalesced"])
+	}
+	return w.Flush()
+}
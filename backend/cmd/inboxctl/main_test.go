@@ -0,0 +1,268 @@
+package main
+
+import (
+	"backend/internal/admin"
+	"backend/internal/audit"
+	"backend/internal/auth"
+	"backend/internal/mail"
+	"backend/internal/models"
+	"backend/internal/store"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useMemoryStore points the commands at a fresh in-memory store, so setup
+// does not connect to a database.
+func useMemoryStore(t *testing.T) *store.Memory {
+	t.Helper()
+	t.Setenv("MAIL_DRIVER", "log")
+	t.Setenv("MAIL_LOG_FILE", t.TempDir()+"/mail.log")
+	mem := store.NewMemory()
+	db = mem
+	auth.SetStore(mem)
+	audit.SetStore(mem)
+	admin.SetStore(mem)
+	mail.Setup()
+	auth.SetupPasswordHasher()
+	auth.SetupPasswordPolicy()
+	t.Cleanup(func() { db = nil })
+	return mem
+}
+
+// runCapture runs inboxctl with args and returns the exit code and output.
+func runCapture(t *testing.T, input string, args ...string) (int, string, string) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	stdin, stdout, stderr = strings.NewReader(input), &out, &errOut
+	code := run(args)
+	return code, out.String(), errOut.String()
+}
+
+func TestRunUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		code int
+		out  string
+		err  string
+	}{
+		{"no command", nil, exitUsage, "", "Usage: inboxctl"},
+		{"unknown command", []string{"frobnicate"}, exitUsage, "", `Unknown command "frobnicate"`},
+		{"help", []string{"help"}, exitOK, "Usage: inboxctl", ""},
+		{"missing user subcommand", []string{"user"}, exitUsage, "", "missing user subcommand"},
+		{"unknown user subcommand", []string{"user", "frobnicate"}, exitUsage, "", `unknown user subcommand "frobnicate"`},
+		{"missing messages subcommand", []string{"messages"}, exitUsage, "", "missing messages subcommand"},
+		{"unknown migrate subcommand", []string{"migrate", "sideways"}, exitUsage, "", `unknown migrate subcommand "sideways"`},
+		{"invalid migrate steps", []string{"migrate", "down", "0"}, exitUsage, "", `invalid step count "0"`},
+		{"invalid shards", []string{"bench", "-shards", "a,b"}, exitUsage, "", `invalid -shards "a,b"`},
+		{"invalid slow consumer policy", []string{"bench", "-slow-consumer", "ignore"}, exitUsage, "", "Error:"},
+		{"flag help", []string{"bench", "-h"}, exitOK, "", "-connections"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, out, errOut := runCapture(t, "", tt.args...)
+			if code != tt.code {
+				t.Errorf("exit code = %d, want %d (stderr: %s)", code, tt.code, errOut)
+			}
+			if !strings.Contains(out, tt.out) {
+				t.Errorf("stdout = %q, want it to contain %q", out, tt.out)
+			}
+			if !strings.Contains(errOut, tt.err) {
+				t.Errorf("stderr = %q, want it to contain %q", errOut, tt.err)
+			}
+		})
+	}
+}
+
+func TestUserCommands(t *testing.T) {
+	mem := useMemoryStore(t)
+	const password = "Correct-Horse-9-Battery"
+
+	steps := []struct {
+		name  string
+		input string
+		args  []string
+		code  int
+		out   string
+	}{
+		{"create needs username and email", "", []string{"user", "create", "-username", "carol"}, exitUsage, ""},
+		{"create rejects unknown flags", "", []string{"user", "create", "-colour", "red"}, exitUsage, ""},
+		{"create rejects unknown roles", "", []string{"user", "create", "-username", "carol", "-email", "carol@example.com", "-role", "wizard"}, exitUsage, ""},
+		{"create", "", []string{"user", "create", "-username", "carol", "-email", "carol@example.com", "-password", password, "-role", "moderator"}, exitOK, "Created user carol"},
+		{"create duplicate", "", []string{"user", "create", "-username", "carol", "-email", "carol@example.com", "-password", password}, exitFailed, ""},
+		{"create reads the password from stdin", password + "\n", []string{"user", "create", "-username", "dave", "-email", "dave@example.com"}, exitOK, "Created user dave"},
+		{"list", "", []string{"user", "list", "-role", "moderator"}, exitOK, "1 of 1 users"},
+		{"promote by email", "", []string{"user", "promote", "carol@example.com", "-role", "admin"}, exitOK, "User carol is now admin"},
+		{"disable unknown user", "", []string{"user", "disable", "nobody"}, exitFailed, ""},
+		{"disable needs one user", "", []string{"user", "disable"}, exitUsage, ""},
+		{"disable", "", []string{"user", "disable", "dave"}, exitOK, "User dave disabled"},
+	}
+	for _, step := range steps {
+		code, out, errOut := runCapture(t, step.input, step.args...)
+		if code != step.code {
+			t.Fatalf("%s: exit code = %d, want %d (stderr: %s)", step.name, code, step.code, errOut)
+		}
+		if !strings.Contains(out, step.out) {
+			t.Fatalf("%s: stdout = %q, want it to contain %q", step.name, out, step.out)
+		}
+	}
+
+	carol, _, err := mem.GetUserByLogin("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if carol.Role != auth.RoleAdmin || !carol.EmailVerified {
+		t.Errorf("carol: role %q, email verified %v; want admin, verified", carol.Role, carol.EmailVerified)
+	}
+	dave, _, err := mem.GetUserByLogin("dave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dave.DisabledAt == nil {
+		t.Error("dave was not disabled")
+	}
+}
+
+func TestMessagesExportAndImport(t *testing.T) {
+	mem := useMemoryStore(t)
+	alice := &models.User{Username: "alice", Email: "alice@example.com"}
+	if err := mem.CreateUser(alice, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, content := range []string{"first", "second", "third"} {
+		msg := &models.Message{UserID: alice.ID, Username: "alice", Content: content, CreatedAt: start.Add(time.Duration(i) * time.Hour)}
+		if err := mem.SaveMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code, out, errOut := runCapture(t, "", "messages", "export", "-since", start.Add(time.Hour).Format(time.RFC3339))
+	if code != exitOK || !strings.Contains(errOut, "Exported 2 messages") {
+		t.Fatalf("export -since: exit code %d, stderr %q", code, errOut)
+	}
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 2 || !strings.Contains(lines[0], `"second"`) {
+		t.Errorf("export -since wrote %q", out)
+	}
+	if code, _, _ := runCapture(t, "", "messages", "export", "-since", "yesterday"); code != exitUsage {
+		t.Errorf("invalid -since: exit code %d, want %d", code, exitUsage)
+	}
+
+	file := filepath.Join(t.TempDir(), "messages.jsonl")
+	if code, _, errOut := runCapture(t, "", "messages", "export", "-o", file); code != exitOK {
+		t.Fatalf("export -o: exit code %d, stderr %q", code, errOut)
+	}
+
+	// Import into a store where alice does not exist: the messages keep
+	// their username and time but lose the user reference.
+	target := useMemoryStore(t)
+	code, _, errOut = runCapture(t, "", "messages", "import", "-i", file)
+	if code != exitOK || !strings.Contains(errOut, "Imported 3 messages") {
+		t.Fatalf("import: exit code %d, stderr %q", code, errOut)
+	}
+	var imported []models.Message
+	target.EachMessage(time.Time{}, func(msg models.Message) error {
+		imported = append(imported, msg)
+		return nil
+	})
+	if len(imported) != 3 {
+		t.Fatalf("imported %d messages, want 3", len(imported))
+	}
+	for i, msg := range imported {
+		if msg.UserID != 0 || msg.Username != "alice" || !msg.CreatedAt.Equal(start.Add(time.Duration(i)*time.Hour)) {
+			t.Errorf("imported message %d: %+v", i, msg)
+		}
+	}
+
+	// A bad record fails the import without saving the records before it.
+	input := `{"username":"bob","content":"ok"}` + "\n" + `{"content":` + "\n"
+	if code, _, _ := runCapture(t, input, "messages", "import"); code != exitFailed {
+		t.Errorf("import of a bad record: exit code %d, want %d", code, exitFailed)
+	}
+	if stats, _ := target.Stats(); stats["messages"] != 3 {
+		t.Errorf("%d messages after a failed import, want 3", stats["messages"])
+	}
+
+	// Importing the same file again, or an export of the imported
+	// messages, saves nothing new.
+	again := filepath.Join(t.TempDir(), "again.jsonl")
+	if code, _, errOut := runCapture(t, "", "messages", "export", "-o", again); code != exitOK {
+		t.Fatalf("export of the import: exit code %d, stderr %q", code, errOut)
+	}
+	for _, f := range []string{file, again} {
+		if code, _, errOut := runCapture(t, "", "messages", "import", "-i", f); code != exitOK || !strings.Contains(errOut, "Imported 0 messages") {
+			t.Errorf("repeated import: exit code %d, stderr %q", code, errOut)
+		}
+	}
+	if stats, _ := target.Stats(); stats["messages"] != 3 {
+		t.Errorf("%d messages after repeating the import, want 3", stats["messages"])
+	}
+}
+
+func TestMessagesExportReportsWriteErrors(t *testing.T) {
+	mem := useMemoryStore(t)
+	if err := mem.SaveMessage(&models.Message{Username: "alice", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	// Writes to /dev/full fail with ENOSPC, here when the buffer is flushed.
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	code, _, errOut := runCapture(t, "", "messages", "export", "-o", "/dev/full")
+	if code != exitFailed || strings.Contains(errOut, "Exported") {
+		t.Errorf("export to a full device: exit code %d, stderr %q", code, errOut)
+	}
+}
+
+func TestStats(t *testing.T) {
+	mem := useMemoryStore(t)
+	alice := &models.User{Username: "alice", Email: "alice@example.com"}
+	if err := mem.CreateUser(alice, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.SaveMessage(&models.Message{UserID: alice.ID, Username: "alice", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	code, out, errOut := runCapture(t, "", "stats")
+	if code != exitOK {
+		t.Fatalf("exit code %d, stderr %q", code, errOut)
+	}
+	// Compare with runs of spaces collapsed, so column widths don't matter.
+	fields := func(s string) string { return strings.Join(strings.Fields(s), " ") }
+	for _, want := range []string{"users 1", "messages (24h) 1", "pass -server"} {
+		if !strings.Contains(fields(out), want) {
+			t.Errorf("stats output %q does not contain %q", out, want)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/admin/stats" || r.Header.Get("Authorization") != "Bearer admin-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"success":false,"error":"Unauthorized"}`))
+			return
+		}
+		w.Write([]byte(`{"success":true,"data":{"connections":3,"online_users":2,"uptime_seconds":60,"persistence":{"queued":0,"saved":5,"failed":0,"retries":1}}}`))
+	}))
+	defer server.Close()
+
+	code, out, errOut = runCapture(t, "", "stats", "-server", server.URL+"/", "-token", "admin-token")
+	if code != exitOK {
+		t.Fatalf("with -server: exit code %d, stderr %q", code, errOut)
+	}
+	for _, want := range []string{"connections 3", "messages saved 5"} {
+		if !strings.Contains(fields(out), want) {
+			t.Errorf("hub stats output %q does not contain %q", out, want)
+		}
+	}
+	code, _, errOut = runCapture(t, "", "stats", "-server", server.URL, "-token", "wrong")
+	if code != exitFailed || !strings.Contains(errOut, "Unauthorized (HTTP 401)") {
+		t.Errorf("with a bad token: exit code %d, stderr %q", code, errOut)
+	}
+}
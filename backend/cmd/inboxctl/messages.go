@@ -0,0 +1,138 @@
+package main
+
+import (
+	"backend/internal/models"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+func runMessages(args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("missing messages subcommand (export or import)")
+	}
+	var command func([]string) error
+	switch args[0] {
+	case "export":
+		command = messagesExport
+	case "import":
+		command = messagesImport
+	default:
+		return usageErrorf("unknown messages subcommand %q", args[0])
+	}
+	setup()
+	return command(args[1:])
+}
+
+// exportedMessage is one line of an export. Its client key lets a repeated
+// import skip the messages it already saved.
+type exportedMessage struct {
+	models.Message
+	ClientKey string `json:"client_key,omitempty"`
+}
+
+// exportKey returns msg's client key, or one derived from its content for
+// messages saved without a key.
+func exportKey(msg models.Message) string {
+	if msg.ClientKey != "" {
+		return msg.ClientKey
+	}
+	sum := sha256.Sum256([]byte(msg.CreatedAt.UTC().Format(time.RFC3339Nano) + "\x00" + msg.Username + "\x00" + msg.Content))
+	return "import-" + hex.EncodeToString(sum[:16])
+}
+
+// messagesExport writes messages as JSON lines, oldest first.
+func messagesExport(args []string) (err error) {
+	fs := newFlagSet("messages export")
+	output := fs.String("o", "", "output file (default stdout)")
+	since := fs.String("since", "", "only messages created at or after this RFC 3339 time")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var sinceTime time.Time
+	if *since != "" {
+		var err error
+		if sinceTime, err = time.Parse(time.RFC3339, *since); err != nil {
+			return usageErrorf("invalid -since: %w", err)
+		}
+	}
+
+	var w io.Writer = stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		w = f
+	}
+	buffered := bufio.NewWriter(w)
+	enc := json.NewEncoder(buffered)
+
+	count := 0
+	err = db.EachMessage(sinceTime, func(msg models.Message) error {
+		count++
+		return enc.Encode(exportedMessage{Message: msg, ClientKey: exportKey(msg)})
+	})
+	if err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "Exported %d messages\n", count)
+	return nil
+}
+
+// messagesImport reads JSON lines produced by export. Message IDs are
+// reassigned; messages whose user no longer exists keep their username but
+// lose the user reference. Messages already imported, or exported from this
+// database, are recognised by their client key and skipped, so an import can
+// be repeated.
+func messagesImport(args []string) error {
+	fs := newFlagSet("messages import")
+	input := fs.String("i", "", "input file (default stdin)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var r io.Reader = stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	count, err := db.ImportMessages(func() (*models.Message, error) {
+		var record exportedMessage
+		if err := dec.Decode(&record); err != nil {
+			return nil, err
+		}
+		msg := record.Message
+		msg.ClientKey = record.ClientKey
+		if msg.ClientKey == "" {
+			// Written by hand or by an older export.
+			msg.ClientKey = exportKey(msg)
+		}
+		return &msg, nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "Imported %d messages\n", count)
+	return nil
+}
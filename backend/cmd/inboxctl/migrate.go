@@ -0,0 +1,64 @@
+package main
+
+import (
+	"backend/internal/database"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+)
+
+func runMigrate(args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	steps := 1
+	switch command {
+	case "up", "status":
+	case "down":
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return usageErrorf("invalid step count %q", args[1])
+			}
+		}
+	default:
+		return usageErrorf("unknown migrate subcommand %q", command)
+	}
+
+	database.Open()
+	switch command {
+	case "up":
+		n, err := database.MigrateUp()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Applied %d migrations\n", n)
+		return nil
+
+	case "down":
+		n, err := database.MigrateDown(steps)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Rolled back %d migrations\n", n)
+		return nil
+
+	case "status":
+		statuses, err := database.MigrationStatuses()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func runStats(args []string) error {
+	fs := newFlagSet("stats")
+	server := fs.String("server", os.Getenv("INBOXLY_SERVER"), "server base URL for live hub stats")
+	token := fs.String("token", os.Getenv("INBOXLY_ADMIN_TOKEN"), "admin JWT for the server")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	setup()
+	stats, err := db.Stats()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Database")
+	counts := []struct{ label, key string }{
+		{"users", "users"},
+		{"disabled users", "disabled_users"},
+		{"messages", "messages"},
+		{"messages (24h)", "messages_24h"},
+		{"active api tokens", "active_api_tokens"},
+		{"audit events", "audit_events"},
+	}
+	for _, c := range counts {
+		fmt.Fprintf(w, "  %s\t%d\n", c.label, stats[c.key])
+	}
+	if size, ok := stats["database_bytes"]; ok {
+		fmt.Fprintf(w, "  database size\t%.1f MB\n", float64(size)/(1<<20))
+	}
+	w.Flush()
+
+	if *server == "" {
+		fmt.Fprintln(stdout, "\nHub: pass -server and -token (or INBOXLY_SERVER/INBOXLY_ADMIN_TOKEN) for live stats")
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(*server, "/")+"/api/admin/stats", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("hub stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Success bool                   `json:"success"`
+		Error   string                 `json:"error"`
+		Data    map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("hub stats: %w", err)
+	}
+	if !body.Success {
+		return fmt.Errorf("hub stats: %s (HTTP %d)", body.Error, resp.StatusCode)
+	}
+
+	fmt.Fprintln(stdout, "\nHub")
+	w = tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, key := range []string{"connections", "online_users", "uptime_seconds"} {
+		fmt.Fprintf(w, "  %s\t%v\n", strings.ReplaceAll(key, "_", " "), body.Data[key])
+	}
+	if slow, ok := body.Data["slow_consumers"].(map[string]interface{}); ok {
+		for _, key := range []string{"disconnected", "frames_dropped", "presence_coalesced"} {
+			fmt.Fprintf(w, "  slow consumers %s\t%v\n", strings.ReplaceAll(key, "_", " "), slow[key])
+		}
+	}
+	if persistence, ok := body.Data["persistence"].(map[string]interface{}); ok {
+		for _, key := range []string{"queued", "saved", "failed", "retries"} {
+			fmt.Fprintf(w, "  messages %s\t%v\n", key, persistence[key])
+		}
+	}
+	return w.Flush()
+}
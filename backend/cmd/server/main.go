@@ -1,12 +1,23 @@
 package main
 
 import (
+	"backend/internal/admin"
+	"backend/internal/audit"
 	"backend/internal/auth"
 	"backend/internal/chat"
-	"backend/internal/database"
+	"backend/internal/cluster"
+	"backend/internal/mail"
+	"backend/internal/persist"
+	"backend/internal/store"
 
+	"context"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -17,9 +28,29 @@ func main() {
 	// Load environment variables
 	godotenv.Load()
 
-	// Connect to database
-	database.Connect()
-	defer database.DB.Close()
+	// Open storage and hand it to the packages that persist data
+	db := store.Open()
+	defer db.Close()
+	auth.SetStore(db)
+	audit.SetStore(db)
+	admin.SetStore(db)
+	broker := cluster.Setup()
+	defer broker.Close()
+	messages := chat.SetupCache(db, broker)
+	writer := persist.Setup(messages)
+	defer writer.Close()
+	chat.Start(messages, writer, broker)
+
+	// Configure outgoing mail
+	mail.Setup()
+
+	// Configure password hashing, policy and authentication backends
+	auth.SetupPasswordHasher()
+	auth.SetupPasswordPolicy()
+	auth.SetupAuthenticators()
+
+	// Configure single sign-on (optional)
+	auth.SetupOIDC()
 
 	// Setup Gin router
 	r := gin.Default()
@@ -48,15 +79,53 @@ func main() {
 		{
 			authGroup.POST("/register", auth.RegisterHandler)
 			authGroup.POST("/login", auth.LoginHandler)
-			authGroup.GET("/profile", auth.AuthMiddleware(), auth.ProfileHandler)
+			authGroup.GET("/profile", auth.AuthMiddleware(), auth.RequireScope(auth.ScopeProfileRead), auth.ProfileHandler)
+			authGroup.POST("/verify-email", auth.VerifyEmailHandler)
+			authGroup.POST("/resend-verification", auth.AuthMiddleware(), auth.ResendVerificationHandler)
+			authGroup.POST("/forgot-password", auth.ForgotPasswordHandler)
+			authGroup.POST("/reset-password", auth.ResetPasswordHandler)
+			authGroup.GET("/oidc/login", auth.OIDCLoginHandler)
+			authGroup.GET("/oidc/callback", auth.OIDCCallbackHandler)
+
+			// Personal API tokens can only be managed from a login session
+			tokenGroup := authGroup.Group("/tokens", auth.AuthMiddleware(), auth.RequireSession())
+			tokenGroup.GET("", auth.ListAPITokensHandler)
+			tokenGroup.POST("", auth.CreateAPITokenHandler)
+			tokenGroup.DELETE("/:id", auth.RevokeAPITokenHandler)
 		}
 		chatGroup := api.Group("/chat")
 		{
-			chatGroup.GET("/messages", chat.GetMessagesHandler)
+			chatGroup.GET("/messages", auth.AuthMiddleware(), auth.RequireScope(auth.ScopeMessagesRead), auth.RequirePermission(auth.PermReadMessages), chat.GetMessagesHandler)
+			chatGroup.GET("/messages/search", auth.AuthMiddleware(), auth.RequireScope(auth.ScopeMessagesRead), auth.RequirePermission(auth.PermReadMessages), chat.SearchMessagesHandler)
 			chatGroup.GET("/ws", auth.WebSocketAuthMiddleware(), chat.WebSocketHandler)
-			chatGroup.POST("/messages", auth.AuthMiddleware(), chat.SendMessageHandler)
+			chatGroup.GET("/stream", auth.WebSocketAuthMiddleware(), chat.StreamHandler)
+			chatGroup.GET("/poll", auth.AuthMiddleware(), auth.RequireScope(auth.ScopeMessagesRead), chat.PollHandler)
+			chatGroup.POST("/messages", auth.AuthMiddleware(), chat.RequireNotMuted(), auth.RequireScope(auth.ScopeMessagesWrite), auth.RequirePermission(auth.PermSendMessages), chat.SendMessageHandler)
 		}
 
+		moderationGroup := api.Group("/moderation", auth.AuthMiddleware(), auth.RequireSession())
+		{
+			moderationGroup.POST("/kick", auth.RequirePermission(auth.PermKickUsers), chat.ModerationHandler(chat.ActionKick))
+			moderationGroup.POST("/mute", auth.RequirePermission(auth.PermMuteUsers), chat.ModerationHandler(chat.ActionMute))
+			moderationGroup.POST("/unmute", auth.RequirePermission(auth.PermMuteUsers), chat.ModerationHandler(chat.ActionUnmute))
+			moderationGroup.POST("/ban", auth.RequirePermission(auth.PermBanUsers), chat.ModerationHandler(chat.ActionBan))
+			moderationGroup.POST("/unban", auth.RequirePermission(auth.PermBanUsers), chat.ModerationHandler(chat.ActionUnban))
+		}
+
+		adminGroup := api.Group("/admin", auth.AuthMiddleware(), auth.RequireSession())
+		{
+			adminGroup.GET("/audit", auth.RequirePermission(auth.PermViewAudit), audit.QueryHandler)
+			adminGroup.GET("/stats", auth.RequirePermission(auth.PermManageUsers), admin.StatsHandler)
+
+			usersGroup := adminGroup.Group("/users", auth.RequirePermission(auth.PermManageUsers))
+			usersGroup.GET("", admin.ListUsersHandler)
+			usersGroup.GET("/:id", admin.GetUserHandler)
+			usersGroup.GET("/:id/connections", admin.UserConnectionsHandler)
+			usersGroup.POST("/:id/disable", admin.SetDisabledHandler(true))
+			usersGroup.POST("/:id/enable", admin.SetDisabledHandler(false))
+			usersGroup.POST("/:id/force-password-reset", admin.ForcePasswordResetHandler)
+			usersGroup.PUT("/:id/role", auth.RequirePermission(auth.PermManageRoles), admin.UpdateRoleHandler)
+		}
 	}
 
 	// Start server
@@ -65,8 +134,38 @@ func main() {
 		port = "8080"
 	}
 	r.SetTrustedProxies([]string{"127.0.0.1"})
-	log.Printf("Server starting on port %s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Failed to start server:", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	shutdown(srv)
+}
+
+// shutdown drains WebSocket clients, then stops the HTTP server. It gives up
+// waiting after SHUTDOWN_TIMEOUT seconds (default 30). The message writer,
+// the broker and storage are closed by main's deferred calls afterwards.
+func shutdown(srv *http.Server) {
+	timeout := 30 * time.Second
+	if seconds, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil && seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.Println("Shutting down: closing WebSocket connections")
+	if err := chat.Shutdown(ctx); err != nil {
+		log.Printf("WebSocket connections did not close in time: %v", err)
+	}
+	log.Println("Shutting down: waiting for HTTP requests")
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP server did not stop in time: %v", err)
 	}
+	log.Println("Server stopped")
 }
@@ -0,0 +1,221 @@
+package admin
+
+import (
+	"backend/internal/audit"
+	"backend/internal/auth"
+	"backend/internal/chat"
+	"backend/internal/models"
+	"backend/pkg/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UserDetail struct {
+	models.User
+	Connections []chat.ConnectionInfo `json:"connections"`
+}
+
+func ListUsersHandler(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	users, total, err := ListUsers(UserFilter{
+		Query:  c.Query("q"),
+		Role:   c.Query("role"),
+		Status: c.Query("status"),
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch users", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Users retrieved successfully", gin.H{
+		"users":  users,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// targetUser loads the user named by the :id path parameter, writing an
+// error response and returning nil if it can't.
+func targetUser(c *gin.Context) *models.User {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid user id", "invalid_id")
+		return nil
+	}
+	user, err := GetUser(userID)
+	if err != nil {
+		if isNotFound(err) {
+			utils.ErrorResponse(c, http.StatusNotFound, "User not found", "user_not_found")
+			return nil
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch user", err.Error())
+		return nil
+	}
+	return user
+}
+
+// requireOther rejects admin actions aimed at the caller's own account, so an
+// admin can't lock themselves out.
+func requireOther(c *gin.Context, user *models.User) bool {
+	if user.ID == c.GetInt("user_id") {
+		utils.ErrorResponse(c, http.StatusBadRequest, "You can't change your own account here", "self_action")
+		return false
+	}
+	return true
+}
+
+func GetUserHandler(c *gin.Context) {
+	user := targetUser(c)
+	if user == nil {
+		return
+	}
+	utils.SuccessResponse(c, "User retrieved successfully", UserDetail{
+		User:        *user,
+		Connections: chat.UserConnections(user.ID),
+	})
+}
+
+func UserConnectionsHandler(c *gin.Context) {
+	user := targetUser(c)
+	if user == nil {
+		return
+	}
+	utils.SuccessResponse(c, "Connections retrieved successfully", chat.UserConnections(user.ID))
+}
+
+func SetDisabledHandler(disabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := targetUser(c)
+		if user == nil || !requireOther(c, user) {
+			return
+		}
+
+		if err := SetDisabled(user.ID, disabled); err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update user", err.Error())
+			return
+		}
+		if disabled {
+			chat.DisconnectUser(user.ID)
+		}
+
+		audit.RecordRequest(c, audit.Event{
+			Action:     audit.ActionAdminUserUpdated,
+			TargetType: "user",
+			TargetID:   strconv.Itoa(user.ID),
+			Metadata:   map[string]interface{}{"target_username": user.Username, "disabled": disabled},
+		})
+
+		message := "User enabled"
+		if disabled {
+			message = "User disabled"
+		}
+		utils.SuccessResponse(c, message, nil)
+	}
+}
+
+func UpdateRoleHandler(c *gin.Context) {
+	user := targetUser(c)
+	if user == nil || !requireOther(c, user) {
+		return
+	}
+
+	var req models.UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Unknown role "+req.Role, "invalid_role")
+		return
+	}
+
+	if err := SetRole(user.ID, req.Role); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to update role", err.Error())
+		return
+	}
+
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionAdminRoleChanged,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(user.ID),
+		Metadata:   map[string]interface{}{"target_username": user.Username, "from": user.Role, "to": req.Role},
+	})
+
+	// Requests read the role from the store, but open connections hold the
+	// one they joined with; reconnecting picks up the new one.
+	chat.DisconnectUser(user.ID)
+	utils.SuccessResponse(c, "Role updated", gin.H{"user_id": user.ID, "role": req.Role})
+}
+
+// ForcePasswordResetHandler invalidates the user's password, sessions and
+// API tokens, and emails them a reset link.
+func ForcePasswordResetHandler(c *gin.Context) {
+	user := targetUser(c)
+	if user == nil || !requireOther(c, user) {
+		return
+	}
+
+	// Invalidating the password also ends the sessions issued before it.
+	if err := InvalidatePassword(user.ID); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to reset password", err.Error())
+		return
+	}
+	revoked, err := auth.RevokeAPITokens(user.ID)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Password invalidated but API tokens were not revoked", err.Error())
+		return
+	}
+	chat.DisconnectUser(user.ID)
+
+	// The password is gone even if the email fails, so audit it either way.
+	mailErr := auth.RequestPasswordReset(user.Email)
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionAdminPasswordReset,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(user.ID),
+		Metadata: map[string]interface{}{
+			"target_username":    user.Username,
+			"api_tokens_revoked": revoked,
+			"email_sent":         mailErr == nil,
+		},
+	})
+	if mailErr != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Password invalidated but reset email failed", mailErr.Error())
+		return
+	}
+	utils.SuccessResponse(c, "Password reset email sent", nil)
+}
+
+var startedAt = time.Now()
+
+// StatsHandler reports live hub statistics for this server instance.
+func StatsHandler(c *gin.Context) {
+	connections := chat.UserConnections(0)
+	users := make(map[int]bool)
+	for _, conn := range connections {
+		users[conn.UserID] = true
+	}
+
+	utils.SuccessResponse(c, "Stats retrieved successfully", gin.H{
+		"connections":    len(connections),
+		"online_users":   len(users),
+		"uptime_seconds": int(time.Since(startedAt).Seconds()),
+		"slow_consumers": chat.SlowConsumerStats(),
+		"persistence":    chat.PersistenceStats(),
+		"message_cache":  chat.CacheStats(),
+	})
+}
@@ -0,0 +1,230 @@
+package admin
+
+import (
+	"backend/internal/audit"
+	"backend/internal/auth"
+	"backend/internal/chat"
+	"backend/internal/cluster"
+	"backend/internal/mail"
+	"backend/internal/models"
+	"backend/internal/persist"
+	"backend/internal/store"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// setup points every package at a fresh in-memory store, starts the chat
+// hub and returns the admin routes behind the real middleware.
+func setup(t *testing.T) (*store.Memory, *gin.Engine) {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+	mem := store.NewMemory()
+	auth.SetStore(mem)
+	audit.SetStore(mem)
+	SetStore(mem)
+	previousSender := mail.DefaultSender
+	mail.DefaultSender = &mail.LogSender{Path: t.TempDir() + "/mail.log"}
+
+	broker := cluster.NewLocal().Join("test")
+	messages := chat.NewMessageCache(mem, broker, 10)
+	writer, err := persist.New(messages, persist.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		writer.Close()
+		broker.Close()
+		mail.DefaultSender = previousSender
+	})
+	chat.Start(messages, writer, broker)
+
+	r := gin.New()
+	users := r.Group("/api/admin/users", auth.AuthMiddleware(), auth.RequireSession(), auth.RequirePermission(auth.PermManageUsers))
+	users.GET("", ListUsersHandler)
+	users.GET("/:id", GetUserHandler)
+	users.POST("/:id/disable", SetDisabledHandler(true))
+	users.POST("/:id/enable", SetDisabledHandler(false))
+	users.POST("/:id/force-password-reset", ForcePasswordResetHandler)
+	users.PUT("/:id/role", auth.RequirePermission(auth.PermManageRoles), UpdateRoleHandler)
+	return mem, r
+}
+
+func addUser(t *testing.T, mem *store.Memory, username, role string) (*models.User, string) {
+	t.Helper()
+	user := &models.User{Username: username, Email: username + "@example.com"}
+	if err := mem.CreateUser(user, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.SetRole(user.ID, role); err != nil {
+		t.Fatal(err)
+	}
+	user.Role = role
+	token, err := auth.GenerateToken(user.ID, user.Username, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, token
+}
+
+func call(r http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func userPath(user *models.User, action string) string {
+	return "/api/admin/users/" + strconv.Itoa(user.ID) + action
+}
+
+func TestListUsersHandler(t *testing.T) {
+	mem, r := setup(t)
+	_, token := addUser(t, mem, "root", auth.RoleAdmin)
+	addUser(t, mem, "alice", auth.RoleMember)
+	bob, _ := addUser(t, mem, "bob", auth.RoleModerator)
+	addUser(t, mem, "alicia", auth.RoleMember)
+	if err := mem.SetDisabled(bob.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+		total int
+	}{
+		{"q=ALI", []string{"alice", "alicia"}, 2},
+		{"role=moderator", []string{"bob"}, 1},
+		{"status=disabled", []string{"bob"}, 1},
+		{"status=active&limit=2&offset=1", []string{"alice", "alicia"}, 3},
+	}
+	for _, tt := range tests {
+		w := call(r, http.MethodGet, "/api/admin/users?"+tt.query, token, "")
+		var body struct {
+			Data struct {
+				Users []models.User `json:"users"`
+				Total int           `json:"total"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, %v", tt.query, w.Code, err)
+		}
+		var names []string
+		for _, u := range body.Data.Users {
+			names = append(names, u.Username)
+		}
+		if strings.Join(names, ",") != strings.Join(tt.want, ",") || body.Data.Total != tt.total {
+			t.Errorf("%s: got %v of %d, want %v of %d", tt.query, names, body.Data.Total, tt.want, tt.total)
+		}
+	}
+}
+
+func TestAdminUserActions(t *testing.T) {
+	mem, r := setup(t)
+	root, token := addUser(t, mem, "root", auth.RoleAdmin)
+	alice, aliceToken := addUser(t, mem, "alice", auth.RoleMember)
+
+	tests := []struct {
+		name, method, path, token, body string
+		want                            int
+	}{
+		{"not an admin", http.MethodGet, userPath(root, ""), aliceToken, "", http.StatusForbidden},
+		{"invalid id", http.MethodGet, "/api/admin/users/abc", token, "", http.StatusBadRequest},
+		{"unknown user", http.MethodGet, "/api/admin/users/999", token, "", http.StatusNotFound},
+		{"own account", http.MethodPost, userPath(root, "/disable"), token, "", http.StatusBadRequest},
+		{"unknown role", http.MethodPut, userPath(alice, "/role"), token, `{"role":"wizard"}`, http.StatusBadRequest},
+		{"get", http.MethodGet, userPath(alice, ""), token, "", http.StatusOK},
+		{"change role", http.MethodPut, userPath(alice, "/role"), token, `{"role":"moderator"}`, http.StatusOK},
+		{"disable", http.MethodPost, userPath(alice, "/disable"), token, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := call(r, tt.method, tt.path, tt.token, tt.body); w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+
+	updated, err := mem.GetUser(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Role != auth.RoleModerator || updated.DisabledAt == nil {
+		t.Errorf("alice is %q, disabled at %v", updated.Role, updated.DisabledAt)
+	}
+	if w := call(r, http.MethodPost, userPath(alice, "/enable"), token, ""); w.Code != http.StatusOK {
+		t.Fatalf("enable: status %d", w.Code)
+	}
+	if updated, _ := mem.GetUser(alice.ID); updated.DisabledAt != nil {
+		t.Error("alice is still disabled")
+	}
+
+	events, err := mem.QueryAuditEvents(store.AuditFilter{ActorID: root.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.Action)
+	}
+	want := []string{audit.ActionAdminUserUpdated, audit.ActionAdminUserUpdated, audit.ActionAdminRoleChanged}
+	if strings.Join(actions, ",") != strings.Join(want, ",") {
+		t.Errorf("audited %v, want %v", actions, want)
+	}
+}
+
+func TestForcePasswordReset(t *testing.T) {
+	mem, r := setup(t)
+	_, token := addUser(t, mem, "root", auth.RoleAdmin)
+	alice, _ := addUser(t, mem, "alice", auth.RoleMember)
+	// Session issue times are whole seconds, so backdate alice's.
+	session, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+		UserID: alice.ID, Username: alice.Username, Role: alice.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiToken, _, err := auth.CreateAPIToken(alice.ID, models.CreateAPITokenRequest{Name: "bot", Scopes: []string{auth.ScopeMessagesRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tok := range []string{session, apiToken} {
+		if _, _, err := auth.ResolveToken(tok); err != nil {
+			t.Fatalf("before the reset: %v", err)
+		}
+	}
+
+	if w := call(r, http.MethodPost, userPath(alice, "/force-password-reset"), token, ""); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	_, hash, err := mem.GetUserByLogin("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == "hash" || auth.CheckPasswordHash("", hash) {
+		t.Errorf("password hash is still usable: %q", hash)
+	}
+	for name, tok := range map[string]string{"session": session, "API token": apiToken} {
+		if _, _, err := auth.ResolveToken(tok); err == nil {
+			t.Errorf("alice's %s still works after the reset", name)
+		}
+	}
+	if events, _ := mem.QueryAuditEvents(store.AuditFilter{Action: audit.ActionAdminPasswordReset}); len(events) != 1 || events[0].Metadata["email_sent"] != true {
+		t.Errorf("audited %+v", events)
+	}
+}
@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"backend/internal/models"
+	"backend/internal/store"
+)
+
+type UserFilter = store.UserFilter
+
+// users is the account store. It is set once at startup by SetStore.
+var users store.UserStore
+
+func SetStore(s store.UserStore) {
+	users = s
+}
+
+// ListUsers returns one page of matching users and the total match count.
+func ListUsers(f UserFilter) ([]models.User, int, error) {
+	return users.ListUsers(f)
+}
+
+func GetUser(userID int) (*models.User, error) {
+	return users.GetUser(userID)
+}
+
+// SetDisabled disables or re-enables an account.
+func SetDisabled(userID int, disabled bool) error {
+	return users.SetDisabled(userID, disabled)
+}
+
+func SetRole(userID int, role string) error {
+	return users.SetRole(userID, role)
+}
+
+// InvalidatePassword replaces the password hash with a marker that never
+// verifies, so the user has to go through the reset flow.
+func InvalidatePassword(userID int) error {
+	return users.SetPasswordHash(userID, "!reset-required")
+}
+
+func isNotFound(err error) bool {
+	return err == store.ErrNotFound
+}
@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"backend/internal/models"
+	"backend/internal/store"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionUserRegistered     = "user.registered"
+	ActionEmailVerified      = "user.email_verified"
+	ActionPasswordReset      = "user.password_reset"
+	ActionLoginSucceeded     = "auth.login_succeeded"
+	ActionLoginFailed        = "auth.login_failed"
+	ActionAPITokenCreated    = "auth.api_token_created"
+	ActionAPITokenRevoked    = "auth.api_token_revoked"
+	ActionModeration         = "moderation." // followed by the moderation action
+	ActionAdminUserUpdated   = "admin.user_updated"
+	ActionAdminRoleChanged   = "admin.role_changed"
+	ActionAdminPasswordReset = "admin.password_reset_forced"
+)
+
+type Event = models.AuditEvent
+
+// events is where Record appends. It is set once at startup by SetStore.
+var events store.AuditStore
+
+func SetStore(s store.AuditStore) {
+	events = s
+}
+
+// Record appends an event. Failures are logged rather than returned so that
+// auditing never blocks the action being audited.
+func Record(e Event) {
+	if err := events.AppendAuditEvent(&e); err != nil {
+		log.Printf("Audit: failed to record %s: %v", e.Action, err)
+	}
+}
+
+// RecordRequest records an event performed by the authenticated caller of
+// the request, filling in the actor and IP address.
+func RecordRequest(c *gin.Context, e Event) {
+	if e.ActorID == 0 {
+		e.ActorID = c.GetInt("user_id")
+	}
+	if e.ActorUsername == "" {
+		e.ActorUsername = c.GetString("username")
+	}
+	if e.IP == "" {
+		e.IP = c.ClientIP()
+	}
+	Record(e)
+}
@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"backend/pkg/utils"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QueryHandler lists audit events. Filters: action (prefix if it ends with
+// "."), actor_id, target_id, ip, since, until (RFC 3339), before_id, limit.
+// format=csv or format=json downloads the result as a file.
+func QueryHandler(c *gin.Context) {
+	var f Filter
+	var err error
+
+	f.Action = c.Query("action")
+	f.TargetID = c.Query("target_id")
+	f.IP = c.Query("ip")
+	for key, dst := range map[string]*int{"actor_id": &f.ActorID, "before_id": &f.BeforeID, "limit": &f.Limit} {
+		if value := c.Query(key); value != "" {
+			if *dst, err = strconv.Atoi(value); err != nil {
+				utils.ErrorResponse(c, http.StatusBadRequest, "Invalid "+key, "invalid_filter")
+				return
+			}
+		}
+	}
+	for key, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if value := c.Query(key); value != "" {
+			if *dst, err = time.Parse(time.RFC3339, value); err != nil {
+				utils.ErrorResponse(c, http.StatusBadRequest, "Invalid "+key+", expected RFC 3339", "invalid_filter")
+				return
+			}
+		}
+	}
+
+	format := c.Query("format")
+	if f.Limit == 0 && format == "" {
+		f.Limit = 100
+	}
+
+	events, err := Query(f)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch audit events", err.Error())
+		return
+	}
+
+	filename := "audit-" + time.Now().UTC().Format("20060102-150405")
+	switch format {
+	case "csv":
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		writeCSV(c, events)
+	case "json":
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		c.JSON(http.StatusOK, events)
+	case "":
+		utils.SuccessResponse(c, "Audit events retrieved successfully", events)
+	default:
+		utils.ErrorResponse(c, http.StatusBadRequest, "Unsupported format", "invalid_format")
+	}
+}
+
+func writeCSV(c *gin.Context, events []Event) {
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "created_at", "action", "actor_id", "actor_username", "target_type", "target_id", "ip", "metadata"})
+	for _, e := range events {
+		metadata, _ := json.Marshal(e.Metadata)
+		actorID := ""
+		if e.ActorID != 0 {
+			actorID = strconv.Itoa(e.ActorID)
+		}
+		w.Write([]string{
+			strconv.Itoa(e.ID), e.CreatedAt.UTC().Format(time.RFC3339), e.Action, actorID,
+			e.ActorUsername, e.TargetType, e.TargetID, e.IP, string(metadata),
+		})
+	}
+	w.Flush()
+}
@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"backend/internal/store"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// useMemoryStore records events in a fresh in-memory store.
+func useMemoryStore(t *testing.T) *store.Memory {
+	t.Helper()
+	mem := store.NewMemory()
+	previous := events
+	SetStore(mem)
+	t.Cleanup(func() { SetStore(previous) })
+	return mem
+}
+
+func query(t *testing.T, params string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := gin.New()
+	r.GET("/audit", QueryHandler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/audit?"+params, nil))
+	return w
+}
+
+func TestRecordRequestFillsInTheCaller(t *testing.T) {
+	mem := useMemoryStore(t)
+	r := gin.New()
+	r.POST("/action", func(c *gin.Context) {
+		c.Set("user_id", 7)
+		c.Set("username", "alice")
+		RecordRequest(c, Event{Action: ActionAPITokenCreated, TargetType: "api_token", TargetID: "3"})
+	})
+	req := httptest.NewRequest(http.MethodPost, "/action", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	recorded, err := mem.QueryAuditEvents(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 1 || recorded[0].ActorID != 7 || recorded[0].ActorUsername != "alice" || recorded[0].IP != "192.0.2.1" {
+		t.Errorf("recorded %+v", recorded)
+	}
+}
+
+func TestQueryHandler(t *testing.T) {
+	useMemoryStore(t)
+	Record(Event{Action: ActionLoginFailed, TargetType: "user", TargetID: "bob", IP: "192.0.2.1"})
+	Record(Event{Action: ActionModeration + "kick", ActorID: 7, ActorUsername: "alice", TargetType: "user", TargetID: "2",
+		Metadata: map[string]interface{}{"reason": "said \"hi\", twice"}})
+
+	for _, params := range []string{"actor_id=seven", "limit=1.5", "since=yesterday", "format=xml"} {
+		if w := query(t, params); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", params, w.Code)
+		}
+	}
+
+	w := query(t, "action=moderation.")
+	var body struct {
+		Data []Event `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(body.Data) != 1 || body.Data[0].Action != "moderation.kick" {
+		t.Errorf("status %d, events %+v", w.Code, body.Data)
+	}
+
+	w = query(t, "format=csv")
+	if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment;") {
+		t.Errorf("Content-Disposition %q", w.Header().Get("Content-Disposition"))
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][2] != "action" {
+		t.Fatalf("csv %q", rows)
+	}
+	if kick := rows[1]; kick[2] != "moderation.kick" || kick[3] != "7" || kick[8] != `{"reason":"said \"hi\", twice"}` {
+		t.Errorf("kick row %q", kick)
+	}
+	if failed := rows[2]; failed[3] != "" || failed[7] != "192.0.2.1" {
+		t.Errorf("anonymous row %q", failed)
+	}
+
+	w = query(t, "format=json&ip=192.0.2.1")
+	var exported []Event
+	if err := json.Unmarshal(w.Body.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 1 || exported[0].Action != ActionLoginFailed {
+		t.Errorf("exported %+v", exported)
+	}
+}
@@ -0,0 +1,10 @@
+package audit
+
+import "backend/internal/store"
+
+type Filter = store.AuditFilter
+
+// Query returns matching events, newest first.
+func Query(f Filter) ([]Event, error) {
+	return events.QueryAuditEvents(f)
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"backend/internal/models"
+	"backend/internal/store"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// APITokenPrefix marks personal access tokens so AuthMiddleware can tell them
+// apart from JWTs without a database lookup.
+const APITokenPrefix = "inbx_"
+
+const (
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeProfileRead   = "profile:read"
+)
+
+var validScopes = map[string]bool{
+	ScopeMessagesRead:  true,
+	ScopeMessagesWrite: true,
+	ScopeProfileRead:   true,
+}
+
+var ErrInvalidScope = errors.New("invalid scope")
+
+// CreateAPIToken issues a personal access token. The plaintext is returned
+// once; only its hash is stored.
+func CreateAPIToken(userID int, req models.CreateAPITokenRequest) (string, *models.APIToken, error) {
+	for _, scope := range req.Scopes {
+		if !validScopes[scope] {
+			return "", nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	token := APITokenPrefix + hex.EncodeToString(buf)
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+
+	apiToken := &models.APIToken{
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    token[:len(APITokenPrefix)+8],
+		Scopes:    req.Scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := repo.CreateAPIToken(apiToken, hashToken(token)); err != nil {
+		return "", nil, err
+	}
+	return token, apiToken, nil
+}
+
+func ListAPITokens(userID int) ([]models.APIToken, error) {
+	return repo.ListAPITokens(userID)
+}
+
+// RevokeAPIToken revokes one of the user's tokens. It reports false if no
+// such active token exists.
+func RevokeAPIToken(userID, tokenID int) (bool, error) {
+	return repo.RevokeAPIToken(userID, tokenID)
+}
+
+// RevokeAPITokens revokes every active token of the user.
+func RevokeAPITokens(userID int) (int, error) {
+	return repo.RevokeAPITokens(userID)
+}
+
+// ValidateAPIToken looks up an active token and records its use. Returns the
+// token's owner along with the granted scopes.
+func ValidateAPIToken(token string) (*models.User, []string, error) {
+	apiToken, user, err := repo.UseAPIToken(hashToken(token))
+	if err != nil {
+		if err == store.ErrNotFound {
+			return nil, nil, ErrInvalidToken
+		}
+		return nil, nil, err
+	}
+	return user, apiToken.Scopes, nil
+}
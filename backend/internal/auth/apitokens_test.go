@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"backend/internal/models"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateAPIToken(t *testing.T) {
+	mem := useMemoryStore(t)
+	alice := createUser(t, mem, "alice", "alice@example.com", "Correct-Horse-9-Battery")
+
+	_, _, err := CreateAPIToken(alice.ID, models.CreateAPITokenRequest{Name: "bot", Scopes: []string{ScopeMessagesRead, "admin:all"}})
+	if !errors.Is(err, ErrInvalidScope) {
+		t.Errorf("unknown scope: got %v, want ErrInvalidScope", err)
+	}
+
+	token, apiToken, err := CreateAPIToken(alice.ID, models.CreateAPITokenRequest{Name: "bot", Scopes: []string{ScopeMessagesRead}, ExpiresInDays: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, APITokenPrefix) || !strings.HasPrefix(token, apiToken.Prefix) || len(apiToken.Prefix) >= len(token) {
+		t.Errorf("token %q with prefix %q", token, apiToken.Prefix)
+	}
+	if apiToken.ExpiresAt == nil || time.Until(*apiToken.ExpiresAt) < 29*24*time.Hour {
+		t.Errorf("expires at %v, want in 30 days", apiToken.ExpiresAt)
+	}
+
+	tokens, err := ListAPITokens(alice.ID)
+	if err != nil || len(tokens) != 1 || tokens[0].ID != apiToken.ID {
+		t.Fatalf("ListAPITokens = %+v, %v", tokens, err)
+	}
+	user, scopes, err := ValidateAPIToken(token)
+	if err != nil || user.ID != alice.ID || len(scopes) != 1 || scopes[0] != ScopeMessagesRead {
+		t.Errorf("ValidateAPIToken = %v, %v, %v", user, scopes, err)
+	}
+	if _, _, err := ValidateAPIToken(token + "0"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("altered token: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	mem := useMemoryStore(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	alice := createUser(t, mem, "alice", "alice@example.com", "Correct-Horse-9-Battery")
+	token, apiToken, err := CreateAPIToken(alice.ID, models.CreateAPITokenRequest{Name: "reader", Scopes: []string{ScopeMessagesRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := GenerateToken(alice.ID, alice.Username, alice.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, write, sessionOnly := protected(RequireScope(ScopeMessagesRead)), protected(RequireScope(ScopeMessagesWrite)), protected(RequireSession())
+	tests := []struct {
+		name   string
+		router http.Handler
+		token  string
+		want   int
+	}{
+		{"token with the scope", read, token, http.StatusOK},
+		{"token without the scope", write, token, http.StatusForbidden},
+		{"token on a session endpoint", sessionOnly, token, http.StatusForbidden},
+		{"session needing a scope", write, session, http.StatusOK},
+		{"session on a session endpoint", sessionOnly, session, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := get(tt.router, tt.token); w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+
+	if revoked, err := RevokeAPIToken(alice.ID+1, apiToken.ID); err != nil || revoked {
+		t.Errorf("another user revoked the token: %v, %v", revoked, err)
+	}
+	if revoked, err := RevokeAPIToken(alice.ID, apiToken.ID); err != nil || !revoked {
+		t.Fatalf("RevokeAPIToken = %v, %v", revoked, err)
+	}
+	if w := get(read, token); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token: status %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"backend/internal/models"
+	"backend/internal/store"
+	"errors"
+	"log"
+	"os"
+	"strings"
+)
+
+// Authenticator verifies a username and password. Implementations return
+// ErrInvalidCredentials when the credentials are wrong or the user is
+// unknown to them, so a ChainAuthenticator can fall through to the next one.
+type Authenticator interface {
+	Name() string
+	Authenticate(username, password string) (*models.User, error)
+}
+
+var authenticator Authenticator = LocalAuthenticator{}
+
+func SetAuthenticator(a Authenticator) {
+	authenticator = a
+}
+
+// SetupAuthenticators builds the authenticator chain from AUTH_BACKENDS, a
+// comma-separated list tried in order (e.g. "ldap,local"). Defaults to "local".
+func SetupAuthenticators() {
+	backends := os.Getenv("AUTH_BACKENDS")
+	if backends == "" {
+		backends = "local"
+	}
+
+	var chain ChainAuthenticator
+	for _, name := range strings.Split(backends, ",") {
+		switch strings.TrimSpace(strings.ToLower(name)) {
+		case "local":
+			chain = append(chain, LocalAuthenticator{})
+		case "ldap":
+			chain = append(chain, NewLDAPAuthenticatorFromEnv())
+		case "":
+		default:
+			log.Fatalf("Unknown authentication backend %q", name)
+		}
+	}
+	if len(chain) == 0 {
+		log.Fatal("AUTH_BACKENDS must list at least one backend")
+	}
+
+	if len(chain) == 1 {
+		authenticator = chain[0]
+	} else {
+		authenticator = chain
+	}
+	log.Printf("Authentication backends: %s", backends)
+}
+
+// LocalAuthenticator checks passwords stored in the users table.
+type LocalAuthenticator struct{}
+
+func (LocalAuthenticator) Name() string { return "local" }
+
+func (LocalAuthenticator) Authenticate(username, password string) (*models.User, error) {
+	// The login name may be either the username or the email address.
+	user, passwordHash, err := repo.GetUserByLogin(normalizeLoginName(username))
+	if err != nil {
+		if err == store.ErrNotFound {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if !CheckPasswordHash(password, passwordHash) {
+		return nil, ErrInvalidCredentials
+	}
+	rehashIfNeeded(user.ID, password, passwordHash)
+
+	return user, nil
+}
+
+// ChainAuthenticator tries each authenticator in order. A backend that is
+// unavailable is logged and skipped so the next one can act as a fallback.
+type ChainAuthenticator []Authenticator
+
+func (c ChainAuthenticator) Name() string {
+	names := make([]string, len(c))
+	for i, a := range c {
+		names[i] = a.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (c ChainAuthenticator) Authenticate(username, password string) (*models.User, error) {
+	var lastErr error = ErrInvalidCredentials
+	for _, a := range c {
+		user, err := a.Authenticate(username, password)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			log.Printf("Authenticator %s failed: %v", a.Name(), err)
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
@@ -1,10 +1,15 @@
 package auth
 
 import (
+	"backend/internal/audit"
 	"backend/internal/models"
+	"backend/internal/store"
 	"backend/pkg/utils"
+	"errors"
+	"log"
+	"math"
 	"net/http"
-	"strings"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -16,10 +21,19 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
+	if err := ValidatePassword(req.Password, req.Username, req.Email); err != nil {
+		writePolicyError(c, err)
+		return
+	}
+
 	// Check if user already exists
 	user, err := CreateUser(req)
 	if err != nil {
-		if strings.Contains(err.Error(), "duplicate key") {
+		if errors.Is(err, ErrInvalidUsername) {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid username", "invalid_username")
+			return
+		}
+		if err == store.ErrDuplicate {
 			utils.ErrorResponse(c, http.StatusConflict, "User already exists", "duplicate_user")
 			return
 		}
@@ -27,8 +41,21 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
+	audit.RecordRequest(c, audit.Event{
+		Action:        audit.ActionUserRegistered,
+		ActorID:       user.ID,
+		ActorUsername: user.Username,
+		TargetType:    "user",
+		TargetID:      strconv.Itoa(user.ID),
+		Metadata:      map[string]interface{}{"email": user.Email},
+	})
+
+	if err := SendVerificationEmail(user.ID, user.Email, user.Username); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
 	// Generate token
-	token, err := GenerateToken(user.ID, user.Username)
+	token, err := GenerateToken(user.ID, user.Username, user.Role)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token", err.Error())
 		return
@@ -49,13 +76,68 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
+	ip := c.ClientIP()
+	attempt, err := ReserveLoginAttempt(req.Username, ip)
+	if err != nil {
+		var throttled *ThrottledError
+		if errors.As(err, &throttled) {
+			retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+			code := "too_many_attempts"
+			if throttled.Locked {
+				code = "account_locked"
+			}
+			audit.RecordRequest(c, audit.Event{
+				Action:   audit.ActionLoginFailed,
+				Metadata: map[string]interface{}{"username": req.Username, "reason": code},
+			})
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			utils.ErrorResponseWithData(c, http.StatusTooManyRequests, throttled.Error(), code, gin.H{
+				"retry_after": retryAfter,
+			})
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Authentication failed", err.Error())
+		return
+	}
+
 	user, err := AuthenticateUser(req)
+	if finishErr := attempt.Finish(err); finishErr != nil {
+		log.Printf("Failed to record login attempt: %v", finishErr)
+	}
 	if err != nil {
+		reason := "invalid_credentials"
+		if !errors.Is(err, ErrInvalidCredentials) {
+			reason = err.Error()
+		}
+		audit.RecordRequest(c, audit.Event{
+			Action:   audit.ActionLoginFailed,
+			Metadata: map[string]interface{}{"username": req.Username, "reason": reason},
+		})
+
+		if errors.Is(err, ErrAccountDisabled) {
+			utils.ErrorResponse(c, http.StatusForbidden, "Account disabled", "account_disabled")
+			return
+		}
+		var banned *BannedError
+		if errors.As(err, &banned) {
+			utils.ErrorResponseWithData(c, http.StatusForbidden, banned.Error(), "account_banned", gin.H{
+				"reason":     banned.Sanction.Reason,
+				"expires_at": banned.Sanction.ExpiresAt,
+			})
+			return
+		}
 		utils.ErrorResponse(c, http.StatusUnauthorized, "Authentication failed", err.Error())
 		return
 	}
 
-	token, err := GenerateToken(user.ID, user.Username)
+	audit.RecordRequest(c, audit.Event{
+		Action:        audit.ActionLoginSucceeded,
+		ActorID:       user.ID,
+		ActorUsername: user.Username,
+		Metadata:      map[string]interface{}{"method": "password"},
+	})
+
+	token, err := GenerateToken(user.ID, user.Username, user.Role)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token", err.Error())
 		return
@@ -81,7 +163,174 @@ func ProfileHandler(c *gin.Context) {
 	userData := gin.H{
 		"user_id":  userID,
 		"username": username,
+		"role":     c.GetString("role"),
 	}
 
 	utils.SuccessResponse(c, "Profile retrieved successfully", userData)
 }
+
+func VerifyEmailHandler(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	userID, err := VerifyEmail(req.Token)
+	if err != nil {
+		if err == ErrInvalidToken {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid or expired token", "invalid_token")
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to verify email", err.Error())
+		return
+	}
+
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionEmailVerified,
+		ActorID:    userID,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(userID),
+	})
+	utils.SuccessResponse(c, "Email verified successfully", nil)
+}
+
+func ResendVerificationHandler(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	user, err := repo.GetUser(userID)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to load user", err.Error())
+		return
+	}
+	if user.EmailVerified {
+		utils.ErrorResponse(c, http.StatusConflict, "Email already verified", "already_verified")
+		return
+	}
+
+	if err := SendVerificationEmail(userID, user.Email, user.Username); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to send verification email", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Verification email sent", nil)
+}
+
+func ForgotPasswordHandler(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	if err := RequestPasswordReset(req.Email); err != nil {
+		log.Printf("Password reset request failed: %v", err)
+	}
+
+	utils.SuccessResponse(c, "If an account exists for that email, a reset link has been sent", nil)
+}
+
+func ResetPasswordHandler(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	userID, err := ResetPassword(req.Token, req.Password)
+	if err != nil {
+		if err == ErrInvalidToken {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid or expired token", "invalid_token")
+			return
+		}
+		var policyErr *PolicyError
+		if errors.As(err, &policyErr) {
+			writePolicyError(c, policyErr)
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to reset password", err.Error())
+		return
+	}
+
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionPasswordReset,
+		ActorID:    userID,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(userID),
+	})
+	utils.SuccessResponse(c, "Password reset successfully", nil)
+}
+
+func writePolicyError(c *gin.Context, err error) {
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid password", err.Error())
+		return
+	}
+	utils.ErrorResponseWithData(c, http.StatusBadRequest, "Password does not meet requirements", "weak_password", gin.H{
+		"violations": policyErr.Violations,
+	})
+}
+
+func CreateAPITokenHandler(c *gin.Context) {
+	var req models.CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	token, apiToken, err := CreateAPIToken(c.GetInt("user_id"), req)
+	if err != nil {
+		if errors.Is(err, ErrInvalidScope) {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error(), "invalid_scope")
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create token", err.Error())
+		return
+	}
+
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionAPITokenCreated,
+		TargetType: "api_token",
+		TargetID:   strconv.Itoa(apiToken.ID),
+		Metadata:   map[string]interface{}{"name": apiToken.Name, "scopes": apiToken.Scopes},
+	})
+
+	utils.CreatedResponse(c, "Token created. Copy it now, it won't be shown again", models.CreateAPITokenResponse{
+		Token:    token,
+		APIToken: *apiToken,
+	})
+}
+
+func ListAPITokensHandler(c *gin.Context) {
+	tokens, err := ListAPITokens(c.GetInt("user_id"))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch tokens", err.Error())
+		return
+	}
+	utils.SuccessResponse(c, "Tokens retrieved successfully", tokens)
+}
+
+func RevokeAPITokenHandler(c *gin.Context) {
+	tokenID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid token id", "invalid_id")
+		return
+	}
+
+	revoked, err := RevokeAPIToken(c.GetInt("user_id"), tokenID)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke token", err.Error())
+		return
+	}
+	if !revoked {
+		utils.ErrorResponse(c, http.StatusNotFound, "Token not found", "not_found")
+		return
+	}
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionAPITokenRevoked,
+		TargetType: "api_token",
+		TargetID:   strconv.Itoa(tokenID),
+	})
+	utils.SuccessResponse(c, "Token revoked successfully", nil)
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"backend/internal/audit"
+	"backend/internal/models"
+	"backend/internal/store"
+	"testing"
+)
+
+// useMemoryStore points the package, and auditing, at a fresh in-memory
+// store.
+func useMemoryStore(t *testing.T) *store.Memory {
+	t.Helper()
+	mem := store.NewMemory()
+	previous := repo
+	SetStore(mem)
+	audit.SetStore(mem)
+	t.Cleanup(func() { SetStore(previous) })
+	return mem
+}
+
+// createUser adds a member with the given password to mem. Like a newly
+// registered user, their email is not verified.
+func createUser(t *testing.T, mem *store.Memory, username, email, password string) *models.User {
+	t.Helper()
+	hash, err := HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: username, Email: email}
+	if err := mem.CreateUser(user, hash); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
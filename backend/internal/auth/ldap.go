@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"backend/internal/models"
+	"backend/internal/store"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+type GroupRoleMapping struct {
+	GroupDN string
+	Role    string
+}
+
+// LDAPAuthenticator authenticates by binding as the user's DN, which it finds
+// with a search under BaseDN using the service account. Users are
+// provisioned locally on first login and their role is refreshed from their
+// group memberships on every login.
+type LDAPAuthenticator struct {
+	URL          string
+	StartTLS     bool
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	UserFilter   string // e.g. "(uid=%s)"; %s is the escaped login name
+	EmailAttr    string
+	GroupBaseDN  string // optional; searched for (member=<user DN>) groups
+	GroupRoles   []GroupRoleMapping
+	DefaultRole  string
+}
+
+// NewLDAPAuthenticatorFromEnv reads LDAP_URL, LDAP_START_TLS, LDAP_BIND_DN,
+// LDAP_BIND_PASSWORD, LDAP_BASE_DN, LDAP_USER_FILTER, LDAP_EMAIL_ATTRIBUTE,
+// LDAP_GROUP_BASE_DN, LDAP_GROUP_ROLE_MAP ("<group DN>=<role>;..." with the
+// first matching group winning) and LDAP_DEFAULT_ROLE.
+func NewLDAPAuthenticatorFromEnv() *LDAPAuthenticator {
+	a := &LDAPAuthenticator{
+		URL:          os.Getenv("LDAP_URL"),
+		StartTLS:     os.Getenv("LDAP_START_TLS") == "true",
+		BindDN:       os.Getenv("LDAP_BIND_DN"),
+		BindPassword: os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:       os.Getenv("LDAP_BASE_DN"),
+		UserFilter:   os.Getenv("LDAP_USER_FILTER"),
+		EmailAttr:    os.Getenv("LDAP_EMAIL_ATTRIBUTE"),
+		GroupBaseDN:  os.Getenv("LDAP_GROUP_BASE_DN"),
+		DefaultRole:  os.Getenv("LDAP_DEFAULT_ROLE"),
+	}
+	if a.URL == "" || a.BaseDN == "" {
+		log.Fatal("LDAP authentication requires LDAP_URL and LDAP_BASE_DN")
+	}
+	if a.UserFilter == "" {
+		a.UserFilter = "(uid=%s)"
+	}
+	if a.EmailAttr == "" {
+		a.EmailAttr = "mail"
+	}
+	if a.DefaultRole == "" {
+		a.DefaultRole = RoleMember
+	}
+	if !ValidRole(a.DefaultRole) {
+		log.Fatalf("Invalid LDAP_DEFAULT_ROLE %q", a.DefaultRole)
+	}
+
+	for _, entry := range strings.Split(os.Getenv("LDAP_GROUP_ROLE_MAP"), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		// Group DNs contain '=', so split on the last one.
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			log.Fatalf("Invalid LDAP_GROUP_ROLE_MAP entry %q", entry)
+		}
+		mapping := GroupRoleMapping{
+			GroupDN: strings.TrimSpace(entry[:i]),
+			Role:    strings.TrimSpace(entry[i+1:]),
+		}
+		if !ValidRole(mapping.Role) {
+			log.Fatalf("Invalid role %q in LDAP_GROUP_ROLE_MAP", mapping.Role)
+		}
+		a.GroupRoles = append(a.GroupRoles, mapping)
+	}
+	return a
+}
+
+func (a *LDAPAuthenticator) Name() string { return "ldap" }
+
+func (a *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.URL)
+	if err != nil {
+		return nil, err
+	}
+	if a.StartTLS {
+		host := strings.TrimPrefix(strings.TrimPrefix(a.URL, "ldap://"), "ldaps://")
+		host, _, _ = strings.Cut(host, ":")
+		if err := conn.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (a *LDAPAuthenticator) Authenticate(username, password string) (*models.User, error) {
+	// An empty password would be an unauthenticated bind, which most servers
+	// accept as anonymous.
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, fmt.Errorf("ldap connect: %w", err)
+	}
+	defer conn.Close()
+
+	if a.BindDN != "" {
+		if err := conn.Bind(a.BindDN, a.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+
+	search := ldap.NewSearchRequest(
+		a.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 10, false,
+		fmt.Sprintf(a.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn", a.EmailAttr, "memberOf"},
+		nil,
+	)
+	result, err := conn.Search(search)
+	if err != nil {
+		return nil, fmt.Errorf("ldap user search: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap user bind: %w", err)
+	}
+
+	groups := entry.GetAttributeValues("memberOf")
+	if a.GroupBaseDN != "" {
+		// Search with the service account again; the user may not be allowed to.
+		if a.BindDN != "" {
+			if err := conn.Bind(a.BindDN, a.BindPassword); err != nil {
+				return nil, fmt.Errorf("ldap service bind: %w", err)
+			}
+		}
+		groupSearch := ldap.NewSearchRequest(
+			a.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 10, false,
+			fmt.Sprintf("(|(member=%[1]s)(uniqueMember=%[1]s))", ldap.EscapeFilter(entry.DN)),
+			[]string{"dn"},
+			nil,
+		)
+		groupResult, err := conn.Search(groupSearch)
+		if err != nil {
+			return nil, fmt.Errorf("ldap group search: %w", err)
+		}
+		for _, g := range groupResult.Entries {
+			groups = append(groups, g.DN)
+		}
+	}
+
+	email := NormalizeEmail(entry.GetAttributeValue(a.EmailAttr))
+	return a.provision(username, email, entry.DN, a.roleFor(groups))
+}
+
+func (a *LDAPAuthenticator) roleFor(groups []string) string {
+	for _, mapping := range a.GroupRoles {
+		for _, group := range groups {
+			if strings.EqualFold(group, mapping.GroupDN) {
+				return mapping.Role
+			}
+		}
+	}
+	return a.DefaultRole
+}
+
+// provision finds or creates the local user for a directory entry, keyed by
+// the entry DN in user_identities. New users keep the name they logged in
+// with if it could have been registered, and get one derived from it
+// otherwise.
+func (a *LDAPAuthenticator) provision(username, email, dn, role string) (*models.User, error) {
+	issuer := "ldap:" + a.URL
+	user, err := repo.GetUserByIdentity(issuer, dn)
+	if err != nil && err != store.ErrNotFound {
+		return nil, err
+	}
+
+	if user == nil {
+		if email == "" {
+			return nil, errors.New("ldap entry has no email address")
+		}
+		name, err := NormalizeUsername(username)
+		if err != nil {
+			name = safeUsername(username, email)
+		}
+		user = &models.User{Username: name, Email: email, EmailVerified: true, Role: role}
+		err = repo.CreateUser(user, "!ldap")
+		if err == store.ErrDuplicate {
+			// A local account already has this username or email. Adopt it
+			// only if it proved it owns the directory's email address;
+			// otherwise whoever registered the name first would inherit
+			// the directory user's access.
+			user, err = repo.GetUserByEmail(email)
+			if err == store.ErrNotFound || err == nil && !user.EmailVerified {
+				err = errors.New("a local account already uses this username or email")
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := repo.LinkIdentity(user.ID, issuer, dn); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := repo.SetRole(user.ID, role); err != nil {
+		return nil, err
+	}
+	return repo.GetUser(user.ID)
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"backend/internal/store"
+	"testing"
+)
+
+func testLDAPAuthenticator() *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		URL: "ldap://ldap.example.com",
+		GroupRoles: []GroupRoleMapping{
+			{GroupDN: "cn=chat-admins,ou=groups,dc=example,dc=com", Role: RoleAdmin},
+			{GroupDN: "cn=chat-mods,ou=groups,dc=example,dc=com", Role: RoleModerator},
+		},
+		DefaultRole: RoleMember,
+	}
+}
+
+func TestLDAPRoleFor(t *testing.T) {
+	a := testLDAPAuthenticator()
+	tests := []struct {
+		groups []string
+		want   string
+	}{
+		{nil, RoleMember},
+		{[]string{"cn=other,ou=groups,dc=example,dc=com"}, RoleMember},
+		{[]string{"CN=Chat-Mods,OU=Groups,DC=example,DC=com"}, RoleModerator},
+		{[]string{"cn=chat-mods,ou=groups,dc=example,dc=com", "cn=chat-admins,ou=groups,dc=example,dc=com"}, RoleAdmin},
+	}
+	for _, tt := range tests {
+		if got := a.roleFor(tt.groups); got != tt.want {
+			t.Errorf("roleFor(%v) = %q, want %q", tt.groups, got, tt.want)
+		}
+	}
+}
+
+func TestLDAPProvision(t *testing.T) {
+	mem := useMemoryStore(t)
+	a := testLDAPAuthenticator()
+	const dn = "uid=alice,ou=people,dc=example,dc=com"
+
+	user, err := a.provision("alice", "alice@example.com", dn, RoleModerator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "alice" || user.Role != RoleModerator || !user.EmailVerified {
+		t.Errorf("provisioned %+v", user)
+	}
+
+	// Later logins find the user by DN and refresh the role.
+	again, err := a.provision("alice", "alice@example.com", dn, RoleMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID || again.Role != RoleMember {
+		t.Errorf("second login: %+v", again)
+	}
+
+	if _, err := a.provision("bob", "", "uid=bob,ou=people,dc=example,dc=com", RoleMember); err == nil {
+		t.Error("provisioned an entry without an email")
+	}
+	if _, err := mem.GetUserByIdentity("ldap:"+a.URL, dn); err != nil {
+		t.Errorf("identity not linked: %v", err)
+	}
+}
+
+func TestLDAPProvisionExistingAccounts(t *testing.T) {
+	mem := useMemoryStore(t)
+	a := testLDAPAuthenticator()
+	issuer := "ldap:" + a.URL
+
+	// Someone registered the directory user's name with their own address.
+	squatter := createUser(t, mem, "carol", "mallory@example.com", "Correct-Horse-9-Battery")
+	if err := mem.SetEmailVerified(squatter.ID); err != nil {
+		t.Fatal(err)
+	}
+	const carolDN = "uid=carol,ou=people,dc=example,dc=com"
+	if _, err := a.provision("carol", "carol@example.com", carolDN, RoleAdmin); err == nil {
+		t.Error("adopted an account with another email")
+	}
+
+	// Or with the directory user's address, without ever confirming it.
+	createUser(t, mem, "dave", "dave@example.com", "Correct-Horse-9-Battery")
+	const daveDN = "uid=dave,ou=people,dc=example,dc=com"
+	if _, err := a.provision("dave", "dave@example.com", daveDN, RoleAdmin); err == nil {
+		t.Error("adopted an account with an unverified email")
+	}
+
+	for _, dn := range []string{carolDN, daveDN} {
+		if _, err := mem.GetUserByIdentity(issuer, dn); err != store.ErrNotFound {
+			t.Errorf("%s: got %v, want it unlinked", dn, err)
+		}
+	}
+	if squatter, err := mem.GetUser(squatter.ID); err != nil || squatter.Role != RoleMember {
+		t.Errorf("squatter: %+v, %v", squatter, err)
+	}
+
+	// An account that verified the same address is the same person.
+	erin := createUser(t, mem, "erin", "erin@example.com", "Correct-Horse-9-Battery")
+	if err := mem.SetEmailVerified(erin.ID); err != nil {
+		t.Fatal(err)
+	}
+	user, err := a.provision("erin", "erin@example.com", "uid=erin,ou=people,dc=example,dc=com", RoleModerator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != erin.ID || user.Role != RoleModerator {
+		t.Errorf("adopted %+v, want user %d as moderator", user, erin.ID)
+	}
+}
+
+func TestLDAPProvisionDerivesSafeUsernames(t *testing.T) {
+	useMemoryStore(t)
+	a := testLDAPAuthenticator()
+
+	tests := []struct {
+		login, email, want string
+	}{
+		{"  frank ", "frank@example.com", "frank"},
+		{"grace@example.com", "grace@example.com", "grace"},
+		{"h@", "hal@example.com", "userh"},
+		{"", "ivan@example.com", "ivan"},
+	}
+	for _, tt := range tests {
+		user, err := a.provision(tt.login, tt.email, "uid="+tt.want+",ou=people,dc=example,dc=com", RoleMember)
+		if err != nil {
+			t.Errorf("%q: %v", tt.login, err)
+			continue
+		}
+		if user.Username != tt.want {
+			t.Errorf("%q: provisioned username %q, want %q", tt.login, user.Username, tt.want)
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package auth
 
 import (
+	"backend/internal/models"
+	"backend/internal/store"
 	"backend/pkg/utils"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -26,45 +30,151 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		claims, err := ValidateToken(tokenString)
-		if err != nil {
-			utils.ErrorResponse(c, http.StatusUnauthorized, "Invalid token", err.Error())
+		if !authenticate(c, tokenString) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// ResolveToken validates a JWT or personal access token and returns its
+// owner as currently stored, so a changed role or username applies to
+// tokens issued before the change and tokens of disabled users stop
+// working. Sessions issued before the password was last set are rejected.
+// scopes is nil for JWT sessions, which have every scope.
+func ResolveToken(tokenString string) (*models.User, []string, error) {
+	if strings.HasPrefix(tokenString, APITokenPrefix) {
+		return ValidateAPIToken(tokenString)
+	}
+
+	claims, err := ValidateToken(tokenString)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := repo.GetUser(claims.UserID)
+	if err == store.ErrNotFound {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.DisabledAt != nil {
+		return nil, nil, ErrAccountDisabled
+	}
+	if user.PasswordChangedAt != nil && issuedBefore(claims, *user.PasswordChangedAt) {
+		return nil, nil, ErrInvalidToken
+	}
+	return user, nil, nil
+}
+
+// issuedBefore reports whether a session was issued before t. Issue times
+// are whole seconds, so sessions from the same second as t still count as
+// issued after it; a login right after a reset must keep working.
+func issuedBefore(claims *Claims, t time.Time) bool {
+	return claims.IssuedAt == nil || claims.IssuedAt.Time.Before(t.Truncate(time.Second))
+}
+
+// authenticate resolves a JWT or personal access token and stores the
+// caller in the context. Personal access tokens also record their scopes;
+// JWT sessions have every scope. Banned callers are turned away. On failure
+// the request is aborted.
+func authenticate(c *gin.Context, tokenString string) bool {
+	user, scopes, err := ResolveToken(tokenString)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "Invalid token", err.Error())
+		c.Abort()
+		return false
+	}
+	if err := CheckBanned(user.ID); err != nil {
+		abortInactive(c, err)
+		return false
+	}
+
+	// Set user info in context
+	c.Set("user_id", user.ID)
+	c.Set("username", user.Username)
+	c.Set("role", user.Role)
+	if scopes != nil {
+		c.Set("token_scopes", scopes)
+	}
+	return true
+}
+
+// abortInactive aborts the request of a caller whose account may not be
+// used, or who could not be checked.
+func abortInactive(c *gin.Context, err error) {
+	var banned *BannedError
+	if errors.As(err, &banned) {
+		utils.ErrorResponseWithData(c, http.StatusForbidden, banned.Error(), "account_banned", gin.H{
+			"reason":     banned.Sanction.Reason,
+			"expires_at": banned.Sanction.ExpiresAt,
+		})
+	} else {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to check account status", err.Error())
+	}
+	c.Abort()
+}
+
+// RequireScope rejects personal access tokens that were not granted scope.
+// Must run after AuthMiddleware.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasScope(c, scope) {
+			utils.ErrorResponse(c, http.StatusForbidden, "Token lacks required scope "+scope, "insufficient_scope")
 			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
 
-		// Set user info in context
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
+// RequireSession rejects personal access tokens, for endpoints that must only
+// be used from an interactive login (such as managing tokens).
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, isToken := c.Get("token_scopes"); isToken {
+			utils.ErrorResponse(c, http.StatusForbidden, "Not available to API tokens", "session_required")
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
 
-// WebSocketAuthMiddleware authenticates WebSocket connections that pass token as a query parameter
+func HasScope(c *gin.Context, scope string) bool {
+	scopes, isToken := c.Get("token_scopes")
+	if !isToken {
+		return true
+	}
+	for _, s := range scopes.([]string) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// WebSocketAuthMiddleware authenticates WebSocket and event stream connections that pass token as a query parameter
 func WebSocketAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// For WebSocket, token is passed as a query parameter
 		tokenParam := c.Query("token")
-		log.Printf("WebSocket connection attempt with token: %s...", tokenParam[:10])
-		
 		if tokenParam == "" {
 			utils.ErrorResponse(c, http.StatusUnauthorized, "Token query parameter required", "missing_token")
 			c.Abort()
 			return
 		}
 
-		claims, err := ValidateToken(tokenParam)
-		if err != nil {
-			utils.ErrorResponse(c, http.StatusUnauthorized, "Invalid token", err.Error())
+		if !authenticate(c, tokenParam) {
+			return
+		}
+		if !HasScope(c, ScopeMessagesRead) {
+			utils.ErrorResponse(c, http.StatusForbidden, "Token lacks required scope "+ScopeMessagesRead, "insufficient_scope")
 			c.Abort()
 			return
 		}
 
-		log.Printf("WebSocket auth successful for user: %s (ID: %d)", claims.Username, claims.UserID)
-		
-		// Set user info in context
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
+		log.Printf("WebSocket auth successful for user: %s (ID: %d)", c.GetString("username"), c.GetInt("user_id"))
 		c.Next()
 	}
 }
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"backend/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// protected returns a router serving GET /protected behind AuthMiddleware
+// and the given middleware.
+func protected(middleware ...gin.HandlerFunc) *gin.Engine {
+	r := gin.New()
+	handlers := append([]gin.HandlerFunc{AuthMiddleware()}, middleware...)
+	handlers = append(handlers, func(c *gin.Context) {
+		c.String(http.StatusOK, "%s:%s", c.GetString("username"), c.GetString("role"))
+	})
+	r.GET("/protected", handlers...)
+	return r
+}
+
+func get(r http.Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthMiddlewareRejectsBadTokens(t *testing.T) {
+	useMemoryStore(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	r := protected()
+
+	// A well-signed token for a user that does not exist.
+	ghost, err := GenerateToken(42, "ghost", RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"missing": "", "garbage": "not-a-jwt", "unknown user": ghost, "unknown API token": APITokenPrefix + "nope"} {
+		if w := get(r, token); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", name, w.Code)
+		}
+	}
+}
+
+func TestAuthMiddlewareUsesCurrentRole(t *testing.T) {
+	mem := useMemoryStore(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	alice := createUser(t, mem, "alice", "alice@example.com", "Correct-Horse-9-Battery")
+	token, err := GenerateToken(alice.ID, alice.Username, RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := protected(RequirePermission(PermKickUsers))
+
+	// The token claims admin, but the store says member.
+	if w := get(r, token); w.Code != http.StatusForbidden {
+		t.Fatalf("member: status %d, want 403", w.Code)
+	}
+
+	if err := mem.SetRole(alice.ID, RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	w := get(r, token)
+	if w.Code != http.StatusOK || w.Body.String() != "alice:moderator" {
+		t.Fatalf("promoted: status %d, body %q", w.Code, w.Body)
+	}
+
+	if err := mem.SetRole(alice.ID, RoleGuest); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(r, token); w.Code != http.StatusForbidden {
+		t.Errorf("demoted: status %d, want 403", w.Code)
+	}
+}
+
+func TestAuthMiddlewareRejectsBannedUsers(t *testing.T) {
+	mem := useMemoryStore(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	alice := createUser(t, mem, "alice", "alice@example.com", "Correct-Horse-9-Battery")
+	session, err := GenerateToken(alice.ID, alice.Username, alice.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiToken, _, err := CreateAPIToken(alice.ID, models.CreateAPITokenRequest{Name: "bot", Scopes: []string{ScopeMessagesWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := protected()
+
+	if _, err := RecordSanction(alice.ID, SanctionBan, "spam", 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"session": session, "API token": apiToken} {
+		w := get(r, token)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "account_banned") {
+			t.Errorf("%s while banned: status %d, body %s", name, w.Code, w.Body)
+		}
+	}
+
+	if _, err := LiftSanctions(alice.ID, SanctionBan); err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"session": session, "API token": apiToken} {
+		if w := get(r, token); w.Code != http.StatusOK {
+			t.Errorf("%s after unban: status %d, body %s", name, w.Code, w.Body)
+		}
+	}
+}
+
+func TestAuthMiddlewareRejectsDisabledUsers(t *testing.T) {
+	mem := useMemoryStore(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	alice := createUser(t, mem, "alice", "alice@example.com", "Correct-Horse-9-Battery")
+	session, err := GenerateToken(alice.ID, alice.Username, alice.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiToken, _, err := CreateAPIToken(alice.ID, models.CreateAPITokenRequest{Name: "bot", Scopes: []string{ScopeMessagesRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := protected()
+
+	if err := mem.SetDisabled(alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"session": session, "API token": apiToken} {
+		if w := get(r, token); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s while disabled: status %d, want 401", name, w.Code)
+		}
+	}
+
+	if err := mem.SetDisabled(alice.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"session": session, "API token": apiToken} {
+		if w := get(r, token); w.Code != http.StatusOK {
+			t.Errorf("%s after enabling: status %d, body %s", name, w.Code, w.Body)
+		}
+	}
+}
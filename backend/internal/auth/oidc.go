@@ -0,0 +1,363 @@
+package auth
+
+import (
+	"backend/internal/audit"
+	"backend/internal/models"
+	"backend/internal/store"
+	"backend/pkg/utils"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateCookie   = "oidc_state"
+	oidcStateTTL      = 10 * time.Minute
+	oidcStateAudience = "inboxly-oidc-state"
+)
+
+// OIDCProvider runs the authorization code flow with PKCE against a single
+// OpenID Connect identity provider discovered from its issuer URL.
+type OIDCProvider struct {
+	issuer   string
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+var oidcProvider *OIDCProvider
+
+// oidcState is kept in a signed, short-lived cookie between the redirect to
+// the provider and the callback, so any server instance can finish the flow.
+type oidcState struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	jwt.RegisteredClaims
+}
+
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	Nonce             string `json:"nonce"`
+}
+
+// SetupOIDC discovers the provider configured by OIDC_ISSUER_URL,
+// OIDC_CLIENT_ID, OIDC_CLIENT_SECRET and OIDC_REDIRECT_URL. SSO stays
+// disabled when OIDC_ISSUER_URL is empty.
+func SetupOIDC() {
+	issuer := os.Getenv("OIDC_ISSUER_URL")
+	if issuer == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		log.Fatal("Failed to discover OIDC provider:", err)
+	}
+
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	scopes := []string{oidc.ScopeOpenID, "email", "profile"}
+	if extra := os.Getenv("OIDC_SCOPES"); extra != "" {
+		scopes = append([]string{oidc.ScopeOpenID}, strings.Fields(extra)...)
+	}
+
+	oidcProvider = &OIDCProvider{
+		issuer: issuer,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}
+	log.Printf("OIDC single sign-on enabled for issuer %s", issuer)
+}
+
+func OIDCEnabled() bool {
+	return oidcProvider != nil
+}
+
+// oidcStateKey signs the state cookie. It is derived from JWT_SECRET but
+// differs from the session key, so a state cookie never passes as a session
+// token and a session token never passes as state.
+func oidcStateKey() []byte {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte(oidcStateAudience))
+	return mac.Sum(nil)
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// AuthURL starts a login and returns the provider URL along with the signed
+// state to store in the browser.
+func (p *OIDCProvider) AuthURL() (string, string, error) {
+	state, err := randomString(16)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString(16)
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	claims := &oidcState{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{oidcStateAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcStateTTL)),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(oidcStateKey())
+	if err != nil {
+		return "", "", err
+	}
+
+	url := p.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	return url, signed, nil
+}
+
+// Exchange completes the flow and returns the Inboxly user the identity
+// belongs to, linking or creating one as needed.
+func (p *OIDCProvider) Exchange(ctx context.Context, signedState, state, code string) (*models.User, error) {
+	saved := &oidcState{}
+	_, err := jwt.ParseWithClaims(signedState, saved, func(token *jwt.Token) (interface{}, error) {
+		return oidcStateKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(oidcStateAudience))
+	if err != nil {
+		return nil, fmt.Errorf("invalid login state: %w", err)
+	}
+	if saved.State != state {
+		return nil, errors.New("state mismatch")
+	}
+
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(saved.CodeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("code exchange failed: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	if claims.Nonce != saved.Nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+
+	return p.resolveUser(claims)
+}
+
+func (p *OIDCProvider) resolveUser(claims oidcClaims) (*models.User, error) {
+	user, err := repo.GetUserByIdentity(p.issuer, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if err != store.ErrNotFound {
+		return nil, err
+	}
+
+	// An identity is only linked to an existing account when both sides
+	// have verified the email; otherwise whoever registers an address first,
+	// here or at the provider, could take over the other account.
+	email := NormalizeEmail(claims.Email)
+	if email != "" {
+		user, err = repo.GetUserByEmail(email)
+		switch {
+		case err == nil && !claims.EmailVerified:
+			return nil, errors.New("identity provider has not verified this email; cannot link to existing account")
+		case err == nil && !user.EmailVerified:
+			return nil, errors.New("existing account has not verified this email; cannot link to it")
+		case err == nil:
+			return user, repo.LinkIdentity(user.ID, p.issuer, claims.Subject)
+		case err != store.ErrNotFound:
+			return nil, err
+		}
+	}
+
+	if email == "" {
+		return nil, errors.New("identity provider did not return an email address")
+	}
+	user, err = createSSOUser(claims, email)
+	if err != nil {
+		return nil, err
+	}
+	return user, repo.LinkIdentity(user.ID, p.issuer, claims.Subject)
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// safeUsername derives a username that NormalizeUsername accepts from name,
+// or from the local part of email when name is empty. Anything from an "@"
+// on is dropped, as are characters outside [a-zA-Z0-9_.-].
+func safeUsername(name, email string) string {
+	if name == "" {
+		name = email
+	}
+	name, _, _ = strings.Cut(name, "@")
+	name = usernameInvalidChars.ReplaceAllString(name, "")
+	if len(name) < 3 {
+		name = "user" + name
+	}
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return name
+}
+
+// createSSOUser provisions a user on first login. The account has no usable
+// password; the stored marker never matches a hash format.
+func createSSOUser(claims oidcClaims, email string) (*models.User, error) {
+	base := safeUsername(claims.PreferredUsername, email)
+
+	for i := 0; i < 20; i++ {
+		username := base
+		if i > 0 {
+			username = fmt.Sprintf("%s%d", base, i+1)
+		}
+
+		user := &models.User{Username: username, Email: email, EmailVerified: claims.EmailVerified}
+		err := repo.CreateUser(user, "!sso")
+		if err == store.ErrDuplicate {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	return nil, errors.New("could not allocate a unique username")
+}
+
+func OIDCLoginHandler(c *gin.Context) {
+	if oidcProvider == nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Single sign-on is not configured", "sso_disabled")
+		return
+	}
+
+	url, state, err := oidcProvider.AuthURL()
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to start login", err.Error())
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidcStateTTL.Seconds()), "/api/auth/oidc", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, url)
+}
+
+// oidcProviderErrors are the error codes from RFC 6749 and OpenID Connect
+// Core that are passed on to the frontend. Anything else the provider sends
+// becomes sso_failed.
+var oidcProviderErrors = map[string]bool{
+	"access_denied":              true,
+	"consent_required":           true,
+	"interaction_required":       true,
+	"login_required":             true,
+	"temporarily_unavailable":    true,
+	"account_selection_required": true,
+}
+
+// OIDCCallbackHandler finishes the login and hands the Inboxly JWT to the
+// frontend in the URL fragment so it never reaches server logs. Failures
+// redirect with a fixed error code; the details are only logged.
+func OIDCCallbackHandler(c *gin.Context) {
+	if oidcProvider == nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "Single sign-on is not configured", "sso_disabled")
+		return
+	}
+
+	frontend := strings.TrimRight(os.Getenv("FRONTEND_URL"), "/") + "/oauth/callback"
+	fail := func(code string) {
+		c.Redirect(http.StatusFound, frontend+"#error="+code)
+	}
+	if errCode := c.Query("error"); errCode != "" {
+		log.Printf("OIDC provider returned error %q: %s", errCode, c.Query("error_description"))
+		if !oidcProviderErrors[errCode] {
+			errCode = "sso_failed"
+		}
+		fail(errCode)
+		return
+	}
+
+	signedState, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		fail("invalid_state")
+		return
+	}
+	c.SetCookie(oidcStateCookie, "", -1, "/api/auth/oidc", "", c.Request.TLS != nil, true)
+
+	user, err := oidcProvider.Exchange(c.Request.Context(), signedState, c.Query("state"), c.Query("code"))
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		audit.RecordRequest(c, audit.Event{
+			Action:   audit.ActionLoginFailed,
+			Metadata: map[string]interface{}{"method": "oidc", "reason": err.Error()},
+		})
+		fail("sso_failed")
+		return
+	}
+
+	if err := CheckAccountActive(user.ID); err != nil {
+		code := "account_banned"
+		if errors.Is(err, ErrAccountDisabled) {
+			code = "account_disabled"
+		}
+		fail(code)
+		return
+	}
+
+	audit.RecordRequest(c, audit.Event{
+		Action:        audit.ActionLoginSucceeded,
+		ActorID:       user.ID,
+		ActorUsername: user.Username,
+		Metadata:      map[string]interface{}{"method": "oidc", "issuer": oidcProvider.issuer},
+	})
+
+	token, err := GenerateToken(user.ID, user.Username, user.Role)
+	if err != nil {
+		log.Printf("OIDC login: failed to generate token for user %d: %v", user.ID, err)
+		fail("server_error")
+		return
+	}
+
+	c.Redirect(http.StatusFound, frontend+"#token="+token)
+}
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"backend/internal/models"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is a minimal OpenID Connect provider: discovery, keys and a
+// token endpoint that returns an ID token with the configured claims.
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims jwt.MapClaims
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code_verifier") == "" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		p.mu.Lock()
+		claims := jwt.MapClaims{
+			"iss": p.URL,
+			"aud": "inboxly",
+			"exp": time.Now().Add(time.Minute).Unix(),
+			"iat": time.Now().Unix(),
+		}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		p.mu.Unlock()
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// setupMockOIDC enables SSO against a fresh mock provider.
+func setupMockOIDC(t *testing.T) *mockProvider {
+	t.Helper()
+	p := newMockProvider(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("OIDC_ISSUER_URL", p.URL)
+	t.Setenv("OIDC_CLIENT_ID", "inboxly")
+	t.Setenv("OIDC_CLIENT_SECRET", "secret")
+	t.Setenv("OIDC_REDIRECT_URL", "http://localhost/api/auth/oidc/callback")
+	SetupOIDC()
+	t.Cleanup(func() { oidcProvider = nil })
+	return p
+}
+
+// login runs the flow with the provider returning claims for the user.
+func (p *mockProvider) login(t *testing.T, claims jwt.MapClaims) (int, error) {
+	t.Helper()
+	authURL, signedState, err := oidcProvider.AuthURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+
+	p.mu.Lock()
+	p.claims = jwt.MapClaims{"nonce": query.Get("nonce")}
+	for k, v := range claims {
+		p.claims[k] = v
+	}
+	p.mu.Unlock()
+
+	user, err := oidcProvider.Exchange(context.Background(), signedState, query.Get("state"), "code")
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+func TestOIDCCreatesAndLinksUsers(t *testing.T) {
+	mem := useMemoryStore(t)
+	p := setupMockOIDC(t)
+
+	newID, err := p.login(t, jwt.MapClaims{
+		"sub": "new", "email": "New@Example.com", "email_verified": true, "preferred_username": "new.user",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := mem.GetUser(newID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Username != "new.user" || created.Email != "new@example.com" || !created.EmailVerified {
+		t.Errorf("created %+v", created)
+	}
+	again, err := p.login(t, jwt.MapClaims{"sub": "new", "email": "changed@example.com"})
+	if err != nil || again != newID {
+		t.Errorf("second login: user %d, %v; want user %d", again, err, newID)
+	}
+
+	alice := createUser(t, mem, "alice", "alice@example.com", "Correct-Horse-9-Battery")
+	if err := mem.SetEmailVerified(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	linked, err := p.login(t, jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true})
+	if err != nil || linked != alice.ID {
+		t.Errorf("linking a verified account: user %d, %v; want user %d", linked, err, alice.ID)
+	}
+}
+
+func TestOIDCRefusesUnverifiedLinks(t *testing.T) {
+	mem := useMemoryStore(t)
+	p := setupMockOIDC(t)
+
+	bob := createUser(t, mem, "bob", "bob@example.com", "Correct-Horse-9-Battery")
+	if err := mem.SetEmailVerified(bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.login(t, jwt.MapClaims{"sub": "bob", "email": "bob@example.com", "email_verified": false}); err == nil {
+		t.Error("linked an email the provider has not verified")
+	}
+
+	// Carol never confirmed her address, so anyone could have registered
+	// it; the provider's verification must not hand them her account.
+	carol := createUser(t, mem, "carol", "carol@example.com", "Correct-Horse-9-Battery")
+	if _, err := p.login(t, jwt.MapClaims{"sub": "carol", "email": "carol@example.com", "email_verified": true}); err == nil {
+		t.Error("linked an account whose email is not verified")
+	}
+
+	for _, user := range []*models.User{bob, carol} {
+		if _, err := mem.GetUserByIdentity(p.URL, user.Username); err == nil {
+			t.Errorf("%s got linked", user.Username)
+		}
+	}
+}
+
+func TestOIDCStateIsNotASession(t *testing.T) {
+	useMemoryStore(t)
+	p := setupMockOIDC(t)
+
+	_, signedState, err := oidcProvider.AuthURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateToken(signedState); err == nil {
+		t.Error("state cookie accepted as a session token")
+	}
+
+	session, err := GenerateToken(1, "alice", RoleMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oidcProvider.Exchange(context.Background(), session, "", "code"); err == nil {
+		t.Error("session token accepted as state")
+	}
+
+	if _, err := p.login(t, jwt.MapClaims{"sub": "x", "email": "x@example.com", "email_verified": true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCCallbackRedirectsFailures(t *testing.T) {
+	useMemoryStore(t)
+	setupMockOIDC(t)
+	t.Setenv("FRONTEND_URL", "http://app.example/")
+	r := gin.New()
+	r.GET("/api/auth/oidc/callback", OIDCCallbackHandler)
+
+	_, signedState, err := oidcProvider.AuthURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		query  string
+		cookie string
+		want   string
+	}{
+		{"provider error", "error=access_denied", "", "access_denied"},
+		{"unknown provider error", "error=x%26token%3Dforged", "", "sso_failed"},
+		{"no state cookie", "state=s&code=c", "", "invalid_state"},
+		{"wrong state", "state=wrong&code=c", signedState, "sso_failed"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+tt.query, nil)
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		want := "http://app.example/oauth/callback#error=" + tt.want
+		if w.Code != http.StatusFound || w.Header().Get("Location") != want {
+			t.Errorf("%s: HTTP %d to %q, want a redirect to %q", tt.name, w.Code, w.Header().Get("Location"), want)
+		}
+	}
+}
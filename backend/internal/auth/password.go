@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes and verifies passwords. Encoded hashes identify their
+// own algorithm and parameters, so hashes produced by any hasher can be
+// verified and NeedsRehash reports when a stored hash is out of date.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	NeedsRehash(encoded string) bool
+}
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// bcryptMaxBytes is the longest password bcrypt accepts.
+const bcryptMaxBytes = 72
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(bytes), err
+}
+
+func (h BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h BcryptHasher) NeedsRehash(encoded string) bool {
+	if !isBcryptHash(encoded) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher stores hashes in the PHC string format:
+// $argon2id$v=19$m=<KiB>,t=<iterations>,p=<threads>$<salt>$<key>
+type Argon2idHasher struct {
+	Memory     uint32
+	Iterations uint32
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+type argon2Params struct {
+	memory     uint32
+	iterations uint32
+	threads    uint8
+	salt       []byte
+	key        []byte
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Threads, h.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.iterations, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(encoded string) bool {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.memory != h.Memory || p.iterations != h.Iterations || p.threads != h.Threads ||
+		uint32(len(p.salt)) != h.SaltLength || uint32(len(p.key)) != h.KeyLength
+}
+
+func decodeArgon2id(encoded string) (*argon2Params, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, err
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	p := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.threads); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func isBcryptHash(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// hasherFor picks the hasher able to verify an encoded hash, regardless of
+// which algorithm is currently configured.
+func hasherFor(encoded string) (PasswordHasher, error) {
+	switch {
+	case isBcryptHash(encoded):
+		return BcryptHasher{}, nil
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return Argon2idHasher{}, nil
+	}
+	return nil, ErrUnknownHashFormat
+}
+
+// Defaults follow the OWASP password storage recommendations.
+var passwordHasher PasswordHasher = Argon2idHasher{
+	Memory:     19 * 1024,
+	Iterations: 2,
+	Threads:    1,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+// SetupPasswordHasher configures password hashing from the environment.
+// PASSWORD_HASH_ALGORITHM selects "argon2id" (default) or "bcrypt";
+// BCRYPT_COST and ARGON2_MEMORY_KIB, ARGON2_ITERATIONS, ARGON2_THREADS tune
+// the parameters. Existing hashes are upgraded on the next successful login.
+func SetupPasswordHasher() {
+	switch strings.ToLower(os.Getenv("PASSWORD_HASH_ALGORITHM")) {
+	case "bcrypt":
+		passwordHasher = BcryptHasher{Cost: envInt("BCRYPT_COST", 12)}
+	case "", "argon2id":
+		passwordHasher = Argon2idHasher{
+			Memory:     uint32(envInt("ARGON2_MEMORY_KIB", 19*1024)),
+			Iterations: uint32(envInt("ARGON2_ITERATIONS", 2)),
+			Threads:    uint8(envInt("ARGON2_THREADS", 1)),
+			SaltLength: 16,
+			KeyLength:  32,
+		}
+	default:
+		log.Fatalf("Unknown PASSWORD_HASH_ALGORITHM %q", os.Getenv("PASSWORD_HASH_ALGORITHM"))
+	}
+}
+
+func SetPasswordHasher(h PasswordHasher) {
+	passwordHasher = h
+}
+
+func envInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid value for %s: %q", key, value)
+	}
+	return n
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"backend/internal/models"
+	"strings"
+	"testing"
+)
+
+// fastArgon2id keeps the tests quick; the defaults take tens of ms.
+var fastArgon2id = Argon2idHasher{Memory: 64, Iterations: 1, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+// useHasher configures h for the test.
+func useHasher(t *testing.T, h PasswordHasher) {
+	t.Helper()
+	previous := passwordHasher
+	SetPasswordHasher(h)
+	t.Cleanup(func() { SetPasswordHasher(previous) })
+}
+
+func TestPasswordHashers(t *testing.T) {
+	hashers := map[string]PasswordHasher{
+		"bcrypt":   BcryptHasher{Cost: 4},
+		"argon2id": fastArgon2id,
+	}
+	outdated := map[string]PasswordHasher{
+		"bcrypt":   BcryptHasher{Cost: 5},
+		"argon2id": Argon2idHasher{Memory: 128, Iterations: 1, Threads: 1, SaltLength: 16, KeyLength: 32},
+	}
+	for name, h := range hashers {
+		t.Run(name, func(t *testing.T) {
+			hash, err := h.Hash("Correct-Horse-9-Battery")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again, _ := h.Hash("Correct-Horse-9-Battery"); again == hash {
+				t.Error("two hashes of one password are equal; the salt is not random")
+			}
+			if ok, err := h.Verify("Correct-Horse-9-Battery", hash); !ok || err != nil {
+				t.Errorf("Verify(right password) = %v, %v", ok, err)
+			}
+			if ok, err := h.Verify("Wrong-Horse-9-Battery", hash); ok || err != nil {
+				t.Errorf("Verify(wrong password) = %v, %v", ok, err)
+			}
+			if h.NeedsRehash(hash) {
+				t.Error("NeedsRehash of a current hash")
+			}
+			if !outdated[name].NeedsRehash(hash) {
+				t.Error("NeedsRehash missed changed parameters")
+			}
+			for other, o := range hashers {
+				if other != name && !o.NeedsRehash(hash) {
+					t.Errorf("%s hasher does not upgrade %s hashes", other, name)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckPasswordHash(t *testing.T) {
+	bcryptHash, err := BcryptHasher{Cost: 4}.Hash("Correct-Horse-9-Battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argonHash, err := fastArgon2id.Hash("Correct-Horse-9-Battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useHasher(t, fastArgon2id)
+
+	tests := []struct {
+		name, password, hash string
+		want                 bool
+	}{
+		{"bcrypt", "Correct-Horse-9-Battery", bcryptHash, true},
+		{"argon2id", "Correct-Horse-9-Battery", argonHash, true},
+		{"wrong password", "correct-horse-9-battery", argonHash, false},
+		{"unknown format", "Correct-Horse-9-Battery", "$md5$abc", false},
+		{"corrupt argon2id", "Correct-Horse-9-Battery", "$argon2id$v=19$m=64$salt$key", false},
+	}
+	for _, tt := range tests {
+		if got := CheckPasswordHash(tt.password, tt.hash); got != tt.want {
+			t.Errorf("%s: CheckPasswordHash = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoginUpgradesPasswordHash(t *testing.T) {
+	mem := useMemoryStore(t)
+	useHasher(t, BcryptHasher{Cost: 4})
+	const password = "Correct-Horse-9-Battery"
+	createUser(t, mem, "alice", "alice@example.com", password)
+
+	SetPasswordHasher(fastArgon2id)
+	if _, err := AuthenticateUser(models.LoginRequest{Username: "alice", Password: password}); err != nil {
+		t.Fatal(err)
+	}
+	_, hash, err := mem.GetUserByLogin("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$") || fastArgon2id.NeedsRehash(hash) {
+		t.Errorf("hash after login is %q, want the configured argon2id", hash)
+	}
+	if _, err := AuthenticateUser(models.LoginRequest{Username: "alice", Password: password}); err != nil {
+		t.Errorf("login with the upgraded hash: %v", err)
+	}
+}
+
+func TestSetupPasswordHasher(t *testing.T) {
+	useHasher(t, passwordHasher)
+
+	t.Setenv("PASSWORD_HASH_ALGORITHM", "bcrypt")
+	t.Setenv("BCRYPT_COST", "5")
+	SetupPasswordHasher()
+	if passwordHasher != (BcryptHasher{Cost: 5}) {
+		t.Errorf("got %#v, want bcrypt with cost 5", passwordHasher)
+	}
+
+	t.Setenv("PASSWORD_HASH_ALGORITHM", "")
+	t.Setenv("ARGON2_MEMORY_KIB", "1024")
+	SetupPasswordHasher()
+	want := Argon2idHasher{Memory: 1024, Iterations: 2, Threads: 1, SaltLength: 16, KeyLength: 32}
+	if passwordHasher != want {
+		t.Errorf("got %#v, want %#v", passwordHasher, want)
+	}
+}
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// PasswordPolicy describes the rules new passwords must satisfy.
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	// MaxBytes limits the encoded length for hashers that cannot take
+	// longer input; 0 means no limit.
+	MaxBytes       int
+	MinCharClasses int
+	// MaxSimilarity is the highest allowed similarity (0..1) between the
+	// password and the username or the local part of the email.
+	MaxSimilarity float64
+	breached      map[string]struct{}
+}
+
+type PolicyViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PolicyError lists every rule a password failed.
+type PolicyError struct {
+	Violations []PolicyViolation
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return "password does not meet requirements: " + strings.Join(messages, "; ")
+}
+
+var passwordPolicy = &PasswordPolicy{
+	MinLength:     8,
+	MaxLength:     128,
+	MaxSimilarity: 0.7,
+	breached:      loadPasswordList(strings.NewReader(commonPasswords)),
+}
+
+// SetupPasswordPolicy configures the policy from the environment:
+// PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH, PASSWORD_MIN_CHAR_CLASSES and
+// PASSWORD_BREACHED_LIST (a file with one known-breached password per line,
+// added to the built-in list of common passwords).
+func SetupPasswordPolicy() {
+	passwordPolicy.MinLength = envInt("PASSWORD_MIN_LENGTH", passwordPolicy.MinLength)
+	passwordPolicy.MaxLength = envInt("PASSWORD_MAX_LENGTH", passwordPolicy.MaxLength)
+	passwordPolicy.MinCharClasses = envInt("PASSWORD_MIN_CHAR_CLASSES", passwordPolicy.MinCharClasses)
+
+	if path := os.Getenv("PASSWORD_BREACHED_LIST"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open breached password list: %v", err)
+		}
+		defer f.Close()
+		for password := range loadPasswordList(f) {
+			passwordPolicy.breached[password] = struct{}{}
+		}
+		log.Printf("Loaded breached password list (%d entries)", len(passwordPolicy.breached))
+	}
+}
+
+func loadPasswordList(r io.Reader) map[string]struct{} {
+	list := make(map[string]struct{})
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			list[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return list
+}
+
+// ValidatePassword checks password against the configured policy. With
+// bcrypt, passwords are also limited to the 72 bytes it can hash.
+func ValidatePassword(password, username, email string) error {
+	policy := *passwordPolicy
+	if _, ok := passwordHasher.(BcryptHasher); ok {
+		policy.MaxBytes = bcryptMaxBytes
+	}
+	return policy.Validate(password, username, email)
+}
+
+func (p *PasswordPolicy) Validate(password, username, email string) error {
+	var violations []PolicyViolation
+	add := func(code, format string, args ...interface{}) {
+		violations = append(violations, PolicyViolation{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		add("too_short", "must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add("too_long", "must be at most %d characters", p.MaxLength)
+	} else if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		add("too_long", "must be at most %d bytes", p.MaxBytes)
+	}
+	if p.MinCharClasses > 0 && charClasses(password) < p.MinCharClasses {
+		add("too_simple", "must contain at least %d of: lowercase, uppercase, digits, symbols", p.MinCharClasses)
+	}
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		add("breached", "is too common and appears in known password breaches")
+	}
+
+	lowered := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+	for _, name := range []string{strings.ToLower(username), localPart} {
+		if len(name) < 3 {
+			continue
+		}
+		if strings.Contains(lowered, name) || strings.Contains(name, lowered) || similarity(lowered, name) > p.MaxSimilarity {
+			add("similar_to_username", "must not be similar to your username or email")
+			break
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func charClasses(s string) int {
+	var lower, upper, digit, other bool
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	count := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
+
+// similarity returns 1 - normalized Levenshtein distance.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
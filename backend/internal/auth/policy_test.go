@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	p := &PasswordPolicy{
+		MinLength:      8,
+		MaxLength:      20,
+		MinCharClasses: 3,
+		MaxSimilarity:  0.7,
+		breached:       loadPasswordList(strings.NewReader("Password1!\n\n  letmein99X  \n")),
+	}
+	tests := []struct {
+		password string
+		want     []string
+	}{
+		{"Correct-Horse-9", nil},
+		{"Sh0rt!", []string{"too_short"}},
+		{"Much-Too-Long-Password-1", []string{"too_long"}},
+		{"alllowercase", []string{"too_simple"}},
+		{"password1!", []string{"breached"}},
+		{"LETMEIN99x", []string{"breached"}},
+		{"Alice-2024!", []string{"similar_to_username"}},
+		{"Al1ce.Smith", []string{"similar_to_username"}},
+		{"xy", []string{"too_short", "too_simple"}},
+	}
+	for _, tt := range tests {
+		err := p.Validate(tt.password, "alice", "al1ce.smith@example.com")
+		var codes []string
+		var policyErr *PolicyError
+		if errors.As(err, &policyErr) {
+			for _, v := range policyErr.Violations {
+				codes = append(codes, v.Code)
+			}
+		} else if err != nil {
+			t.Fatalf("%q: %v", tt.password, err)
+		}
+		if !reflect.DeepEqual(codes, tt.want) {
+			t.Errorf("%q: violations %v, want %v", tt.password, codes, tt.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"alice", "alice", 1},
+		{"alice", "alicf", 0.8},
+		{"abc", "xyz", 0},
+		{"héllo", "hello", 0.8},
+	}
+	for _, tt := range tests {
+		if got := similarity(tt.a, tt.b); got != tt.want {
+			t.Errorf("similarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSetupPasswordPolicy(t *testing.T) {
+	previous := *passwordPolicy
+	t.Cleanup(func() { *passwordPolicy = previous })
+	passwordPolicy.breached = map[string]struct{}{}
+
+	list := t.TempDir() + "/breached.txt"
+	if err := os.WriteFile(list, []byte("Tr0ub4dor&3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PASSWORD_MIN_LENGTH", "12")
+	t.Setenv("PASSWORD_MIN_CHAR_CLASSES", "2")
+	t.Setenv("PASSWORD_BREACHED_LIST", list)
+	SetupPasswordPolicy()
+
+	if passwordPolicy.MinLength != 12 || passwordPolicy.MinCharClasses != 2 || passwordPolicy.MaxLength != previous.MaxLength {
+		t.Errorf("policy %+v", passwordPolicy)
+	}
+	if err := ValidatePassword("tr0ub4dor&3", "alice", "alice@example.com"); err == nil {
+		t.Error("accepted a password from PASSWORD_BREACHED_LIST")
+	}
+}
+
+func TestRegisterRejectsWeakPasswords(t *testing.T) {
+	mem := useMemoryStore(t)
+	r := gin.New()
+	r.POST("/register", RegisterHandler)
+
+	body := `{"username":"alice","email":"alice@example.com","password":"alice123"}`
+	req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", w.Code, w.Body)
+	}
+	for _, want := range []string{"weak_password", "similar_to_username"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("response %s does not mention %s", w.Body, want)
+		}
+	}
+	if _, _, err := mem.GetUserByLogin("alice"); err == nil {
+		t.Error("the user was created")
+	}
+}
+
+func TestBcryptCapsPasswordBytes(t *testing.T) {
+	// 46 characters, but 76 bytes: bcrypt would reject it when hashing.
+	password := "Correct-Horse-9-" + strings.Repeat("é", 30)
+
+	useHasher(t, fastArgon2id)
+	if err := ValidatePassword(password, "alice", "alice@example.com"); err != nil {
+		t.Errorf("argon2id: %v", err)
+	}
+
+	useHasher(t, BcryptHasher{Cost: 4})
+	var policyErr *PolicyError
+	err := ValidatePassword(password, "alice", "alice@example.com")
+	if !errors.As(err, &policyErr) || len(policyErr.Violations) != 1 || policyErr.Violations[0].Code != "too_long" {
+		t.Errorf("bcrypt: got %v, want too_long", err)
+	}
+	if err := ValidatePassword(password[:72], "alice", "alice@example.com"); err != nil {
+		t.Errorf("bcrypt, 72 bytes: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"backend/pkg/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles, from most to least privileged. A user's role is stored in
+// users.role and read on every request.
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+	RoleGuest     = "guest"
+)
+
+type Permission string
+
+const (
+	PermReadMessages     Permission = "messages.read"
+	PermSendMessages     Permission = "messages.send"
+	PermEditOwnMessages  Permission = "messages.edit_own"
+	PermDeleteOwnMessage Permission = "messages.delete_own"
+	PermDeleteAnyMessage Permission = "messages.delete_any"
+	PermKickUsers        Permission = "users.kick"
+	PermMuteUsers        Permission = "users.mute"
+	PermBanUsers         Permission = "users.ban"
+	PermManageUsers      Permission = "users.manage"
+	PermManageRoles      Permission = "roles.manage"
+	PermViewAudit        Permission = "audit.view"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleGuest: {
+		PermReadMessages,
+	},
+	RoleMember: {
+		PermReadMessages, PermSendMessages, PermEditOwnMessages, PermDeleteOwnMessage,
+	},
+	RoleModerator: {
+		PermReadMessages, PermSendMessages, PermEditOwnMessages, PermDeleteOwnMessage,
+		PermDeleteAnyMessage, PermKickUsers, PermMuteUsers, PermBanUsers,
+	},
+	RoleAdmin: {
+		PermReadMessages, PermSendMessages, PermEditOwnMessages, PermDeleteOwnMessage,
+		PermDeleteAnyMessage, PermKickUsers, PermMuteUsers, PermBanUsers,
+		PermManageUsers, PermManageRoles, PermViewAudit,
+	},
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func RoleHasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleRank orders roles so moderators can't act on admins; higher is more
+// privileged. Unknown roles rank lowest.
+func RoleRank(role string) int {
+	switch role {
+	case RoleAdmin:
+		return 3
+	case RoleModerator:
+		return 2
+	case RoleMember:
+		return 1
+	}
+	return 0
+}
+
+// RequirePermission rejects callers whose role lacks any of perms. Must run
+// after AuthMiddleware.
+func RequirePermission(perms ...Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, perm := range perms {
+			if !RoleHasPermission(role, perm) {
+				utils.ErrorResponse(c, http.StatusForbidden, "Insufficient permissions", "forbidden")
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRolePermissions(t *testing.T) {
+	roles := []string{RoleGuest, RoleMember, RoleModerator, RoleAdmin}
+	tests := []struct {
+		perm Permission
+		// least is the least privileged role that has perm.
+		least string
+	}{
+		{PermReadMessages, RoleGuest},
+		{PermSendMessages, RoleMember},
+		{PermDeleteAnyMessage, RoleModerator},
+		{PermKickUsers, RoleModerator},
+		{PermBanUsers, RoleModerator},
+		{PermManageUsers, RoleAdmin},
+		{PermManageRoles, RoleAdmin},
+		{PermViewAudit, RoleAdmin},
+	}
+	for _, tt := range tests {
+		for _, role := range roles {
+			want := RoleRank(role) >= RoleRank(tt.least)
+			if got := RoleHasPermission(role, tt.perm); got != want {
+				t.Errorf("RoleHasPermission(%s, %s) = %v, want %v", role, tt.perm, got, want)
+			}
+		}
+	}
+	if RoleHasPermission("wizard", PermReadMessages) || ValidRole("wizard") || RoleRank("wizard") != RoleRank(RoleGuest) {
+		t.Error("an unknown role has privileges")
+	}
+	for i := 1; i < len(roles); i++ {
+		if RoleRank(roles[i]) <= RoleRank(roles[i-1]) {
+			t.Errorf("%s does not outrank %s", roles[i], roles[i-1])
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	mem := useMemoryStore(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	r := protected(RequirePermission(PermReadMessages, PermViewAudit))
+
+	tests := map[string]int{
+		RoleMember: http.StatusForbidden,
+		RoleAdmin:  http.StatusOK,
+	}
+	for role, want := range tests {
+		user := createUser(t, mem, role, role+"@example.com", "Correct-Horse-9-Battery")
+		if err := mem.SetRole(user.ID, role); err != nil {
+			t.Fatal(err)
+		}
+		token, err := GenerateToken(user.ID, user.Username, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := get(r, token); w.Code != want {
+			t.Errorf("%s: status %d, want %d", role, w.Code, want)
+		}
+	}
+}
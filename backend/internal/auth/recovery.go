@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"backend/internal/mail"
+	"backend/internal/store"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func frontendLink(path, token string) string {
+	base := strings.TrimRight(os.Getenv("FRONTEND_URL"), "/")
+	return fmt.Sprintf("%s%s?token=%s", base, path, token)
+}
+
+// SendVerificationEmail issues a fresh verification token and mails it.
+func SendVerificationEmail(userID int, email, username string) error {
+	if err := invalidateAuthTokens(userID, PurposeEmailVerification); err != nil {
+		return err
+	}
+	token, err := CreateAuthToken(userID, PurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return mail.Send(mail.Message{
+		To:      email,
+		Subject: "Verify your Inboxly email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening the link below:\n\n%s\n\nThe link expires in %d hours.\n",
+			username, frontendLink("/verify-email", token), int(emailVerificationTTL.Hours())),
+	})
+}
+
+// VerifyEmail marks the token owner's email as verified and returns their ID.
+func VerifyEmail(token string) (int, error) {
+	userID, err := ConsumeAuthToken(token, PurposeEmailVerification)
+	if err != nil {
+		return 0, err
+	}
+	return userID, repo.SetEmailVerified(userID)
+}
+
+// RequestPasswordReset mails a reset link if the email belongs to an account.
+// Unknown addresses are not reported so the endpoint can't be used to probe
+// for registered users.
+func RequestPasswordReset(email string) error {
+	user, err := repo.GetUserByEmail(NormalizeEmail(email))
+	if err != nil {
+		if err == store.ErrNotFound {
+			log.Printf("Password reset requested for unknown email")
+			return nil
+		}
+		return err
+	}
+
+	if err := invalidateAuthTokens(user.ID, PurposePasswordReset); err != nil {
+		return err
+	}
+	token, err := CreateAuthToken(user.ID, PurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return mail.Send(mail.Message{
+		To:      email,
+		Subject: "Reset your Inboxly password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone requested a password reset for your account. Open the link below to choose a new password:\n\n%s\n\nThe link expires in %d minutes. If you didn't request this, you can ignore this email.\n",
+			user.Username, frontendLink("/reset-password", token), int(passwordResetTTL.Minutes())),
+	})
+}
+
+// ResetPassword sets a new password for the token owner and returns their ID.
+func ResetPassword(token, newPassword string) (int, error) {
+	// Check the policy before consuming the token so a rejected password
+	// doesn't force the user to request a new link.
+	ownerID, err := repo.FindAuthToken(hashToken(token), PurposePasswordReset)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return 0, ErrInvalidToken
+		}
+		return 0, err
+	}
+	owner, err := repo.GetUser(ownerID)
+	if err != nil {
+		return 0, err
+	}
+	if err := ValidatePassword(newPassword, owner.Username, owner.Email); err != nil {
+		return 0, err
+	}
+
+	userID, err := ConsumeAuthToken(token, PurposePasswordReset)
+	if err != nil {
+		return 0, err
+	}
+
+	hashedPassword, err := HashPassword(newPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	// Receiving the reset link proves ownership of the address as well.
+	if err := repo.SetPasswordHash(userID, hashedPassword); err != nil {
+		return 0, err
+	}
+	if err := repo.SetEmailVerified(userID); err != nil {
+		return 0, err
+	}
+	return userID, invalidateAuthTokens(userID, PurposePasswordReset)
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"backend/internal/mail"
+	"backend/internal/models"
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mailbox keeps the messages sent through it.
+type mailbox struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *mailbox) Send(msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+var linkToken = regexp.MustCompile(`\?token=([0-9a-f]+)`)
+
+// lastToken returns the token in the link of the latest message to addr.
+func (m *mailbox) lastToken(t *testing.T, addr string) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To != addr {
+			continue
+		}
+		match := linkToken.FindStringSubmatch(m.messages[i].Body)
+		if match == nil {
+			t.Fatalf("no link in %q", m.messages[i].Body)
+		}
+		return match[1]
+	}
+	t.Fatalf("no mail to %s", addr)
+	return ""
+}
+
+func (m *mailbox) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.messages)
+}
+
+func useMailbox(t *testing.T) *mailbox {
+	t.Helper()
+	box := &mailbox{}
+	previous := mail.DefaultSender
+	mail.DefaultSender = box
+	t.Cleanup(func() { mail.DefaultSender = previous })
+	return box
+}
+
+func TestVerifyEmail(t *testing.T) {
+	mem := useMemoryStore(t)
+	box := useMailbox(t)
+	alice := createUser(t, mem, "alice", "alice@example.com", "Correct-Horse-9-Battery")
+
+	if err := SendVerificationEmail(alice.ID, alice.Email, alice.Username); err != nil {
+		t.Fatal(err)
+	}
+	first := box.lastToken(t, alice.Email)
+	if err := SendVerificationEmail(alice.ID, alice.Email, alice.Username); err != nil {
+		t.Fatal(err)
+	}
+	second := box.lastToken(t, alice.Email)
+
+	if _, err := VerifyEmail(first); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("superseded token: got %v, want ErrInvalidToken", err)
+	}
+	if _, err := ResetPassword(second, "Another-Horse-7-Staple"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("verification token used for a reset: got %v, want ErrInvalidToken", err)
+	}
+	userID, err := VerifyEmail(second)
+	if err != nil || userID != alice.ID {
+		t.Fatalf("VerifyEmail = %d, %v; want %d", userID, err, alice.ID)
+	}
+	if user, _ := mem.GetUser(alice.ID); !user.EmailVerified {
+		t.Error("email not verified")
+	}
+	if _, err := VerifyEmail(second); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("reused token: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestExpiredAuthToken(t *testing.T) {
+	mem := useMemoryStore(t)
+	alice := createUser(t, mem, "alice", "alice@example.com", "Correct-Horse-9-Battery")
+
+	token, err := CreateAuthToken(alice.ID, PurposeEmailVerification, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyEmail(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	mem := useMemoryStore(t)
+	box := useMailbox(t)
+	alice := createUser(t, mem, "alice", "alice@example.com", "Correct-Horse-9-Battery")
+
+	if err := RequestPasswordReset("nobody@example.com"); err != nil {
+		t.Fatalf("unknown address: %v", err)
+	}
+	if box.count() != 0 {
+		t.Fatal("mailed a reset link for an unknown address")
+	}
+	if err := RequestPasswordReset("Alice@Example.com"); err != nil {
+		t.Fatal(err)
+	}
+	token := box.lastToken(t, "Alice@Example.com")
+
+	// A rejected password leaves the link usable.
+	var policyErr *PolicyError
+	if _, err := ResetPassword(token, "short"); !errors.As(err, &policyErr) {
+		t.Fatalf("weak password: got %v, want a PolicyError", err)
+	}
+	const password = "Another-Horse-7-Staple"
+	userID, err := ResetPassword(token, password)
+	if err != nil || userID != alice.ID {
+		t.Fatalf("ResetPassword = %d, %v; want %d", userID, err, alice.ID)
+	}
+	if _, err := ResetPassword(token, "Third-Horse-5-Battery"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("reused token: got %v, want ErrInvalidToken", err)
+	}
+
+	if _, err := AuthenticateUser(models.LoginRequest{Username: "alice", Password: password}); err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+	if _, err := AuthenticateUser(models.LoginRequest{Username: "alice", Password: "Correct-Horse-9-Battery"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("login with the old password: got %v, want ErrInvalidCredentials", err)
+	}
+	if user, _ := mem.GetUser(alice.ID); !user.EmailVerified {
+		t.Error("a completed reset did not verify the email")
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"backend/internal/models"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	SanctionKick = "kick"
+	SanctionMute = "mute"
+	SanctionBan  = "ban"
+)
+
+// BannedError is returned by AuthenticateUser for banned accounts.
+type BannedError struct {
+	Sanction *models.Sanction
+}
+
+func (e *BannedError) Error() string {
+	if e.Sanction.ExpiresAt != nil {
+		return fmt.Sprintf("account banned until %s", e.Sanction.ExpiresAt.Format(time.RFC3339))
+	}
+	return "account banned"
+}
+
+// RecordSanction stores a moderation action. A zero duration means the
+// sanction doesn't expire (kicks are instantaneous and never "active").
+func RecordSanction(userID int, kind, reason string, actorID int, duration time.Duration) (*models.Sanction, error) {
+	sanction := &models.Sanction{
+		UserID:    userID,
+		Kind:      kind,
+		Reason:    reason,
+		CreatedBy: actorID,
+	}
+	if duration > 0 {
+		expiresAt := time.Now().Add(duration)
+		sanction.ExpiresAt = &expiresAt
+	}
+
+	if err := repo.CreateSanction(sanction); err != nil {
+		return nil, err
+	}
+	return sanction, nil
+}
+
+// ActiveSanction returns the longest-running active sanction of the given
+// kind, or nil if there is none.
+func ActiveSanction(userID int, kind string) (*models.Sanction, error) {
+	return repo.ActiveSanction(userID, kind)
+}
+
+// LiftSanctions ends every active sanction of the given kind. It reports
+// whether any were active.
+func LiftSanctions(userID int, kind string) (bool, error) {
+	return repo.LiftSanctions(userID, kind)
+}
+
+var ErrAccountDisabled = errors.New("account disabled")
+
+// CheckAccountActive returns ErrAccountDisabled or a *BannedError if the
+// user may not log in or connect.
+func CheckAccountActive(userID int) error {
+	user, err := repo.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.DisabledAt != nil {
+		return ErrAccountDisabled
+	}
+	return CheckBanned(userID)
+}
+
+// CheckBanned returns a *BannedError if the user is currently banned.
+func CheckBanned(userID int) error {
+	ban, err := ActiveSanction(userID, SanctionBan)
+	if err != nil {
+		return err
+	}
+	if ban != nil {
+		return &BannedError{Sanction: ban}
+	}
+	return nil
+}
+
+// LookupUser returns the username and role for a user ID.
+func LookupUser(userID int) (string, string, error) {
+	user, err := repo.GetUser(userID)
+	if err != nil {
+		return "", "", err
+	}
+	return user.Username, user.Role, nil
+}
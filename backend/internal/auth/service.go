@@ -1,37 +1,73 @@
 package auth
 
 import (
-	"backend/internal/database"
 	"backend/internal/models"
-	"database/sql"
+	"backend/internal/store"
 	"errors"
+	"log"
 	"os"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrInvalidUsername is returned for usernames that are too short or too
+// long, or that contain "@", which would be ambiguous with email addresses at
+// login.
+var ErrInvalidUsername = errors.New("username must be 3 to 50 characters and must not contain @")
+
+// repo holds users and credentials. It is set once at startup by SetStore.
+var repo store.Store
+
+func SetStore(s store.Store) {
+	repo = s
+}
+
 type Claims struct {
 	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
+	Role     string `json:"role"`
 	jwt.RegisteredClaims
 }
 
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+	return passwordHasher.Hash(password)
 }
 
 func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	hasher, err := hasherFor(hash)
+	if err != nil {
+		return false
+	}
+	ok, err := hasher.Verify(password, hash)
+	return err == nil && ok
 }
 
-func GenerateToken(userID int, username string) (string, error) {
+// rehashIfNeeded upgrades a stored hash to the configured algorithm and
+// parameters. It is only called after the password has been verified.
+func rehashIfNeeded(userID int, password, hash string) {
+	if !passwordHasher.NeedsRehash(hash) {
+		return
+	}
+	newHash, err := HashPassword(password)
+	if err != nil {
+		log.Printf("Failed to rehash password for user %d: %v", userID, err)
+		return
+	}
+	if err := repo.ReplacePasswordHash(userID, hash, newHash); err != nil {
+		log.Printf("Failed to store rehashed password for user %d: %v", userID, err)
+	}
+}
+
+func GenerateToken(userID int, username, role string) (string, error) {
 	claims := &Claims{
 		UserID:   userID,
 		Username: username,
+		Role:     role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -52,49 +88,61 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, err
 	}
 
-	if !token.Valid {
+	if !token.Valid || claims.UserID == 0 {
 		return nil, errors.New("invalid token")
 	}
 
+	// Tokens issued before roles existed carry no role.
+	if claims.Role == "" {
+		claims.Role = RoleMember
+	}
+
 	return claims, nil
 }
 
+// NormalizeEmail trims and lowercases an email address for storage and lookup.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizeUsername trims a username and checks that it may be registered.
+func NormalizeUsername(username string) (string, error) {
+	username = strings.TrimSpace(username)
+	if n := utf8.RuneCountInString(username); n < 3 || n > 50 || strings.Contains(username, "@") {
+		return "", ErrInvalidUsername
+	}
+	return username, nil
+}
+
 func CreateUser(req models.RegisterRequest) (*models.User, error) {
-	hashedPassword, err := HashPassword(req.Password)
+	username, err := NormalizeUsername(req.Username)
 	if err != nil {
 		return nil, err
 	}
+	req.Username = username
+	req.Email = NormalizeEmail(req.Email)
 
-	query := `INSERT INTO users (username, email, password_hash) VALUES ($1, $2, $3) RETURNING id, created_at`
-	var user models.User
-	err = database.DB.QueryRow(query, req.Username, req.Email, hashedPassword).Scan(&user.ID, &user.CreatedAt)
+	hashedPassword, err := HashPassword(req.Password)
 	if err != nil {
 		return nil, err
 	}
 
-	user.Username = req.Username
-	user.Email = req.Email
-	return &user, nil
+	user := &models.User{Username: req.Username, Email: req.Email}
+	if err := repo.CreateUser(user, hashedPassword); err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
+// AuthenticateUser checks credentials against the configured authenticators
+// and refuses disabled or banned accounts.
 func AuthenticateUser(req models.LoginRequest) (*models.User, error) {
-	query := `SELECT id, username, email, password_hash, created_at FROM users WHERE username = $1`
-	var user models.User
-	var passwordHash string
-
-	err := database.DB.QueryRow(query, req.Username).Scan(
-		&user.ID, &user.Username, &user.Email, &passwordHash, &user.CreatedAt,
-	)
+	user, err := authenticator.Authenticate(req.Username, req.Password)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.New("invalid credentials")
-		}
 		return nil, err
 	}
-
-	if !CheckPasswordHash(req.Password, passwordHash) {
-		return nil, errors.New("invalid credentials")
+	if err := CheckAccountActive(user.ID); err != nil {
+		return nil, err
 	}
-
-	return &user, nil
+	return user, nil
 }
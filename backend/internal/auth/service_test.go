@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"backend/internal/models"
+	"errors"
+	"testing"
+)
+
+func TestCreateUserRejectsAtInUsername(t *testing.T) {
+	useMemoryStore(t)
+	_, err := CreateUser(models.RegisterRequest{
+		Username: "bob@example.com",
+		Email:    "mallory@example.com",
+		Password: "Correct-Horse-9-Battery",
+	})
+	if !errors.Is(err, ErrInvalidUsername) {
+		t.Fatalf("got %v, want ErrInvalidUsername", err)
+	}
+}
+
+func TestAuthenticateByUsernameOrEmail(t *testing.T) {
+	mem := useMemoryStore(t)
+	const password = "Correct-Horse-9-Battery"
+	alice := createUser(t, mem, "Alice", "alice@example.com", password)
+
+	for _, login := range []string{"alice", "ALICE", "Alice@Example.com"} {
+		user, err := AuthenticateUser(models.LoginRequest{Username: login, Password: password})
+		if err != nil {
+			t.Errorf("%q: %v", login, err)
+			continue
+		}
+		if user.ID != alice.ID {
+			t.Errorf("%q: got user %d, want %d", login, user.ID, alice.ID)
+		}
+	}
+	if _, err := AuthenticateUser(models.LoginRequest{Username: "alice", Password: "wrong"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password: got %v, want ErrInvalidCredentials", err)
+	}
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"backend/internal/store"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Login throttling policy. Failures are counted since the last successful
+// login within failureWindow. After the free attempts are used up each further
+// failure doubles the wait, and reaching the lockout threshold locks the
+// account (or IP) for lockoutDuration.
+const (
+	failureWindow   = 30 * time.Minute
+	baseBackoff     = time.Second
+	lockoutDuration = 15 * time.Minute
+
+	usernameFreeAttempts = 3
+	usernameLockoutAfter = 10
+
+	// An IP may be shared by many users (NAT, office proxies), so allow more.
+	ipFreeAttempts = 10
+	ipLockoutAfter = 50
+)
+
+// ThrottledError is returned when a login attempt arrives before the caller
+// is allowed to retry.
+type ThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("account temporarily locked, retry in %s", e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("too many failed attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+func normalizeLoginName(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func backoffFor(failures, freeAttempts, lockoutAfter int) (time.Duration, bool) {
+	if failures >= lockoutAfter {
+		return lockoutDuration, true
+	}
+	if failures < freeAttempts {
+		return 0, false
+	}
+	wait := baseBackoff << uint(failures-freeAttempts)
+	if wait > lockoutDuration {
+		wait = lockoutDuration
+	}
+	return wait, false
+}
+
+// LoginAttempt is a login attempt reserved by ReserveLoginAttempt.
+type LoginAttempt struct {
+	id int
+}
+
+// ReserveLoginAttempt records a failed attempt for the login name and ip,
+// then checks the throttle against the attempts recorded before it.
+// Recording first means concurrent attempts count against each other rather
+// than all passing the check at once. Failures are counted per normalized
+// login name, per IP and, when the name resolves to a user, per user, so
+// trying a username and its email address draws on the same budget. If the
+// caller has to wait the attempt is dropped and a *ThrottledError returned.
+func ReserveLoginAttempt(username, ip string) (*LoginAttempt, error) {
+	login := normalizeLoginName(username)
+	userID := 0
+	user, _, err := repo.GetUserByLogin(login)
+	switch {
+	case err == nil:
+		userID = user.ID
+	case !errors.Is(err, store.ErrNotFound):
+		return nil, err
+	}
+
+	id, err := repo.ReserveLoginAttempt(login, userID, ip)
+	if err != nil {
+		return nil, err
+	}
+	attempt := &LoginAttempt{id: id}
+
+	type check struct {
+		field        store.LoginField
+		value        string
+		freeAttempts int
+		lockoutAfter int
+	}
+	checks := []check{
+		{store.LoginByUsername, login, usernameFreeAttempts, usernameLockoutAfter},
+		{store.LoginByIP, ip, ipFreeAttempts, ipLockoutAfter},
+	}
+	if userID != 0 {
+		checks = append(checks, check{store.LoginByUserID, strconv.Itoa(userID), usernameFreeAttempts, usernameLockoutAfter})
+	}
+
+	var worst *ThrottledError
+	for _, check := range checks {
+		failures, elapsed, err := repo.RecentLoginFailures(check.field, check.value, id, failureWindow)
+		if err != nil {
+			attempt.release()
+			return nil, err
+		}
+		wait, locked := backoffFor(failures, check.freeAttempts, check.lockoutAfter)
+		remaining := wait - elapsed
+		if wait == 0 || remaining <= 0 {
+			continue
+		}
+		if worst == nil || remaining > worst.RetryAfter {
+			worst = &ThrottledError{RetryAfter: remaining, Locked: locked}
+		}
+	}
+
+	if worst != nil {
+		attempt.release()
+		return nil, worst
+	}
+	return attempt, nil
+}
+
+// Finish records how the attempt ended. A success clears the failures
+// counted against the login, invalid credentials keep the failure, and any
+// other error drops the attempt since it says nothing about the password.
+func (a *LoginAttempt) Finish(err error) error {
+	switch {
+	case err == nil:
+		return repo.SetLoginAttemptSucceeded(a.id)
+	case errors.Is(err, ErrInvalidCredentials):
+		return nil
+	default:
+		return repo.DeleteLoginAttempt(a.id)
+	}
+}
+
+func (a *LoginAttempt) release() {
+	if err := repo.DeleteLoginAttempt(a.id); err != nil {
+		log.Printf("Failed to release login attempt: %v", err)
+	}
+}
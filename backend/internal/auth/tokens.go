@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"backend/internal/store"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposePasswordReset     = "password_reset"
+
+	emailVerificationTTL = 48 * time.Hour
+	passwordResetTTL     = time.Hour
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAuthToken issues a single-use token for the given purpose. Only its
+// SHA-256 hash is stored; the plaintext is returned to be mailed to the user.
+func CreateAuthToken(userID int, purpose string, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	if err := repo.CreateAuthToken(userID, purpose, hashToken(token), time.Now().Add(ttl)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeAuthToken marks an unused, unexpired token as used and returns the
+// user it was issued to.
+func ConsumeAuthToken(token, purpose string) (int, error) {
+	userID, err := repo.ConsumeAuthToken(hashToken(token), purpose)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return 0, ErrInvalidToken
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+func invalidateAuthTokens(userID int, purpose string) error {
+	return repo.InvalidateAuthTokens(userID, purpose)
+}
@@ -0,0 +1,102 @@
+package chat
+
+import (
+	"backend/internal/cluster"
+	"backend/internal/persist"
+	"backend/internal/store"
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BenchmarkConfig describes a synthetic load for Benchmark.
+type BenchmarkConfig struct {
+	Connections  int
+	Messages     int
+	Shards       int
+	SlowConsumer SlowConsumerPolicy
+}
+
+// BenchmarkResult reports how long a hub took to register every connection
+// and to deliver every message to every connection.
+type BenchmarkResult struct {
+	BenchmarkConfig
+	ConnectTime   time.Duration
+	BroadcastTime time.Duration
+	Frames        int64
+	Dropped       int64
+	SlowConsumers map[string]int64
+}
+
+func (r BenchmarkResult) MessagesPerSecond() float64 {
+	return float64(r.Messages) / r.BroadcastTime.Seconds()
+}
+
+func (r BenchmarkResult) FramesPerSecond() float64 {
+	return float64(r.Frames) / r.BroadcastTime.Seconds()
+}
+
+var benchFrame = []byte(`{"type":"chat_message"`)
+
+// Benchmark runs a hub with synthetic connections that read their frames as
+// fast as they arrive, without sockets or a database. Connections dropped
+// for falling behind are counted in Dropped.
+func Benchmark(cfg BenchmarkConfig) BenchmarkResult {
+	broker := cluster.NewLocal().Join("bench")
+	defer broker.Close()
+	messages := NewMessageCache(store.NewMemory(), broker, defaultCacheSize)
+	writer, _ := persist.New(messages, persist.Options{})
+	defer writer.Close()
+	h := NewHub(messages, writer, broker, HubOptions{
+		Shards:       cfg.Shards,
+		SlowConsumer: cfg.SlowConsumer,
+	})
+	go h.Run()
+	defer h.stop()
+
+	result := BenchmarkResult{BenchmarkConfig: cfg}
+	result.Shards = len(h.shards)
+
+	var joined, done sync.WaitGroup
+	joined.Add(cfg.Connections)
+	done.Add(cfg.Connections)
+	start := time.Now()
+	for i := 0; i < cfg.Connections; i++ {
+		c := &Client{hub: h, send: make(chan *frame, 256), userID: i + 1, username: "bench"}
+		h.register <- c
+		go func() {
+			defer done.Done()
+			// The first frame is the online count sent on registration.
+			if _, ok := <-c.send; !ok {
+				joined.Done()
+				atomic.AddInt64(&result.Dropped, 1)
+				return
+			}
+			joined.Done()
+			received := 0
+			for f := range c.send {
+				if !bytes.HasPrefix(f.data, benchFrame) {
+					continue
+				}
+				atomic.AddInt64(&result.Frames, 1)
+				if received++; received == cfg.Messages {
+					return
+				}
+			}
+			atomic.AddInt64(&result.Dropped, 1)
+		}()
+	}
+	joined.Wait()
+	result.ConnectTime = time.Since(start)
+
+	message := newFrame("chat_message", Message{Username: "bench", Content: "benchmark", Timestamp: time.Now()})
+	start = time.Now()
+	for i := 0; i < cfg.Messages; i++ {
+		h.broadcast <- message.copy()
+	}
+	done.Wait()
+	result.BroadcastTime = time.Since(start)
+	result.SlowConsumers = h.slow.snapshot()
+	return result
+}
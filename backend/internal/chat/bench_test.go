@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestBenchmarkStopsItsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	result := Benchmark(BenchmarkConfig{Connections: 50, Messages: 20, Shards: 4})
+	if result.Frames != 50*20 || result.Dropped != 0 {
+		t.Errorf("delivered %d frames with %d dropped, want %d and none", result.Frames, result.Dropped, 50*20)
+	}
+
+	// Goroutines may take a moment to return after their channels close.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines before the benchmark, %d after", before, after)
+	}
+}
+
+func BenchmarkHubBroadcast(b *testing.B) {
+	for _, connections := range []int{100, 1000} {
+		b.Run(fmt.Sprintf("connections=%d", connections), func(b *testing.B) {
+			result := Benchmark(BenchmarkConfig{Connections: connections, Messages: b.N})
+			b.ReportMetric(result.FramesPerSecond(), "frames/s")
+			b.ReportMetric(float64(result.Dropped), "dropped")
+		})
+	}
+}
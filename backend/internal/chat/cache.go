@@ -0,0 +1,260 @@
+package chat
+
+import (
+	"backend/internal/cluster"
+	"backend/internal/models"
+	"backend/internal/store"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultCacheSize is how many of the newest messages MessageCache keeps
+// unless MESSAGE_CACHE_SIZE says otherwise.
+const defaultCacheSize = 500
+
+// MessageCache serves recent history from memory. It wraps the message
+// store: writes go to the database first and then update a ring buffer of
+// the newest messages, and reads that fall inside the buffer never reach the
+// database. Changes are published to other instances, whose hubs apply them
+// to their own caches.
+type MessageCache struct {
+	store.MessageStore
+	broker cluster.Broker
+
+	mu sync.RWMutex
+	// ring holds count messages in (CreatedAt, ID) order starting at head.
+	ring  []models.Message
+	head  int
+	count int
+	// loaded is false until the ring is filled from the database, and again
+	// after events may have been missed.
+	loaded bool
+	// complete means the ring holds every message in the database, so
+	// nothing older has to be fetched.
+	complete bool
+
+	hits, misses atomic.Int64
+}
+
+// NewMessageCache keeps up to size messages of s in memory; 0 disables the
+// cache and every read goes to s.
+func NewMessageCache(s store.MessageStore, broker cluster.Broker, size int) *MessageCache {
+	if size < 0 {
+		size = 0
+	}
+	return &MessageCache{
+		MessageStore: s,
+		broker:       broker,
+		ring:         make([]models.Message, size),
+	}
+}
+
+// SetupCache creates the server's cache from MESSAGE_CACHE_SIZE (default
+// 500, 0 to disable).
+func SetupCache(s store.MessageStore, broker cluster.Broker) *MessageCache {
+	size := defaultCacheSize
+	if v := os.Getenv("MESSAGE_CACHE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid MESSAGE_CACHE_SIZE %q", v)
+		}
+		size = n
+	}
+	return NewMessageCache(s, broker, size)
+}
+
+func (c *MessageCache) at(i int) models.Message {
+	return c.ring[(c.head+i)%len(c.ring)]
+}
+
+func (c *MessageCache) set(i int, msg models.Message) {
+	c.ring[(c.head+i)%len(c.ring)] = msg
+}
+
+// find returns the position of a message in the ring, or -1.
+func (c *MessageCache) find(id int) int {
+	for i := c.count - 1; i >= 0; i-- {
+		if c.at(i).ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// slice copies positions [from, to) of the ring.
+func (c *MessageCache) slice(from, to int) []models.Message {
+	messages := make([]models.Message, 0, to-from)
+	for i := from; i < to; i++ {
+		messages = append(messages, c.at(i))
+	}
+	return messages
+}
+
+// ensureLoaded fills the ring from the database unless it already reflects
+// it, and reports whether the ring can be used.
+func (c *MessageCache) ensureLoaded() bool {
+	if len(c.ring) == 0 {
+		return false
+	}
+	c.mu.RLock()
+	loaded := c.loaded
+	c.mu.RUnlock()
+	if loaded {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loaded {
+		return true
+	}
+	messages, err := c.MessageStore.RecentMessages(len(c.ring))
+	if err != nil {
+		log.Printf("Message cache: loading recent messages failed: %v", err)
+		return false
+	}
+	c.head, c.count = 0, copy(c.ring, messages)
+	c.complete = len(messages) < len(c.ring)
+	c.loaded = true
+	return true
+}
+
+// invalidate drops the ring; it is reloaded on the next read.
+func (c *MessageCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loaded = false
+	c.head, c.count = 0, 0
+}
+
+// upsert adds new messages to the ring.
+func (c *MessageCache) upsert(messages []models.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loaded {
+		return
+	}
+
+	for _, msg := range messages {
+		if c.find(msg.ID) >= 0 {
+			continue
+		}
+
+		pos := sort.Search(c.count, func(i int) bool {
+			m := c.at(i)
+			return m.CreatedAt.After(msg.CreatedAt) || m.CreatedAt.Equal(msg.CreatedAt) && m.ID > msg.ID
+		})
+		if pos == 0 && (!c.complete || c.count == len(c.ring)) {
+			// Older than everything in the ring: either there may be
+			// messages in between that it does not hold, or there is no
+			// room. It is only in the database now.
+			c.complete = false
+			continue
+		}
+		if c.count == len(c.ring) {
+			c.head = (c.head + 1) % len(c.ring)
+			c.count--
+			pos--
+			c.complete = false
+		}
+		c.count++
+		for i := c.count - 1; i > pos; i-- {
+			c.set(i, c.at(i-1))
+		}
+		c.set(pos, msg)
+	}
+}
+
+// apply updates the cache from another instance's event.
+func (c *MessageCache) apply(event cluster.Event) {
+	switch event.Kind {
+	case cluster.KindMessages:
+		c.upsert(event.Messages)
+	case cluster.KindResync:
+		c.invalidate()
+	}
+}
+
+func (c *MessageCache) saved(messages []models.Message) {
+	c.upsert(messages)
+	c.broker.Publish(cluster.Event{Kind: cluster.KindMessages, Messages: messages})
+}
+
+func (c *MessageCache) SaveMessage(msg *models.Message) error {
+	if err := c.MessageStore.SaveMessage(msg); err != nil {
+		return err
+	}
+	c.saved([]models.Message{*msg})
+	return nil
+}
+
+func (c *MessageCache) SaveMessages(msgs []models.Message) error {
+	if err := c.MessageStore.SaveMessages(msgs); err != nil {
+		return err
+	}
+	// The caller owns msgs, so the event gets its own copy.
+	c.saved(append([]models.Message(nil), msgs...))
+	return nil
+}
+
+func (c *MessageCache) GetMessage(id int) (*models.Message, error) {
+	if c.ensureLoaded() {
+		c.mu.RLock()
+		if i := c.find(id); c.loaded && i >= 0 {
+			msg := c.at(i)
+			c.mu.RUnlock()
+			c.hits.Add(1)
+			return &msg, nil
+		}
+		c.mu.RUnlock()
+	}
+	c.misses.Add(1)
+	return c.MessageStore.GetMessage(id)
+}
+
+func (c *MessageCache) RecentMessages(limit int) ([]models.Message, error) {
+	if c.ensureLoaded() {
+		c.mu.RLock()
+		if c.loaded && (limit <= c.count || c.complete) {
+			messages := c.slice(max(c.count-limit, 0), c.count)
+			c.mu.RUnlock()
+			c.hits.Add(1)
+			return messages, nil
+		}
+		c.mu.RUnlock()
+	}
+	c.misses.Add(1)
+	return c.MessageStore.RecentMessages(limit)
+}
+
+func (c *MessageCache) MessagesBefore(before, limit int) ([]models.Message, error) {
+	if c.ensureLoaded() {
+		c.mu.RLock()
+		if i := c.find(before); c.loaded && i >= 0 && (limit <= i || c.complete) {
+			messages := c.slice(max(i-limit, 0), i)
+			c.mu.RUnlock()
+			c.hits.Add(1)
+			return messages, nil
+		}
+		c.mu.RUnlock()
+	}
+	c.misses.Add(1)
+	return c.MessageStore.MessagesBefore(before, limit)
+}
+
+// Stats reports the cache's size and how many reads it answered.
+func (c *MessageCache) Stats() map[string]int64 {
+	c.mu.RLock()
+	cached := c.count
+	c.mu.RUnlock()
+	return map[string]int64{
+		"capacity": int64(len(c.ring)),
+		"cached":   int64(cached),
+		"hits":     c.hits.Load(),
+		"misses":   c.misses.Load(),
+	}
+}
@@ -0,0 +1,155 @@
+package chat
+
+import (
+	"backend/internal/cluster"
+	"backend/internal/models"
+	"backend/internal/store"
+	"slices"
+	"testing"
+	"time"
+)
+
+// ids returns the IDs of messages, in order.
+func ids(messages []models.Message) []int {
+	result := make([]int, len(messages))
+	for i, msg := range messages {
+		result[i] = msg.ID
+	}
+	return result
+}
+
+// expectStats checks how many reads c answered and how many went to the
+// store.
+func expectStats(t *testing.T, c *MessageCache, hits, misses int64) {
+	t.Helper()
+	stats := c.Stats()
+	if stats["hits"] != hits || stats["misses"] != misses {
+		t.Errorf("%d hits and %d misses, want %d and %d", stats["hits"], stats["misses"], hits, misses)
+	}
+}
+
+func TestMessageCacheServesRecentHistory(t *testing.T) {
+	mem := store.NewMemory()
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		if err := mem.SaveMessage(&models.Message{Username: "alice", Content: "hi", CreatedAt: start.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bus := cluster.NewLocal()
+	broker := bus.Join("a")
+	defer broker.Close()
+	cache := NewMessageCache(mem, broker, 3)
+
+	reads := []struct {
+		name string
+		read func() ([]models.Message, error)
+		want []int
+		hit  bool
+	}{
+		{"recent within the ring", func() ([]models.Message, error) { return cache.RecentMessages(2) }, []int{4, 5}, true},
+		{"recent beyond the ring", func() ([]models.Message, error) { return cache.RecentMessages(5) }, []int{1, 2, 3, 4, 5}, false},
+		{"before within the ring", func() ([]models.Message, error) { return cache.MessagesBefore(5, 2) }, []int{3, 4}, true},
+		{"before beyond the ring", func() ([]models.Message, error) { return cache.MessagesBefore(4, 2) }, []int{2, 3}, false},
+		{"before an uncached message", func() ([]models.Message, error) { return cache.MessagesBefore(2, 1) }, []int{1}, false},
+	}
+	var hits, misses int64
+	for _, r := range reads {
+		messages, err := r.read()
+		if err != nil {
+			t.Fatalf("%s: %v", r.name, err)
+		}
+		if !slices.Equal(ids(messages), r.want) {
+			t.Errorf("%s: got %v, want %v", r.name, ids(messages), r.want)
+		}
+		if r.hit {
+			hits++
+		} else {
+			misses++
+		}
+		expectStats(t, cache, hits, misses)
+	}
+
+	// A new message evicts the oldest cached one and reaches the database.
+	msg := &models.Message{Username: "alice", Content: "new", CreatedAt: start.Add(time.Hour)}
+	if err := cache.SaveMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := mem.GetMessage(msg.ID); err != nil || saved.Content != "new" {
+		t.Fatalf("store has %+v, %v", saved, err)
+	}
+	messages, err := cache.RecentMessages(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{4, 5, msg.ID}; !slices.Equal(ids(messages), want) {
+		t.Errorf("after saving: got %v, want %v", ids(messages), want)
+	}
+	if _, err := cache.GetMessage(msg.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectStats(t, cache, hits+2, misses)
+}
+
+func TestMessageCacheFollowsOtherInstances(t *testing.T) {
+	mem := store.NewMemory()
+	bus := cluster.NewLocal()
+	brokerA, brokerB := bus.Join("a"), bus.Join("b")
+	defer brokerA.Close()
+	defer brokerB.Close()
+	a, b := NewMessageCache(mem, brokerA, 10), NewMessageCache(mem, brokerB, 10)
+
+	// b loads the empty history, so it holds every message there is.
+	if messages, err := b.RecentMessages(10); err != nil || len(messages) != 0 {
+		t.Fatalf("b: %v, %v", messages, err)
+	}
+	msgs := []models.Message{
+		{Username: "alice", Content: "one", CreatedAt: time.Now()},
+		{Username: "alice", Content: "two", CreatedAt: time.Now()},
+	}
+	if err := a.SaveMessages(msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-brokerB.Events():
+		b.apply(event)
+	case <-time.After(time.Second):
+		t.Fatal("b received no event")
+	}
+	messages, err := b.RecentMessages(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ids(msgs); !slices.Equal(ids(messages), want) {
+		t.Errorf("b has %v, want %v", ids(messages), want)
+	}
+	expectStats(t, b, 2, 0)
+
+	// After a resync b may have missed events, so it reloads.
+	b.apply(cluster.Event{Kind: cluster.KindResync})
+	if b.Stats()["cached"] != 0 {
+		t.Error("b kept its messages after a resync")
+	}
+	if messages, err := b.RecentMessages(10); err != nil || len(messages) != 2 {
+		t.Errorf("b after resync: %v, %v", ids(messages), err)
+	}
+}
+
+func TestMessageCacheDisabled(t *testing.T) {
+	mem := store.NewMemory()
+	if err := mem.SaveMessage(&models.Message{Username: "alice", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	broker := cluster.NewLocal().Join("a")
+	defer broker.Close()
+	cache := NewMessageCache(mem, broker, 0)
+
+	if messages, err := cache.RecentMessages(10); err != nil || len(messages) != 1 {
+		t.Fatalf("got %v, %v", messages, err)
+	}
+	if _, err := cache.GetMessage(1); err != nil {
+		t.Fatal(err)
+	}
+	expectStats(t, cache, 0, 2)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -20,6 +21,8 @@ const (
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	// Binary is preferred when a client offers both.
+	Subprotocols: []string{SubprotocolMsgpack, SubprotocolJSON},
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
@@ -28,67 +31,118 @@ var upgrader = websocket.Upgrader{
 type Client struct {
 	hub      *Hub
 	conn     *websocket.Conn
-	send     chan []byte
+	send     chan *frame
 	userID   int
 	username string
-}
+	role     string
+	ip       string
 
+	userAgent   string
+	connectedAt time.Time
+	transport   string
+	encoding    string
 
-func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	tokenString := r.URL.Query().Get("token")
-	if tokenString == "" {
-		http.Error(w, "Missing token", http.StatusUnauthorized)
-		return
-	}
+	// mutedUntil is a UnixNano deadline; zero when not muted.
+	mutedUntil atomic.Int64
 
-	claims, err := auth.ValidateToken(tokenString)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		log.Printf("Invalid token: %v", err)
-		return
-	}
+	// closeMessage is the close frame writePump sends once send is closed;
+	// it is set before closing send.
+	closeMessage []byte
+}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println(err)
-		return
+// framePermissions lists the permission required to send each frame type.
+// Frames not listed here are open to every authenticated client.
+var framePermissions = map[string]auth.Permission{
+	"chat_message":   auth.PermSendMessages,
+	"delete_message": auth.PermDeleteOwnMessage,
+	"kick_user":      auth.PermKickUsers,
+	"mute_user":      auth.PermMuteUsers,
+	"unmute_user":    auth.PermMuteUsers,
+	"ban_user":       auth.PermBanUsers,
+	"unban_user":     auth.PermBanUsers,
+}
+
+func (c *Client) info() ConnectionInfo {
+	info := ConnectionInfo{
+		UserID:      c.userID,
+		Username:    c.username,
+		Role:        c.role,
+		IP:          c.ip,
+		UserAgent:   c.userAgent,
+		Transport:   c.transport,
+		Encoding:    c.encoding,
+		ConnectedAt: c.connectedAt,
 	}
+	if until := c.mutedUntil.Load(); until > time.Now().UnixNano() {
+		t := time.Unix(0, until)
+		info.MutedUntil = &t
+	}
+	return info
+}
 
-	client := &Client{
-		hub:      hub,
-		conn:     conn,
-		send:     make(chan []byte, 256),
-		userID:   claims.UserID,
-		username: claims.Username,
+// sendError queues an error frame for this client only. The frame goes
+// through the hub to the client's shard, which owns send and may already
+// have closed it; it is dropped when the hub is backed up.
+func (c *Client) sendError(code, message string) {
+	select {
+	case c.hub.direct <- directFrame{client: c, frame: newFrame("error", ErrorPayload{Code: code, Message: message})}:
+	default:
 	}
-	client.hub.register <- client
+}
 
-	go client.writePump()
-	go client.readPump()
+// start registers the client and runs its pumps.
+func (c *Client) start() {
+	// Count the pumps before the client is visible to Shutdown, which
+	// waits for them.
+	c.hub.pumps.Add(2)
+	c.hub.register <- c
+	go c.writePump()
+	go c.readPump()
 }
 
 func (c *Client) readPump() {
 	defer func() {
 		c.hub.unregister <- c
 		c.conn.Close()
+		c.hub.pumps.Done()
 	}()
 	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 	for {
-		_, p, err := c.conn.ReadMessage()
+		messageType, p, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 			}
 			break
 		}
+		if messageType == websocket.BinaryMessage {
+			if p, err = msgpackToJSON(p); err != nil {
+				continue
+			}
+		}
 
 		var wsMessage WSMessage
 		if err := json.Unmarshal(p, &wsMessage); err != nil {
 			continue
 		}
 
+		if perm, ok := framePermissions[wsMessage.Type]; ok && !auth.RoleHasPermission(c.role, perm) {
+			c.sendError("forbidden", "You don't have permission to send "+wsMessage.Type)
+			continue
+		}
+
+		if handler, ok := moderationFrames[wsMessage.Type]; ok {
+			handler(c, wsMessage.Payload)
+			continue
+		}
+
 		if wsMessage.Type == "chat_message" {
+			if until := c.mutedUntil.Load(); until > time.Now().UnixNano() {
+				c.sendError("muted", "You are muted until "+time.Unix(0, until).Format(time.RFC3339))
+				continue
+			}
+
 			var content string
 			if payloadMap, ok := wsMessage.Payload.(map[string]interface{}); ok {
 				if contentVal, ok := payloadMap["content"].(string); ok {
@@ -111,18 +165,7 @@ func (c *Client) readPump() {
 				Timestamp: timestamp,
 			}
 
-			outgoingMsg := WSMessage{
-				Type:    "chat_message",
-				Payload: message,
-			}
-
-			messageBytes, err := json.Marshal(outgoingMsg)
-			if err != nil {
-				log.Printf("Error marshaling outgoing message: %v", err)
-				continue
-			}
-
-			c.hub.broadcast <- messageBytes
+			c.hub.broadcast <- newFrame("chat_message", message)
 
 			// Save message to database
 			c.hub.saveMessage(message)
@@ -135,6 +178,7 @@ func (c *Client) writePump() {
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
+		c.hub.pumps.Done()
 	}()
 	for {
 		select {
@@ -142,16 +186,20 @@ func (c *Client) writePump() {
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.conn.WriteMessage(websocket.CloseMessage, c.closeMessage)
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
+			data := message.encoded(c.encoding)
+			if data == nil {
+				continue
+			}
+			w, err := c.conn.NextWriter(messageType(c.encoding))
 			if err != nil {
 				return
 			}
 
-			w.Write(message)
+			w.Write(data)
 
 			if err := w.Close(); err != nil {
 				return
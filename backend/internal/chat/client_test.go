@@ -0,0 +1,62 @@
+package chat
+
+import (
+	"backend/internal/auth"
+	"testing"
+	"time"
+)
+
+func TestFramesNeedPermission(t *testing.T) {
+	mem, srv := startTestHub(t)
+	guest := addUser(t, mem, "guest")
+	if err := mem.SetRole(guest.ID, auth.RoleGuest); err != nil {
+		t.Fatal(err)
+	}
+	member := addUser(t, mem, "member")
+	guestConn, memberConn := dial(t, srv, guest), dial(t, srv, member)
+
+	for _, frameType := range []string{"chat_message", "kick_user", "ban_user"} {
+		frame := map[string]interface{}{"type": frameType, "payload": map[string]interface{}{"content": "hi", "user_id": member.ID}}
+		if err := guestConn.WriteJSON(frame); err != nil {
+			t.Fatal(err)
+		}
+		payload := readUntil(t, guestConn, "error")["payload"].(map[string]interface{})
+		if payload["code"] != "forbidden" {
+			t.Errorf("%s from a guest: got error %v, want forbidden", frameType, payload)
+		}
+	}
+
+	if err := memberConn.WriteJSON(map[string]interface{}{"type": "chat_message", "payload": map[string]interface{}{"content": "hello"}}); err != nil {
+		t.Fatal(err)
+	}
+	payload := readUntil(t, guestConn, "chat_message")["payload"].(map[string]interface{})
+	if payload["content"] != "hello" || payload["username"] != "member" {
+		t.Errorf("guest received %v", payload)
+	}
+}
+
+func TestErrorAfterKick(t *testing.T) {
+	mem, srv := startTestHub(t)
+	bob := addUser(t, mem, "bob")
+
+	// A client without a connection, so the test decides when its readPump
+	// would run.
+	c := &Client{hub: hub, send: make(chan *frame, 256), userID: bob.ID, username: bob.Username, role: bob.Role}
+	hub.register <- c
+	hub.kick <- bob.ID
+	deadline := time.After(2 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-c.send:
+		case <-deadline:
+			t.Fatal("kick did not close the client's send channel")
+		}
+	}
+
+	// readPump keeps handling frames until its next read fails.
+	c.sendError("forbidden", "You don't have permission to send kick_user")
+	c.sendError("muted", "You are muted")
+
+	alice := addUser(t, mem, "alice")
+	readUntil(t, dial(t, srv, alice), "online_count")
+}
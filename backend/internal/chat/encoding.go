@@ -0,0 +1,144 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// WebSocket subprotocols a client can ask for in Sec-WebSocket-Protocol.
+// Clients that ask for none get JSON text frames.
+const (
+	SubprotocolJSON    = "inboxly.json"
+	SubprotocolMsgpack = "inboxly.msgpack"
+)
+
+// Encodings frames are sent in.
+const (
+	EncodingJSON    = "json"
+	EncodingMsgpack = "msgpack"
+)
+
+// encodings maps each subprotocol to its encoding.
+var encodings = map[string]string{
+	SubprotocolJSON:    EncodingJSON,
+	SubprotocolMsgpack: EncodingMsgpack,
+}
+
+// encodingOf returns the encoding negotiated for conn.
+func encodingOf(conn *websocket.Conn) string {
+	if encoding, ok := encodings[conn.Subprotocol()]; ok {
+		return encoding
+	}
+	return EncodingJSON
+}
+
+func init() {
+	// A MessagePack frame is the JSON frame's document, so timestamps are
+	// RFC 3339 strings rather than the MessagePack timestamp extension.
+	msgpack.Register(time.Time{}, func(e *msgpack.Encoder, v reflect.Value) error {
+		return e.EncodeString(v.Interface().(time.Time).Format(time.RFC3339Nano))
+	}, nil)
+}
+
+// frameEncodings holds a frame's encodings other than JSON, each made the
+// first time a client needs it and then shared by every client.
+type frameEncodings struct {
+	msgpackOnce sync.Once
+	msgpack     []byte
+}
+
+// encoded returns f in the given encoding.
+func (f *frame) encoded(encoding string) []byte {
+	if encoding != EncodingMsgpack {
+		return f.data
+	}
+	f.msgpackOnce.Do(func() {
+		msg := f.msg
+		if msg == nil {
+			var err error
+			if msg, err = decodeFrame(f.data); err != nil {
+				log.Printf("Error decoding relayed frame: %v", err)
+				return
+			}
+		}
+		var err error
+		if f.msgpack, err = marshalMsgpack(msg); err != nil {
+			log.Printf("Error encoding frame as MessagePack: %v", err)
+		}
+	})
+	return f.msgpack
+}
+
+// messageType is the WebSocket message type frames in encoding are sent as.
+func messageType(encoding string) int {
+	if encoding == EncodingMsgpack {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// marshalMsgpack encodes msg with the keys of its JSON encoding; whole
+// numbers take the fewest bytes.
+func marshalMsgpack(msg *WSMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	enc.UseCompactFloats(true)
+	if err := enc.Encode(msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// payloadTypes makes an empty payload for each type of frame the server
+// sends.
+var payloadTypes = map[string]func() interface{}{
+	"chat_message":      func() interface{} { return new(Message) },
+	"user_joined":       func() interface{} { return new(UserJoined) },
+	"user_left":         func() interface{} { return new(UserLeft) },
+	"online_count":      func() interface{} { return new(int) },
+	"system":            func() interface{} { return new(SystemMessage) },
+	"error":             func() interface{} { return new(ErrorPayload) },
+	"server_restarting": func() interface{} { return new(ServerRestarting) },
+	"resync":            func() interface{} { return new(Resync) },
+}
+
+// decodeFrame turns a frame relayed from another instance back into its
+// payload type, so it is encoded like the frames of this one.
+func decodeFrame(data []byte) (*WSMessage, error) {
+	var raw struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	newPayload, ok := payloadTypes[raw.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown frame type %q", raw.Type)
+	}
+	payload := newPayload()
+	if err := json.Unmarshal(raw.Payload, payload); err != nil {
+		return nil, fmt.Errorf("decoding %s frame: %w", raw.Type, err)
+	}
+	return &WSMessage{Type: raw.Type, Payload: payload}, nil
+}
+
+// msgpackToJSON converts a frame received from a client to JSON, so it is
+// handled like a text frame.
+func msgpackToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
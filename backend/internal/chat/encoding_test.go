@@ -0,0 +1,145 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// sampleFrames has a frame of every type the server sends.
+func sampleFrames() []*frame {
+	at := time.Date(2026, 3, 1, 12, 30, 15, 123456789, time.FixedZone("CET", 3600))
+	return []*frame{
+		newFrame("chat_message", Message{UserID: 7, Username: "alice", Content: "héllo", Timestamp: at}),
+		newFrame("user_joined", UserJoined{Username: "alice", Message: "alice joined the chat"}),
+		newFrame("user_left", UserLeft{Username: "alice", Message: "alice left the chat"}),
+		newFrame("online_count", 300),
+		newFrame("system", SystemMessage{Action: ActionMute, Username: "bob", Moderator: "alice", ExpiresAt: &at, Message: "bob was muted"}),
+		newFrame("system", SystemMessage{Action: ActionKick, Username: "bob", Moderator: "alice", Message: "bob was kicked"}),
+		newFrame("error", ErrorPayload{Code: "muted", Message: "You are muted"}),
+		restartFrame,
+		resyncFrame,
+	}
+}
+
+// canonical re-encodes a decoded document as JSON with sorted keys.
+func canonical(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	data, err = json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestMsgpackFramesMatchJSON(t *testing.T) {
+	types := map[string]bool{}
+	for _, f := range sampleFrames() {
+		types[f.msg.Type] = true
+		encoded := f.encoded(EncodingMsgpack)
+
+		var fromMsgpack, fromJSON interface{}
+		if err := msgpack.Unmarshal(encoded, &fromMsgpack); err != nil {
+			t.Fatalf("%s: %v", f.data, err)
+		}
+		if err := json.Unmarshal(f.data, &fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := canonical(t, fromMsgpack), canonical(t, fromJSON); got != want {
+			t.Errorf("MessagePack frame is\n%s\nwant\n%s", got, want)
+		}
+
+		relayed := &frame{data: f.data}
+		if !bytes.Equal(relayed.encoded(EncodingMsgpack), encoded) {
+			t.Errorf("relayed %s frame is encoded differently", f.msg.Type)
+		}
+	}
+	if len(types) != len(payloadTypes) {
+		t.Errorf("samples cover %d frame types, payloadTypes has %d", len(types), len(payloadTypes))
+	}
+}
+
+func TestMsgpackKeepsTimestampsAndIntegers(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 30, 15, 0, time.UTC)
+	f := newFrame("chat_message", Message{UserID: 7, Username: "alice", Timestamp: at})
+
+	var doc struct {
+		Payload map[string]interface{} `msgpack:"payload"`
+	}
+	if err := msgpack.Unmarshal(f.encoded(EncodingMsgpack), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if ts, ok := doc.Payload["timestamp"].(string); !ok || ts != "2026-03-01T12:30:15Z" {
+		t.Errorf("timestamp = %#v, want an RFC 3339 string", doc.Payload["timestamp"])
+	}
+	if id, ok := doc.Payload["user_id"].(int8); !ok || id != 7 {
+		t.Errorf("user_id = %#v, want a compact integer", doc.Payload["user_id"])
+	}
+}
+
+func TestDecodeFrameRejectsUnknownTypes(t *testing.T) {
+	if _, err := decodeFrame([]byte(`{"type":"teleport","payload":{}}`)); err == nil {
+		t.Error("decoded a frame of an unknown type")
+	}
+	relayed := &frame{data: []byte(`{"type":"teleport","payload":{}}`)}
+	if data := relayed.encoded(EncodingMsgpack); data != nil {
+		t.Errorf("encoded an unknown frame as %x", data)
+	}
+}
+
+func TestMsgpackConnection(t *testing.T) {
+	mem, srv := startTestHub(t)
+	alice := addUser(t, mem, "alice")
+	conn := dial(t, srv, alice, SubprotocolMsgpack, SubprotocolJSON)
+	if conn.Subprotocol() != SubprotocolMsgpack {
+		t.Fatalf("negotiated %q, want %q", conn.Subprotocol(), SubprotocolMsgpack)
+	}
+
+	send, err := msgpack.Marshal(map[string]interface{}{
+		"type":    "chat_message",
+		"payload": map[string]interface{}{"content": "hello"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, send); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind != websocket.BinaryMessage {
+			t.Fatalf("got a text frame %s", data)
+		}
+		var frame struct {
+			Type    string      `msgpack:"type"`
+			Payload interface{} `msgpack:"payload"`
+		}
+		if err := msgpack.Unmarshal(data, &frame); err != nil {
+			t.Fatalf("frame %x: %v", data, err)
+		}
+		if frame.Type == "chat_message" {
+			payload, _ := frame.Payload.(map[string]interface{})
+			if payload["content"] != "hello" || payload["username"] != "alice" {
+				t.Errorf("got %v", frame.Payload)
+			}
+			return
+		}
+	}
+}
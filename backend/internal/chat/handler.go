@@ -1,87 +1,171 @@
 package chat
 
 import (
-	"backend/internal/database"
+	"backend/internal/auth"
+	"backend/internal/cluster"
 	"backend/internal/models"
+	"backend/internal/persist"
+	"backend/internal/store"
 	"backend/pkg/utils"
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-var hub = NewHub()
+var hub *Hub
 
-func init() {
+// Start creates the hub and runs it in the background. It must be called
+// before any chat handler is served. HUB_SHARDS sets the number of delivery
+// shards (default: one per CPU) and HUB_SLOW_CONSUMER the slow-consumer
+// policy (default: disconnect).
+func Start(messages *MessageCache, writer *persist.Writer, broker cluster.Broker) {
+	shards, _ := strconv.Atoi(os.Getenv("HUB_SHARDS"))
+	policy, err := ParseSlowConsumerPolicy(os.Getenv("HUB_SLOW_CONSUMER"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	hub = NewHub(messages, writer, broker, HubOptions{Shards: shards, SlowConsumer: policy})
 	go hub.Run()
+	go hub.polls.expire(hub)
 }
 
 func WebSocketHandler(c *gin.Context) {
+	client := admit(c)
+	if client == nil {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+
+	client.conn = conn
+	client.transport = TransportWebSocket
+	client.encoding = encodingOf(conn)
+	log.Printf("WebSocket connection established for user %s (ID: %v, %s)", client.username, client.userID, client.encoding)
+	client.start()
+}
+
+// admit checks that the authenticated user may join the chat and returns
+// their client, not yet registered. Otherwise it writes the error response
+// and returns nil.
+func admit(c *gin.Context) *Client {
+	if hub.draining.Load() {
+		utils.ErrorResponse(c, http.StatusServiceUnavailable, "Server is restarting", "server_restarting")
+		return nil
+	}
+
 	userID, exists := c.Get("user_id")
 	if !exists {
-		log.Println("WebSocket connection failed: user_id not found in context")
+		log.Println("Chat connection failed: user_id not found in context")
 		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated", "missing_user")
-		return
+		return nil
 	}
 
 	username, exists := c.Get("username")
 	if !exists {
-		log.Println("WebSocket connection failed: username not found in context")
+		log.Println("Chat connection failed: username not found in context")
 		utils.ErrorResponse(c, http.StatusUnauthorized, "Username not found", "missing_username")
-		return
+		return nil
 	}
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err := auth.CheckAccountActive(userID.(int)); err != nil {
+		if errors.Is(err, auth.ErrAccountDisabled) {
+			utils.ErrorResponse(c, http.StatusForbidden, "Account disabled", "account_disabled")
+			return nil
+		}
+		var banned *auth.BannedError
+		if errors.As(err, &banned) {
+			utils.ErrorResponse(c, http.StatusForbidden, banned.Error(), "account_banned")
+			return nil
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to check account status", err.Error())
+		return nil
+	}
+
+	mute, err := auth.ActiveSanction(userID.(int), auth.SanctionMute)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
-		return
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to check account status", err.Error())
+		return nil
 	}
 
-	log.Printf("WebSocket connection established for user %s (ID: %v)", username, userID)
 	client := &Client{
 		hub:      hub,
-		conn:     conn,
-		send:     make(chan []byte, 256),
+		send:     make(chan *frame, 256),
 		userID:   userID.(int),
 		username: username.(string),
-	}
-
-	client.hub.register <- client
+		role:     c.GetString("role"),
+		ip:       c.ClientIP(),
 
-	go client.writePump()
-	go client.readPump()
+		userAgent:   c.Request.UserAgent(),
+		connectedAt: time.Now(),
+		encoding:    EncodingJSON,
+	}
+	if mute != nil && mute.ExpiresAt != nil {
+		client.mutedUntil.Store(mute.ExpiresAt.UnixNano())
+	}
+	return client
 }
 
+// GetMessagesHandler returns the newest messages, oldest first. Older pages
+// are fetched with before, the ID of the oldest message already loaded.
+// Recent pages are served from the hub's message cache.
 func GetMessagesHandler(c *gin.Context) {
-	query := `
-		SELECT id, user_id, username, content, created_at 
-		FROM messages 
-		ORDER BY created_at DESC 
-		LIMIT 50
-	`
-
-	rows, err := database.DB.Query(query)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	var messages []models.Message
+	var err error
+	if before := c.Query("before"); before != "" {
+		id, convErr := strconv.Atoi(before)
+		if convErr != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid before parameter", "invalid_before")
+			return
+		}
+		messages, err = hub.messages.MessagesBefore(id, limit)
+		if err == store.ErrNotFound {
+			utils.ErrorResponse(c, http.StatusNotFound, "Message not found", "message_not_found")
+			return
+		}
+	} else {
+		messages, err = hub.messages.RecentMessages(limit)
+	}
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch messages", err.Error())
 		return
 	}
-	defer rows.Close()
 
-	var messages []models.Message
-	for rows.Next() {
-		var msg models.Message
-		err := rows.Scan(&msg.ID, &msg.UserID, &msg.Username, &msg.Content, &msg.CreatedAt)
-		if err != nil {
-			log.Printf("Error scanning message: %v", err)
-			continue
-		}
-		messages = append(messages, msg)
+	utils.SuccessResponse(c, "Messages retrieved successfully", messages)
+}
+
+// SearchMessagesHandler returns messages containing every word of the q
+// parameter, newest first.
+func SearchMessagesHandler(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Missing search query", "missing_query")
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
 	}
 
-	// Reverse to get chronological order
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
+	messages, err := hub.messages.SearchMessages(query, limit)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to search messages", err.Error())
+		return
 	}
 
 	utils.SuccessResponse(c, "Messages retrieved successfully", messages)
@@ -108,21 +192,76 @@ func SendMessageHandler(c *gin.Context) {
 		return
 	}
 
-	query := `INSERT INTO messages (user_id, username, content, created_at) VALUES ($1, $2, $3, CURRENT_TIMESTAMP)`
-	result, err := database.DB.Exec(query, userID, username, req.Content)
-	if err != nil {
+	msg := models.Message{
+		UserID:   userID.(int),
+		Username: username.(string),
+		Content:  req.Content,
+	}
+	if err := hub.messages.SaveMessage(&msg); err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to save message", err.Error())
 		return
 	}
+	hub.broadcast <- newFrame("chat_message", Message{
+		UserID:    msg.UserID,
+		Username:  msg.Username,
+		Content:   msg.Content,
+		Timestamp: msg.CreatedAt,
+	})
 
-	id, _ := result.LastInsertId()
-	msg := models.Message{
-		ID:        int(id),
-		UserID:    userID.(int),
-		Username:  username.(string),
-		Content:   req.Content,
-		CreatedAt: time.Now(),
+	utils.SuccessResponse(c, "Message sent successfully", msg)
+}
+
+// UserConnections returns the live connections of a user, or of every user
+// when userID is 0.
+func UserConnections(userID int) []ConnectionInfo {
+	reply := make(chan []ConnectionInfo, 1)
+	hub.inspect <- inspectRequest{userID: userID, reply: reply}
+	return <-reply
+}
+
+// Shutdown stops accepting WebSocket connections, sends every client a
+// server_restarting frame and closes its connection with code 1012 after
+// the frames already queued for it are written. It returns once every
+// connection is closed and its last message is saved, or when ctx is done.
+func Shutdown(ctx context.Context) error {
+	hub.draining.Store(true)
+	done := make(chan struct{})
+	select {
+	case hub.shutdown <- done:
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
-	utils.SuccessResponse(c, "Message sent successfully", msg)
+	closed := make(chan struct{})
+	go func() {
+		<-done
+		hub.pumps.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SlowConsumerStats reports how often clients of this instance fell behind.
+func SlowConsumerStats() map[string]int64 {
+	return hub.slow.snapshot()
+}
+
+// PersistenceStats reports the asynchronous message writer's counters.
+func PersistenceStats() map[string]int64 {
+	return hub.writer.Stats()
+}
+
+// CacheStats reports the message cache's size and hit counters.
+func CacheStats() map[string]int64 {
+	return hub.messages.Stats()
+}
+
+// DisconnectUser closes all of a user's live connections.
+func DisconnectUser(userID int) {
+	hub.kick <- userID
 }
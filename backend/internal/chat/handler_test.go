@@ -0,0 +1,171 @@
+package chat
+
+import (
+	"backend/internal/audit"
+	"backend/internal/auth"
+	"backend/internal/cluster"
+	"backend/internal/models"
+	"backend/internal/persist"
+	"backend/internal/store"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// startTestHub runs the package hub on an in-memory store and serves the
+// chat routes. Requests authenticate as the user whose ID is in the user
+// query parameter.
+func startTestHub(t *testing.T) (*store.Memory, *httptest.Server) {
+	t.Helper()
+	mem := store.NewMemory()
+	auth.SetStore(mem)
+	audit.SetStore(mem)
+	broker := cluster.NewLocal().Join("test")
+	messages := NewMessageCache(mem, broker, 50)
+	writer, err := persist.New(messages, persist.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Start(messages, writer, broker)
+
+	r := gin.New()
+	chatGroup := r.Group("/api/chat", func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.Query("user"))
+		user, err := mem.GetUser(userID)
+		if err != nil {
+			c.AbortWithStatus(401)
+			return
+		}
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		c.Set("role", user.Role)
+	})
+	chatGroup.GET("/ws", WebSocketHandler)
+	chatGroup.GET("/stream", StreamHandler)
+	chatGroup.GET("/poll", PollHandler)
+	chatGroup.GET("/messages", GetMessagesHandler)
+	chatGroup.POST("/messages", SendMessageHandler)
+
+	srv := httptest.NewServer(r)
+	t.Cleanup(func() {
+		srv.Close()
+		hub.stop()
+		writer.Close()
+		broker.Close()
+	})
+	return mem, srv
+}
+
+func addUser(t *testing.T, mem *store.Memory, username string) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, Email: username + "@example.com"}
+	if err := mem.CreateUser(user, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// dial opens a chat WebSocket as user, asking for subprotocols if given.
+func dial(t *testing.T, srv *httptest.Server, user *models.User, subprotocols ...string) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: subprotocols}
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/chat/ws?user=" + strconv.Itoa(user.ID)
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readUntil reads JSON frames from conn until one has the given type.
+func readUntil(t *testing.T, conn *websocket.Conn, frameType string) map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", frameType, err)
+		}
+		var frame map[string]interface{}
+		if err := json.Unmarshal(data, &frame); err != nil {
+			t.Fatalf("frame %s: %v", data, err)
+		}
+		if frame["type"] == frameType {
+			return frame
+		}
+	}
+}
+
+func TestShutdownClosesConnections(t *testing.T) {
+	mem, srv := startTestHub(t)
+	alice, bob := addUser(t, mem, "alice"), addUser(t, mem, "bob")
+	conns := []*websocket.Conn{dial(t, srv, alice), dial(t, srv, bob)}
+	for _, conn := range conns {
+		readUntil(t, conn, "online_count")
+	}
+	resp, err := http.Get(srv.URL + "/api/chat/stream?user=" + strconv.Itoa(bob.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	stream := bufio.NewReader(resp.Body)
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(line, "online_count") {
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	for _, conn := range conns {
+		frame := readUntil(t, conn, "server_restarting")
+		if payload, _ := frame["payload"].(map[string]interface{}); payload["reconnect_after_ms"] == nil {
+			t.Errorf("server_restarting frame %v has no reconnect delay", frame)
+		}
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseServiceRestart {
+			t.Errorf("got %v, want close code 1012", err)
+		}
+	}
+
+	// The stream ends after the restart frame, with a retry delay.
+	rest, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restart := strings.Index(string(rest), "server_restarting")
+	if restart < 0 || !strings.Contains(string(rest[restart:]), "\nretry: ") {
+		t.Errorf("stream ended with %q", rest)
+	}
+
+	// New connections are turned away while draining.
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/chat/ws?user=" + strconv.Itoa(alice.ID)
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp == nil || resp.StatusCode != 503 {
+		t.Errorf("dial while draining: %v", err)
+	}
+}
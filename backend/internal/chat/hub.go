@@ -1,128 +1,453 @@
 package chat
 
 import (
-	"backend/internal/database"
+	"backend/internal/cluster"
+	"backend/internal/models"
+	"backend/internal/persist"
 	"encoding/json"
 	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// presenceInterval is how often the hub reports its connection count to
+	// other instances; reports older than presenceTTL are discarded.
+	presenceInterval = 10 * time.Second
+	presenceTTL      = 3 * presenceInterval
+)
+
+// Joins, leaves and online count changes are collected for presenceDelay
+// and sent together, so a connect storm costs one online_count per window
+// rather than one per connection. A window with more than
+// maxPresenceFrames joins and leaves only sends the count.
+const (
+	presenceDelay     = 200 * time.Millisecond
+	maxPresenceFrames = 20
+)
+
+// Clients are asked to wait reconnectAfter plus a random share of
+// reconnectJitter before reconnecting, so they do not all arrive at once.
+const (
+	reconnectAfter  = time.Second
+	reconnectJitter = 5 * time.Second
 )
 
+var closeServiceRestart = websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restarting")
+
+// hubBuffer is the capacity of the hub's broadcast and unregister queues.
+const hubBuffer = 1024
+
+// Hub tracks the clients of this instance. Run owns membership, presence
+// and the broker; delivery to clients is spread over shards, each with its
+// own goroutine, so Run never loops over every connection.
 type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
+	clients    map[*Client]*shard
+	shards     []*shard
+	next       int
+	broadcast  chan *frame
 	register   chan *Client
 	unregister chan *Client
+	direct     chan directFrame
+	kick       chan int
+	mute       chan muteRequest
+	inspect    chan inspectRequest
+	shutdown   chan chan struct{}
+	quit       chan chan struct{}
+	messages   *MessageCache
+	writer     *persist.Writer
+
+	// draining is set once shutdown starts; no new clients are accepted.
+	draining atomic.Bool
+	// pumps counts running readPump and writePump goroutines.
+	pumps sync.WaitGroup
+
+	// broker relays events to the hubs of other instances.
+	broker cluster.Broker
+	remote map[string]presence
+
+	// journal numbers broadcast frames and keeps the latest for clients
+	// that resume a stream.
+	journal *journal
+
+	// polls holds long-poll sessions between requests.
+	polls *pollSessions
+
+	// countFrame caches the online_count frame for countValue.
+	countFrame *frame
+	countValue int
+
+	// presenceDue fires when the collected presence updates are sent; it
+	// is nil when there are none. presenceFrames are the user_joined and
+	// user_left frames collected so far, and presenceStale is set when
+	// other instances have not been told the local count.
+	presenceDue    <-chan time.Time
+	presenceFrames []*frame
+	presenceStale  bool
+
+	slow slowConsumerCounters
+}
+
+// HubOptions tunes delivery to local clients.
+type HubOptions struct {
+	// Shards is the number of delivery workers; one per CPU when 0.
+	Shards int
+	// SlowConsumer is applied when a client's send buffer is full;
+	// SlowConsumerDisconnect when empty.
+	SlowConsumer SlowConsumerPolicy
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		broadcast:  make(chan []byte),
+// presence is the last connection count reported by another instance.
+type presence struct {
+	connections int
+	seen        time.Time
+}
+
+// directFrame is a frame for one client, such as an error in reply to a
+// frame it sent.
+type directFrame struct {
+	client *Client
+	frame  *frame
+}
+
+type muteRequest struct {
+	userID int
+	until  time.Time
+}
+
+// inspectRequest asks the hub for the live connections of a user (or of
+// everyone when userID is 0).
+type inspectRequest struct {
+	userID int
+	reply  chan []ConnectionInfo
+}
+
+func NewHub(messages *MessageCache, writer *persist.Writer, broker cluster.Broker, opts HubOptions) *Hub {
+	if opts.Shards <= 0 {
+		opts.Shards = runtime.GOMAXPROCS(0)
+	}
+	if opts.SlowConsumer == "" {
+		opts.SlowConsumer = SlowConsumerDisconnect
+	}
+	h := &Hub{
+		messages:  messages,
+		writer:    writer,
+		broker:    broker,
+		broadcast: make(chan *frame, hubBuffer),
+		// register stays unbuffered so a client is registered before its
+		// readPump can queue the matching unregister.
 		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
+		unregister: make(chan *Client, hubBuffer),
+		direct:     make(chan directFrame, hubBuffer),
+		kick:       make(chan int),
+		mute:       make(chan muteRequest),
+		inspect:    make(chan inspectRequest),
+		shutdown:   make(chan chan struct{}),
+		quit:       make(chan chan struct{}),
+		clients:    make(map[*Client]*shard),
+		remote:     make(map[string]presence),
+		journal:    newJournal(),
+		polls:      newPollSessions(),
+	}
+	for i := 0; i < opts.Shards; i++ {
+		s := newShard(opts.SlowConsumer, &h.slow, h.unregister)
+		h.shards = append(h.shards, s)
+		go s.run()
 	}
+	return h
 }
 
-func (h *Hub) broadcastUserCount() {
+// onlineCount is the number of connections across all instances.
+func (h *Hub) onlineCount() int {
 	count := len(h.clients)
-	
-	message := WSMessage{
-		Type:    "online_count",
-		Payload: count,
+	for _, p := range h.remote {
+		count += p.connections
+	}
+	return count
+}
+
+// newFrame marshals a frame once so it can be shared by every client. The
+// frame is kept for the other encodings.
+func newFrame(frameType string, payload interface{}) *frame {
+	msg := &WSMessage{Type: frameType, Payload: payload}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling %s frame: %v", frameType, err)
+	}
+	return &frame{data: data, msg: msg}
+}
+
+func (h *Hub) userCountFrame() *frame {
+	if count := h.onlineCount(); h.countFrame == nil || count != h.countValue {
+		h.countFrame = newFrame("online_count", count)
+		h.countValue = count
+	}
+	return h.countFrame.copy()
+}
+
+func (h *Hub) broadcastUserCount() {
+	h.deliver(h.userCountFrame())
+}
+
+// deliver records a frame in the journal and hands it to every shard for
+// its local clients.
+func (h *Hub) deliver(f *frame) {
+	h.journal.add(f)
+	for _, s := range h.shards {
+		s.ops <- shardOp{frame: f}
+	}
+}
+
+// fanOut delivers a frame locally and to clients of other instances.
+func (h *Hub) fanOut(f *frame) {
+	h.deliver(f)
+	h.publish(cluster.Event{Kind: cluster.KindBroadcast, Frame: f.data})
+}
+
+func (h *Hub) publish(event cluster.Event) {
+	h.broker.Publish(event)
+}
+
+// presenceChanged schedules reporting the new local count to other
+// instances and updating the online count of local clients.
+func (h *Hub) presenceChanged() {
+	h.presenceStale = true
+	h.countChanged()
+}
+
+// countChanged schedules updating the online count of local clients.
+func (h *Hub) countChanged() {
+	if h.presenceDue == nil {
+		h.presenceDue = time.After(presenceDelay)
+	}
+}
+
+// announce schedules a user_joined or user_left frame for every client.
+func (h *Hub) announce(f *frame) {
+	h.presenceFrames = append(h.presenceFrames, f)
+	h.presenceChanged()
+}
+
+// flushPresence sends the presence updates collected since the last flush.
+func (h *Hub) flushPresence() {
+	if len(h.presenceFrames) <= maxPresenceFrames {
+		for _, f := range h.presenceFrames {
+			h.fanOut(f)
+		}
+	}
+	if h.presenceStale {
+		h.publish(cluster.Event{Kind: cluster.KindPresence, Connections: len(h.clients)})
+	}
+	h.broadcastUserCount()
+	h.presenceDue, h.presenceFrames, h.presenceStale = nil, nil, false
+}
+
+// remove detaches a client; its shard closes send, which makes writePump
+// send a close frame and drop the connection. It reports whether the
+// client was still registered.
+func (h *Hub) remove(c *Client) bool {
+	s, ok := h.clients[c]
+	if !ok {
+		return false
 	}
-	messageBytes, _ := json.Marshal(message)
+	delete(h.clients, c)
+	s.ops <- shardOp{leave: c}
+	return true
+}
 
+func (h *Hub) disconnect(userID int) {
 	for c := range h.clients {
-		select {
-		case c.send <- messageBytes:
-		default:
-			close(c.send)
-			delete(h.clients, c)
+		if c.userID == userID {
+			h.remove(c)
+		}
+	}
+}
+
+func (h *Hub) setMute(userID int, until int64) {
+	for c := range h.clients {
+		if c.userID == userID {
+			c.mutedUntil.Store(until)
+		}
+	}
+}
+
+var restartFrame = newFrame("server_restarting", ServerRestarting{
+	Message:           "The server is restarting, please reconnect",
+	ReconnectAfterMs:  reconnectAfter.Milliseconds(),
+	ReconnectJitterMs: reconnectJitter.Milliseconds(),
+})
+
+// restartOp tells c the server is restarting and closes its connection with
+// code 1012 once the frames already queued for it are written.
+func restartOp(c *Client) shardOp {
+	return shardOp{leave: c, frame: restartFrame, direct: true, closeMessage: closeServiceRestart}
+}
+
+// closeAll disconnects every client for a restart.
+func (h *Hub) closeAll() {
+	for c, s := range h.clients {
+		delete(h.clients, c)
+		s.ops <- restartOp(c)
+	}
+	// Other instances should learn of the departure before this one exits.
+	h.presenceStale = true
+	h.flushPresence()
+}
+
+// apply handles an event published by another instance.
+func (h *Hub) apply(event cluster.Event) {
+	switch event.Kind {
+	case cluster.KindBroadcast:
+		h.deliver(&frame{data: event.Frame})
+	case cluster.KindKick:
+		h.disconnect(event.UserID)
+		h.presenceChanged()
+	case cluster.KindMute:
+		h.setMute(event.UserID, event.Until)
+	case cluster.KindPresence:
+		previous, known := h.remote[event.Origin]
+		h.remote[event.Origin] = presence{connections: event.Connections, seen: time.Now()}
+		if !known || previous.connections != event.Connections {
+			h.countChanged()
+		}
+	case cluster.KindMessages:
+		h.messages.apply(event)
+	case cluster.KindResync:
+		// Broadcasts may have been missed too, so clients reload history.
+		h.messages.apply(event)
+		h.deliver(resyncFrame.copy())
+		h.publish(cluster.Event{Kind: cluster.KindPresence, Connections: len(h.clients)})
+	}
+}
+
+// expirePresence forgets instances that stopped reporting and reports
+// whether any were removed.
+func (h *Hub) expirePresence() bool {
+	expired := false
+	for origin, p := range h.remote {
+		if time.Since(p.seen) > presenceTTL {
+			delete(h.remote, origin)
+			expired = true
 		}
 	}
+	return expired
 }
 
 func (h *Hub) Run() {
+	remote := h.broker.Events()
+	heartbeat := time.NewTicker(presenceInterval)
+	defer heartbeat.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
-			h.clients[client] = true
+			s := h.shards[h.next%len(h.shards)]
+			h.next++
+			if h.draining.Load() {
+				// The client was upgraded while Shutdown ran.
+				s.ops <- shardOp{join: client}
+				s.ops <- restartOp(client)
+				continue
+			}
+			h.clients[client] = s
 
 			// Send current user count immediately to the new client
-			countMsg := WSMessage{
-				Type:    "online_count",
-				Payload: len(h.clients),
-			}
-			countBytes, _ := json.Marshal(countMsg)
-			client.send <- countBytes
+			s.ops <- shardOp{join: client, frame: h.userCountFrame(), direct: true}
 
-			// Notify all clients that a user joined
-			userJoined := UserJoined{
+			// Notify all clients that a user joined, and update the
+			// online count
+			h.announce(newFrame("user_joined", UserJoined{
 				Username: client.username,
 				Message:  client.username + " joined the chat",
+			}))
+
+		case client := <-h.unregister:
+			if h.remove(client) {
+				// Notify all clients that a user left, and update the online
+				// count
+				h.announce(newFrame("user_left", UserLeft{
+					Username: client.username,
+					Message:  client.username + " left the chat",
+				}))
 			}
-			message := WSMessage{
-				Type:    "user_joined",
-				Payload: userJoined,
-			}
-			messageBytes, _ := json.Marshal(message)
 
-			for c := range h.clients {
-				select {
-				case c.send <- messageBytes:
-				default:
-					close(c.send)
-					delete(h.clients, c)
-				}
+		case <-h.presenceDue:
+			h.flushPresence()
+
+		case d := <-h.direct:
+			// Clients that were removed have their send channel closed.
+			if s, ok := h.clients[d.client]; ok {
+				s.ops <- shardOp{to: d.client, frame: d.frame}
 			}
 
-			// Update online count after a user joins
-			h.broadcastUserCount()
+		case userID := <-h.kick:
+			h.disconnect(userID)
+			h.publish(cluster.Event{Kind: cluster.KindKick, UserID: userID})
+			h.presenceChanged()
 
-		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+		case req := <-h.mute:
+			var until int64
+			if !req.until.IsZero() {
+				until = req.until.UnixNano()
+			}
+			h.setMute(req.userID, until)
+			h.publish(cluster.Event{Kind: cluster.KindMute, UserID: req.userID, Until: until})
 
-				// Notify all clients that a user left
-				userLeft := UserLeft{
-					Username: client.username,
-					Message:  client.username + " left the chat",
-				}
-				message := WSMessage{
-					Type:    "user_left",
-					Payload: userLeft,
-				}
-				messageBytes, _ := json.Marshal(message)
-
-				for c := range h.clients {
-					select {
-					case c.send <- messageBytes:
-					default:
-						close(c.send)
-						delete(h.clients, c)
-					}
+		case req := <-h.inspect:
+			connections := []ConnectionInfo{}
+			for c := range h.clients {
+				if req.userID == 0 || c.userID == req.userID {
+					connections = append(connections, c.info())
 				}
+			}
+			req.reply <- connections
 
-				// Update online count after a user leaves
-				h.broadcastUserCount()
+		case done := <-h.shutdown:
+			h.closeAll()
+			close(done)
+
+		case done := <-h.quit:
+			for _, s := range h.shards {
+				close(s.ops)
 			}
+			close(done)
+			return
 
 		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
+			h.fanOut(message)
+
+		case event := <-remote:
+			h.apply(event)
+
+		case <-heartbeat.C:
+			h.publish(cluster.Event{Kind: cluster.KindPresence, Connections: len(h.clients)})
+			if h.expirePresence() {
+				h.countChanged()
 			}
 		}
 	}
 }
 
+// stop ends Run and the shard goroutines of a hub that is being thrown
+// away, such as a benchmark's. Registered clients are left as they are.
+func (h *Hub) stop() {
+	done := make(chan struct{})
+	h.quit <- done
+	<-done
+}
+
+// saveMessage hands message to the asynchronous writer.
 func (h *Hub) saveMessage(message Message) {
-	query := `INSERT INTO messages (user_id, username, content, created_at) VALUES ($1, $2, $3, $4)`
-	_, err := database.DB.Exec(query, message.UserID, message.Username, message.Content, message.Timestamp)
+	err := h.writer.Save(models.Message{
+		UserID:    message.UserID,
+		Username:  message.Username,
+		Content:   message.Content,
+		CreatedAt: message.Timestamp,
+	})
 	if err != nil {
 		log.Printf("Error saving message: %v", err)
 	}
@@ -0,0 +1,174 @@
+package chat
+
+import (
+	"backend/internal/cluster"
+	"backend/internal/models"
+	"backend/internal/store"
+	"testing"
+	"time"
+)
+
+// newTestHub returns a hub for origin on bus, without a writer and not
+// running, so tests drive it directly.
+func newTestHub(t *testing.T, bus *cluster.Local, origin string) *Hub {
+	t.Helper()
+	broker := bus.Join(origin)
+	t.Cleanup(func() { broker.Close() })
+	messages := NewMessageCache(store.NewMemory(), broker, 10)
+	h := NewHub(messages, nil, broker, HubOptions{Shards: 1})
+	// Run is not running, so stop the shards directly.
+	t.Cleanup(func() {
+		for _, s := range h.shards {
+			close(s.ops)
+		}
+	})
+	return h
+}
+
+// addClients registers n placeholder clients without running the hub.
+func addClients(h *Hub, n int) {
+	for i := 0; i < n; i++ {
+		c := &Client{hub: h, send: make(chan *frame, 256), userID: i + 1, username: "test"}
+		h.shards[0].ops <- shardOp{join: c}
+		h.clients[c] = h.shards[0]
+	}
+}
+
+// nextEvent returns the next event h received from other instances.
+func nextEvent(t *testing.T, h *Hub) cluster.Event {
+	t.Helper()
+	select {
+	case event := <-h.broker.Events():
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("%s received no event", h.broker.Origin())
+		return cluster.Event{}
+	}
+}
+
+func TestHubPresenceAcrossInstances(t *testing.T) {
+	bus := cluster.NewLocal()
+	a, b := newTestHub(t, bus, "a"), newTestHub(t, bus, "b")
+
+	addClients(a, 2)
+	a.presenceChanged()
+	a.flushPresence()
+	event := nextEvent(t, b)
+	if event.Origin != "a" || event.Kind != cluster.KindPresence || event.Connections != 2 {
+		t.Fatalf("b received %+v", event)
+	}
+	b.apply(event)
+	addClients(b, 1)
+	if got := b.onlineCount(); got != 3 {
+		t.Errorf("online count on b = %d, want 3", got)
+	}
+
+	// Hubs don't hear their own events.
+	select {
+	case event := <-a.broker.Events():
+		t.Errorf("a received its own %+v", event)
+	default:
+	}
+
+	// Instances that stop reporting drop out of the count.
+	b.remote["a"] = presence{connections: 2, seen: time.Now().Add(-presenceTTL - time.Second)}
+	if !b.expirePresence() {
+		t.Error("stale presence not expired")
+	}
+	if got := b.onlineCount(); got != 1 {
+		t.Errorf("online count on b after expiry = %d, want 1", got)
+	}
+}
+
+func TestHubCoalescesPresence(t *testing.T) {
+	bus := cluster.NewLocal()
+	a, b := newTestHub(t, bus, "a"), newTestHub(t, bus, "b")
+	addClients(a, 1)
+	var observer *Client
+	for c := range a.clients {
+		observer = c
+	}
+	joined := func(n int) {
+		for i := 0; i < n; i++ {
+			a.announce(newFrame("user_joined", UserJoined{Username: "test"}))
+		}
+	}
+	// received returns the types of the frames queued for the observer.
+	received := func() []string {
+		var types []string
+		for {
+			select {
+			case f := <-observer.send:
+				types = append(types, f.msg.Type)
+			case <-time.After(100 * time.Millisecond):
+				return types
+			}
+		}
+	}
+
+	joined(3)
+	if a.presenceDue == nil {
+		t.Fatal("no flush scheduled")
+	}
+	a.flushPresence()
+	if got := received(); len(got) != 4 || got[3] != "online_count" {
+		t.Errorf("after 3 joins the observer received %v, want 3 user_joined and an online_count", got)
+	}
+	for i := 0; i < 3; i++ {
+		if event := nextEvent(t, b); event.Kind != cluster.KindBroadcast {
+			t.Errorf("b received %+v, want a user_joined broadcast", event)
+		}
+	}
+	if event := nextEvent(t, b); event.Kind != cluster.KindPresence {
+		t.Errorf("b received %+v, want one presence event", event)
+	}
+
+	// A storm only updates the count.
+	joined(maxPresenceFrames + 1)
+	a.flushPresence()
+	if got := received(); len(got) != 1 || got[0] != "online_count" {
+		t.Errorf("after a storm the observer received %v, want one online_count", got)
+	}
+	if event := nextEvent(t, b); event.Kind != cluster.KindPresence {
+		t.Errorf("b received %+v, want one presence event", event)
+	}
+	if a.presenceDue != nil || a.presenceFrames != nil || a.presenceStale {
+		t.Error("flushing left updates pending")
+	}
+}
+
+func TestHubResync(t *testing.T) {
+	bus := cluster.NewLocal()
+	a, b := newTestHub(t, bus, "a"), newTestHub(t, bus, "b")
+	addClients(b, 1)
+
+	if err := b.messages.SaveMessages([]models.Message{{UserID: 1, Username: "alice", Content: "hi", CreatedAt: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	if !b.messages.ensureLoaded() {
+		t.Fatal("cache not loaded")
+	}
+	// Drain the messages event b published for the save.
+	if event := nextEvent(t, a); event.Kind != cluster.KindMessages {
+		t.Fatalf("a received %+v", event)
+	}
+
+	// After a broker reconnect b may have missed events: it reloads its
+	// cache and reports its presence again so others relearn its count.
+	b.apply(cluster.Event{Kind: cluster.KindResync})
+	b.messages.mu.RLock()
+	loaded := b.messages.loaded
+	b.messages.mu.RUnlock()
+	if loaded {
+		t.Error("cache still loaded after resync")
+	}
+	event := nextEvent(t, a)
+	if event.Origin != "b" || event.Kind != cluster.KindPresence || event.Connections != 1 {
+		t.Errorf("a received %+v, want b's presence", event)
+	}
+	for c := range b.clients {
+		if got := <-c.send; got.msg == nil || got.msg.Type != "resync" {
+			t.Errorf("client received %s, want a resync frame", got.data)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package chat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// journalSize is how many broadcast frames the hub keeps for clients that
+// resume after a reconnect.
+const journalSize = 1024
+
+// frame is an encoded frame queued for clients. The hub encodes a
+// broadcast once per encoding and every client shares it.
+type frame struct {
+	// seq numbers broadcast frames in the hub's journal; frames for a
+	// single client have none.
+	seq uint64
+	// data is the JSON encoding.
+	data []byte
+	// msg is the frame data was encoded from. Frames relayed from other
+	// instances only exist as JSON and have none.
+	msg *WSMessage
+	frameEncodings
+}
+
+// copy returns a new frame with f's contents, for the journal to number
+// when f is delivered again.
+func (f *frame) copy() *frame {
+	return &frame{data: f.data, msg: f.msg}
+}
+
+// journal keeps the most recent broadcast frames. Frames are numbered from
+// 1 in delivery order; epoch tells this hub's numbers apart from those of
+// an earlier run or another instance.
+type journal struct {
+	epoch string
+
+	mu     sync.RWMutex
+	frames [journalSize]*frame
+	last   uint64
+}
+
+func newJournal() *journal {
+	return &journal{epoch: strconv.FormatInt(time.Now().UnixNano(), 36)}
+}
+
+// add numbers f and records it. Only Run calls it.
+func (j *journal) add(f *frame) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.last++
+	f.seq = j.last
+	j.frames[f.seq%journalSize] = f
+}
+
+// since returns the frames after seq. It reports false if some of them are
+// no longer kept.
+func (j *journal) since(seq uint64) ([]*frame, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if seq > j.last || j.last-seq > journalSize {
+		return nil, false
+	}
+	frames := make([]*frame, 0, j.last-seq)
+	for s := seq + 1; s <= j.last; s++ {
+		frames = append(frames, j.frames[s%journalSize])
+	}
+	return frames, true
+}
+
+// id formats a frame's position as an event ID for clients.
+func (j *journal) id(f *frame) string {
+	return fmt.Sprintf("%s-%d", j.epoch, f.seq)
+}
+
+// parseID returns the position named by an event ID, or false if it is
+// malformed or was issued by another hub.
+func (j *journal) parseID(id string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != j.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
@@ -15,6 +15,55 @@ type WSMessage struct {
 	Payload interface{} `json:"payload"`
 }
 
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// SystemMessage announces moderation actions to the channel.
+type SystemMessage struct {
+	Action    string     `json:"action"`
+	Username  string     `json:"username"`
+	Moderator string     `json:"moderator"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Message   string     `json:"message"`
+}
+
+// ServerRestarting is sent to every client before the server shuts down.
+// Clients should reconnect after ReconnectAfterMs plus a random delay of up
+// to ReconnectJitterMs.
+type ServerRestarting struct {
+	Message           string `json:"message"`
+	ReconnectAfterMs  int64  `json:"reconnect_after_ms"`
+	ReconnectJitterMs int64  `json:"reconnect_jitter_ms"`
+}
+
+// Resync tells a client that resumed a stream that it missed events.
+type Resync struct {
+	Message string `json:"message"`
+}
+
+// Transports a client can be connected with.
+const (
+	TransportWebSocket = "websocket"
+	TransportStream    = "stream"
+	TransportPoll      = "poll"
+)
+
+// ConnectionInfo describes a live connection.
+type ConnectionInfo struct {
+	UserID      int        `json:"user_id"`
+	Username    string     `json:"username"`
+	Role        string     `json:"role"`
+	IP          string     `json:"ip"`
+	UserAgent   string     `json:"user_agent"`
+	Transport   string     `json:"transport"`
+	Encoding    string     `json:"encoding"`
+	ConnectedAt time.Time  `json:"connected_at"`
+	MutedUntil  *time.Time `json:"muted_until,omitempty"`
+}
+
 type ChatMessage struct {
 	Content string `json:"content"`
 }
@@ -0,0 +1,246 @@
+package chat
+
+import (
+	"backend/internal/audit"
+	"backend/internal/auth"
+	"backend/internal/models"
+	"backend/internal/store"
+	"backend/pkg/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ActionKick   = "kick"
+	ActionMute   = "mute"
+	ActionUnmute = "unmute"
+	ActionBan    = "ban"
+	ActionUnban  = "unban"
+)
+
+var actionPermissions = map[string]auth.Permission{
+	ActionKick:   auth.PermKickUsers,
+	ActionMute:   auth.PermMuteUsers,
+	ActionUnmute: auth.PermMuteUsers,
+	ActionBan:    auth.PermBanUsers,
+	ActionUnban:  auth.PermBanUsers,
+}
+
+var (
+	ErrForbidden        = errors.New("you don't have permission to do that")
+	ErrCannotModerate   = errors.New("cannot moderate yourself or a user with an equal or higher role")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrDurationRequired = errors.New("duration_seconds is required")
+	ErrNotSanctioned    = errors.New("user has no active sanction of that kind")
+)
+
+// Actor identifies the user performing a moderation action.
+type Actor struct {
+	UserID   int
+	Username string
+	Role     string
+	IP       string
+}
+
+// Moderate applies a moderation action, records it, updates live connections
+// and announces it to the channel.
+func Moderate(actor Actor, action string, req models.ModerationRequest) error {
+	perm, ok := actionPermissions[action]
+	if !ok {
+		return fmt.Errorf("unknown moderation action %q", action)
+	}
+	if !auth.RoleHasPermission(actor.Role, perm) {
+		return ErrForbidden
+	}
+
+	targetName, targetRole, err := auth.LookupUser(req.UserID)
+	if err == store.ErrNotFound {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if req.UserID == actor.UserID || auth.RoleRank(targetRole) >= auth.RoleRank(actor.Role) {
+		return ErrCannotModerate
+	}
+
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	var expiresAt *time.Time
+
+	switch action {
+	case ActionKick:
+		if _, err := auth.RecordSanction(req.UserID, auth.SanctionKick, req.Reason, actor.UserID, 0); err != nil {
+			return err
+		}
+		hub.kick <- req.UserID
+
+	case ActionMute:
+		if duration <= 0 {
+			return ErrDurationRequired
+		}
+		sanction, err := auth.RecordSanction(req.UserID, auth.SanctionMute, req.Reason, actor.UserID, duration)
+		if err != nil {
+			return err
+		}
+		expiresAt = sanction.ExpiresAt
+		hub.mute <- muteRequest{userID: req.UserID, until: *sanction.ExpiresAt}
+
+	case ActionUnmute:
+		lifted, err := auth.LiftSanctions(req.UserID, auth.SanctionMute)
+		if err != nil {
+			return err
+		}
+		if !lifted {
+			return ErrNotSanctioned
+		}
+		hub.mute <- muteRequest{userID: req.UserID}
+
+	case ActionBan:
+		sanction, err := auth.RecordSanction(req.UserID, auth.SanctionBan, req.Reason, actor.UserID, duration)
+		if err != nil {
+			return err
+		}
+		expiresAt = sanction.ExpiresAt
+		hub.kick <- req.UserID
+
+	case ActionUnban:
+		lifted, err := auth.LiftSanctions(req.UserID, auth.SanctionBan)
+		if err != nil {
+			return err
+		}
+		if !lifted {
+			return ErrNotSanctioned
+		}
+	}
+
+	log.Printf("Moderation: %s (ID: %d) applied %s to %s (ID: %d): %s", actor.Username, actor.UserID, action, targetName, req.UserID, req.Reason)
+	metadata := map[string]interface{}{"target_username": targetName, "reason": req.Reason}
+	if expiresAt != nil {
+		metadata["expires_at"] = expiresAt
+	}
+	audit.Record(audit.Event{
+		Action:        audit.ActionModeration + action,
+		ActorID:       actor.UserID,
+		ActorUsername: actor.Username,
+		TargetType:    "user",
+		TargetID:      strconv.Itoa(req.UserID),
+		IP:            actor.IP,
+		Metadata:      metadata,
+	})
+	hub.broadcastSystem(SystemMessage{
+		Action:    action,
+		Username:  targetName,
+		Moderator: actor.Username,
+		Reason:    req.Reason,
+		ExpiresAt: expiresAt,
+		Message:   systemText(action, targetName, req.Reason),
+	})
+	return nil
+}
+
+func systemText(action, username, reason string) string {
+	verbs := map[string]string{
+		ActionKick:   "was kicked",
+		ActionMute:   "was muted",
+		ActionUnmute: "was unmuted",
+		ActionBan:    "was banned",
+		ActionUnban:  "was unbanned",
+	}
+	text := username + " " + verbs[action]
+	if reason != "" {
+		text += ": " + reason
+	}
+	return text
+}
+
+func (h *Hub) broadcastSystem(msg SystemMessage) {
+	h.broadcast <- newFrame("system", msg)
+}
+
+func moderationErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden, "forbidden"
+	case errors.Is(err, ErrCannotModerate):
+		return http.StatusForbidden, "cannot_moderate"
+	case errors.Is(err, ErrUserNotFound):
+		return http.StatusNotFound, "user_not_found"
+	case errors.Is(err, ErrDurationRequired):
+		return http.StatusBadRequest, "duration_required"
+	case errors.Is(err, ErrNotSanctioned):
+		return http.StatusNotFound, "not_sanctioned"
+	}
+	return http.StatusInternalServerError, "moderation_failed"
+}
+
+// ModerationHandler exposes a moderation action over REST.
+func ModerationHandler(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.ModerationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body", err.Error())
+			return
+		}
+
+		actor := Actor{UserID: c.GetInt("user_id"), Username: c.GetString("username"), Role: c.GetString("role"), IP: c.ClientIP()}
+		if err := Moderate(actor, action, req); err != nil {
+			status, code := moderationErrorStatus(err)
+			utils.ErrorResponse(c, status, err.Error(), code)
+			return
+		}
+
+		utils.SuccessResponse(c, "Moderation action applied", gin.H{"action": action, "user_id": req.UserID})
+	}
+}
+
+// moderationFrames handles moderation requests sent over the WebSocket.
+// Permissions are also checked in readPump via framePermissions.
+var moderationFrames = map[string]func(c *Client, payload interface{}){
+	"kick_user":   moderationFrame(ActionKick),
+	"mute_user":   moderationFrame(ActionMute),
+	"unmute_user": moderationFrame(ActionUnmute),
+	"ban_user":    moderationFrame(ActionBan),
+	"unban_user":  moderationFrame(ActionUnban),
+}
+
+func moderationFrame(action string) func(c *Client, payload interface{}) {
+	return func(c *Client, payload interface{}) {
+		var req models.ModerationRequest
+		raw, _ := json.Marshal(payload)
+		if err := json.Unmarshal(raw, &req); err != nil || req.UserID == 0 {
+			c.sendError("invalid_payload", "user_id is required")
+			return
+		}
+
+		actor := Actor{UserID: c.userID, Username: c.username, Role: c.role, IP: c.ip}
+		if err := Moderate(actor, action, req); err != nil {
+			_, code := moderationErrorStatus(err)
+			c.sendError(code, err.Error())
+		}
+	}
+}
+
+// RequireNotMuted rejects REST message sends from muted users.
+func RequireNotMuted() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mute, err := auth.ActiveSanction(c.GetInt("user_id"), auth.SanctionMute)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to check account status", err.Error())
+			c.Abort()
+			return
+		}
+		if mute != nil {
+			utils.ErrorResponseWithData(c, http.StatusForbidden, "You are muted", "muted", gin.H{"expires_at": mute.ExpiresAt})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,151 @@
+package chat
+
+import (
+	"backend/internal/auth"
+	"backend/internal/models"
+	"backend/internal/store"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func actorFor(user *models.User) Actor {
+	return Actor{UserID: user.ID, Username: user.Username, Role: user.Role, IP: "192.0.2.1"}
+}
+
+// expectClosed waits for the server to close conn.
+func expectClosed(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				t.Errorf("connection ended with %v, want a close frame", err)
+			}
+			return
+		}
+	}
+}
+
+func sendChat(t *testing.T, conn *websocket.Conn, content string) {
+	t.Helper()
+	if err := conn.WriteJSON(map[string]interface{}{"type": "chat_message", "payload": map[string]interface{}{"content": content}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModerateChecksTheActor(t *testing.T) {
+	mem, _ := startTestHub(t)
+	admin, mod, bob := addUser(t, mem, "admin"), addUser(t, mem, "mod"), addUser(t, mem, "bob")
+	for user, role := range map[*models.User]string{admin: auth.RoleAdmin, mod: auth.RoleModerator} {
+		if err := mem.SetRole(user.ID, role); err != nil {
+			t.Fatal(err)
+		}
+		user.Role = role
+	}
+
+	tests := []struct {
+		name   string
+		actor  *models.User
+		action string
+		req    models.ModerationRequest
+		want   error
+	}{
+		{"member", bob, ActionKick, models.ModerationRequest{UserID: mod.ID}, ErrForbidden},
+		{"self", mod, ActionKick, models.ModerationRequest{UserID: mod.ID}, ErrCannotModerate},
+		{"higher role", mod, ActionBan, models.ModerationRequest{UserID: admin.ID}, ErrCannotModerate},
+		{"unknown user", mod, ActionKick, models.ModerationRequest{UserID: 999}, ErrUserNotFound},
+		{"mute without duration", mod, ActionMute, models.ModerationRequest{UserID: bob.ID}, ErrDurationRequired},
+		{"unmute without mute", mod, ActionUnmute, models.ModerationRequest{UserID: bob.ID}, ErrNotSanctioned},
+		{"unban without ban", admin, ActionUnban, models.ModerationRequest{UserID: mod.ID}, ErrNotSanctioned},
+	}
+	for _, tt := range tests {
+		if err := Moderate(actorFor(tt.actor), tt.action, tt.req); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if events, _ := mem.QueryAuditEvents(store.AuditFilter{Action: "moderation."}); len(events) != 0 {
+		t.Errorf("refused actions were audited: %+v", events)
+	}
+}
+
+func TestMuteAndBan(t *testing.T) {
+	mem, srv := startTestHub(t)
+	mod, bob, carol := addUser(t, mem, "mod"), addUser(t, mem, "bob"), addUser(t, mem, "carol")
+	if err := mem.SetRole(mod.ID, auth.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	mod.Role = auth.RoleModerator
+	bobConn, carolConn := dial(t, srv, bob), dial(t, srv, carol)
+
+	if err := Moderate(actorFor(mod), ActionMute, models.ModerationRequest{UserID: bob.ID, Reason: "spam", DurationSeconds: 60}); err != nil {
+		t.Fatal(err)
+	}
+	system := readUntil(t, carolConn, "system")["payload"].(map[string]interface{})
+	if system["action"] != ActionMute || system["username"] != "bob" || system["reason"] != "spam" || system["expires_at"] == nil {
+		t.Errorf("system frame %v", system)
+	}
+	readUntil(t, bobConn, "system")
+	sendChat(t, bobConn, "still here")
+	if code := readUntil(t, bobConn, "error")["payload"].(map[string]interface{})["code"]; code != "muted" {
+		t.Errorf("muted user got error %v, want muted", code)
+	}
+
+	if err := Moderate(actorFor(mod), ActionUnmute, models.ModerationRequest{UserID: bob.ID}); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, bobConn, "system")
+	sendChat(t, bobConn, "thanks")
+	if content := readUntil(t, carolConn, "chat_message")["payload"].(map[string]interface{})["content"]; content != "thanks" {
+		t.Errorf("carol received %v", content)
+	}
+
+	if err := Moderate(actorFor(mod), ActionBan, models.ModerationRequest{UserID: bob.ID, Reason: "again"}); err != nil {
+		t.Fatal(err)
+	}
+	expectClosed(t, bobConn)
+	var banned *auth.BannedError
+	if err := auth.CheckBanned(bob.ID); !errors.As(err, &banned) || banned.Sanction.ExpiresAt != nil {
+		t.Errorf("CheckBanned = %v, want a permanent ban", err)
+	}
+
+	events, err := mem.QueryAuditEvents(store.AuditFilter{Action: "moderation."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.Action)
+		if e.ActorID != mod.ID || e.TargetID != strconv.Itoa(bob.ID) || e.IP != "192.0.2.1" {
+			t.Errorf("event %+v", e)
+		}
+	}
+	if len(actions) != 3 || actions[0] != "moderation.ban" || actions[2] != "moderation.mute" {
+		t.Errorf("audited %v, newest first; want ban, unmute, mute", actions)
+	}
+}
+
+func TestKickClosesEveryConnection(t *testing.T) {
+	mem, srv := startTestHub(t)
+	mod, bob := addUser(t, mem, "mod"), addUser(t, mem, "bob")
+	if err := mem.SetRole(mod.ID, auth.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	mod.Role = auth.RoleModerator
+	first, second := dial(t, srv, bob), dial(t, srv, bob)
+	readUntil(t, first, "online_count")
+	readUntil(t, second, "online_count")
+
+	if err := Moderate(actorFor(mod), ActionKick, models.ModerationRequest{UserID: bob.ID}); err != nil {
+		t.Fatal(err)
+	}
+	expectClosed(t, first)
+	expectClosed(t, second)
+	if err := auth.CheckBanned(bob.ID); err != nil {
+		t.Errorf("a kick banned the user: %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package chat
+
+import (
+	"backend/pkg/utils"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// pollTimeout is how long a poll waits for events unless the client
+	// asks for less; pollMaxTimeout caps what it may ask for.
+	pollTimeout    = 25 * time.Second
+	pollMaxTimeout = 55 * time.Second
+	// pollSessionTTL is how long a session lives without being polled.
+	pollSessionTTL = 60 * time.Second
+	// pollBatch is the most events returned by one poll.
+	pollBatch = 100
+)
+
+// pollSession is a long-poll client. Its hub client queues frames between
+// polls; frames handed out stay pending until a later poll acknowledges
+// them with its cursor, so a lost response is delivered again.
+type pollSession struct {
+	id     string
+	client *Client
+
+	// mu is held by the poll in progress.
+	mu      sync.Mutex
+	pending []*frame
+	// cursors numbers pending frames; the last one handed out is cursor.
+	cursors []uint64
+	cursor  uint64
+	closed  bool
+
+	// interrupt releases a waiting poll when a newer one arrives.
+	interrupt chan struct{}
+	active    atomic.Int32
+	lastPoll  atomic.Int64
+}
+
+// pollSessions holds the live long-poll sessions of this instance.
+type pollSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*pollSession
+}
+
+func newPollSessions() *pollSessions {
+	return &pollSessions{sessions: make(map[string]*pollSession)}
+}
+
+func (p *pollSessions) get(id string, userID int) *pollSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.sessions[id]
+	if s == nil || s.client.userID != userID {
+		return nil
+	}
+	return s
+}
+
+func (p *pollSessions) add(client *Client) *pollSession {
+	b := make([]byte, 16)
+	rand.Read(b)
+	s := &pollSession{id: hex.EncodeToString(b), client: client, interrupt: make(chan struct{}, 1)}
+	s.lastPoll.Store(time.Now().UnixNano())
+
+	p.mu.Lock()
+	p.sessions[s.id] = s
+	p.mu.Unlock()
+	return s
+}
+
+func (p *pollSessions) remove(s *pollSession) {
+	p.mu.Lock()
+	delete(p.sessions, s.id)
+	p.mu.Unlock()
+}
+
+// expire unregisters sessions that have not been polled for
+// pollSessionTTL. It runs for the life of the hub.
+func (p *pollSessions) expire(h *Hub) {
+	ticker := time.NewTicker(pollSessionTTL / 4)
+	defer ticker.Stop()
+	for range ticker.C {
+		deadline := time.Now().Add(-pollSessionTTL).UnixNano()
+		var expired []*pollSession
+		p.mu.Lock()
+		for id, s := range p.sessions {
+			if s.active.Load() == 0 && s.lastPoll.Load() < deadline {
+				delete(p.sessions, id)
+				expired = append(expired, s)
+			}
+		}
+		p.mu.Unlock()
+		for _, s := range expired {
+			log.Printf("Long-poll session of user %s (ID: %d) expired", s.client.username, s.client.userID)
+			h.unregister <- s.client
+		}
+	}
+}
+
+// poll acknowledges the frames up to cursor and returns the pending ones
+// with the cursor of the last, waiting up to timeout for new frames if
+// there are none. done reports that the hub closed the session and every
+// frame has been handed out.
+func (s *pollSession) poll(ctx <-chan struct{}, cursor uint64, timeout time.Duration) (frames []*frame, last uint64, done bool) {
+	s.active.Add(1)
+	defer s.active.Add(-1)
+	defer s.lastPoll.Store(time.Now().UnixNano())
+	select {
+	case s.interrupt <- struct{}{}:
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.interrupt:
+	default:
+	}
+
+	acked := 0
+	for acked < len(s.cursors) && s.cursors[acked] <= cursor {
+		acked++
+	}
+	s.pending, s.cursors = s.pending[acked:], s.cursors[acked:]
+
+	if len(s.pending) == 0 && !s.closed {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case f, ok := <-s.client.send:
+			s.queue(f, ok)
+		case <-timer.C:
+		case <-s.interrupt:
+		case <-ctx:
+		}
+	}
+	for !s.closed && len(s.pending) < pollBatch {
+		select {
+		case f, ok := <-s.client.send:
+			s.queue(f, ok)
+			continue
+		default:
+		}
+		break
+	}
+
+	n := min(len(s.pending), pollBatch)
+	last = cursor
+	if n > 0 {
+		last = s.cursors[n-1]
+	}
+	return s.pending[:n], last, s.closed && n == len(s.pending)
+}
+
+func (s *pollSession) queue(f *frame, ok bool) {
+	if !ok {
+		s.closed = true
+		return
+	}
+	s.cursor++
+	s.pending = append(s.pending, f)
+	s.cursors = append(s.cursors, s.cursor)
+}
+
+// PollHandler is the long-poll transport for clients that can use neither
+// WebSockets nor Server-Sent Events. The first request, without session,
+// starts a session; each response returns the session, a cursor and the
+// frames a WebSocket client would have received since the cursor passed
+// in. The request is held open until there are frames or timeout seconds
+// pass. Sessions that are not polled for a minute expire.
+func PollHandler(c *gin.Context) {
+	timeout := pollTimeout
+	if seconds, err := strconv.Atoi(c.Query("timeout")); err == nil && seconds >= 0 {
+		timeout = min(time.Duration(seconds)*time.Second, pollMaxTimeout)
+	}
+	cursor, _ := strconv.ParseUint(c.Query("cursor"), 10, 64)
+
+	events := []json.RawMessage{}
+	session := hub.polls.get(c.Query("session"), c.GetInt("user_id"))
+	if session == nil {
+		client := admit(c)
+		if client == nil {
+			return
+		}
+		client.transport = TransportPoll
+		hub.register <- client
+		session = hub.polls.add(client)
+		log.Printf("Long-poll session started for user %s (ID: %d)", client.username, client.userID)
+		if c.Query("session") != "" {
+			// The old session expired; its frames are gone.
+			events = append(events, resyncFrame.data)
+		}
+		cursor = 0
+	}
+
+	frames, cursor, closed := session.poll(c.Request.Context().Done(), cursor, timeout)
+	for _, f := range frames {
+		events = append(events, f.data)
+	}
+	if closed {
+		hub.polls.remove(session)
+	}
+
+	utils.SuccessResponse(c, "Events retrieved successfully", gin.H{
+		"session": session.id,
+		"cursor":  cursor,
+		"events":  events,
+		"closed":  closed,
+	})
+}
@@ -0,0 +1,139 @@
+package chat
+
+import (
+	"backend/internal/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pollResult is the data of a long-poll response.
+type pollResult struct {
+	Session string `json:"session"`
+	Cursor  uint64 `json:"cursor"`
+	Events  []struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	} `json:"events"`
+	Closed bool `json:"closed"`
+}
+
+// contents returns the content of the chat messages among the events.
+func (r pollResult) contents() []string {
+	var contents []string
+	for _, e := range r.Events {
+		var msg Message
+		if e.Type == "chat_message" && json.Unmarshal(e.Payload, &msg) == nil {
+			contents = append(contents, msg.Content)
+		}
+	}
+	return contents
+}
+
+// poll makes one long-poll request as user.
+func poll(t *testing.T, srv *httptest.Server, user *models.User, session string, cursor uint64, timeout int) pollResult {
+	t.Helper()
+	url := fmt.Sprintf("%s/api/chat/poll?user=%d&session=%s&cursor=%d&timeout=%d", srv.URL, user.ID, session, cursor, timeout)
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Data pollResult `json:"data"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("poll: HTTP %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Data
+}
+
+func TestPollRedeliversUnacknowledgedFrames(t *testing.T) {
+	mem, srv := startTestHub(t)
+	alice, bob := addUser(t, mem, "alice"), addUser(t, mem, "bob")
+
+	first := poll(t, srv, alice, "", 0, 0)
+	if first.Session == "" {
+		t.Fatal("no session started")
+	}
+	postMessage(t, srv, bob, "hello")
+
+	got := poll(t, srv, alice, first.Session, first.Cursor, 2)
+	for len(got.contents()) == 0 {
+		// Presence frames can come first; acknowledge them.
+		got = poll(t, srv, alice, first.Session, got.Cursor, 2)
+	}
+	if contents := got.contents(); len(contents) != 1 || contents[0] != "hello" {
+		t.Fatalf("got messages %v, want [hello]", contents)
+	}
+
+	// The response was "lost": polling with the old cursor returns the
+	// same frames again.
+	again := poll(t, srv, alice, first.Session, first.Cursor, 0)
+	if again.Cursor != got.Cursor || len(again.contents()) != 1 {
+		t.Errorf("repeated poll: cursor %d and messages %v, want cursor %d and [hello]", again.Cursor, again.contents(), got.Cursor)
+	}
+
+	acked := poll(t, srv, alice, first.Session, got.Cursor, 0)
+	if acked.Session != first.Session || len(acked.Events) != 0 {
+		t.Errorf("after acknowledging: session %s, %d events", acked.Session, len(acked.Events))
+	}
+}
+
+func TestPollWaitsForFrames(t *testing.T) {
+	mem, srv := startTestHub(t)
+	alice, bob := addUser(t, mem, "alice"), addUser(t, mem, "bob")
+
+	session := poll(t, srv, alice, "", 0, 0)
+	for len(session.Events) > 0 {
+		session = poll(t, srv, alice, session.Session, session.Cursor, 0)
+	}
+
+	posted := make(chan error, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		resp, err := http.Post(fmt.Sprintf("%s/api/chat/messages?user=%d", srv.URL, bob.ID), "application/json", strings.NewReader(`{"content":"wake up"}`))
+		if err == nil {
+			resp.Body.Close()
+		}
+		posted <- err
+	}()
+	start := time.Now()
+	got := poll(t, srv, alice, session.Session, session.Cursor, 10)
+	if err := <-posted; err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Events) == 0 || time.Since(start) > 5*time.Second {
+		t.Errorf("poll returned %d events after %v", len(got.Events), time.Since(start))
+	}
+}
+
+func TestPollSessionsBelongToTheirUser(t *testing.T) {
+	mem, srv := startTestHub(t)
+	alice, bob := addUser(t, mem, "alice"), addUser(t, mem, "bob")
+
+	session := poll(t, srv, alice, "", 0, 0).Session
+	for _, tt := range []struct {
+		user    *models.User
+		session string
+	}{
+		{bob, session},
+		{alice, "expired"},
+	} {
+		got := poll(t, srv, tt.user, tt.session, 5, 0)
+		if got.Session == tt.session || len(got.Events) == 0 || got.Events[0].Type != "resync" {
+			t.Errorf("user %s polling session %q: got session %q and events %+v, want a new session starting with resync",
+				tt.user.Username, tt.session, got.Session, got.Events)
+		}
+	}
+	if got := poll(t, srv, alice, session, 0, 0); got.Session != session {
+		t.Errorf("alice's session was replaced: got %q, want %q", got.Session, session)
+	}
+}
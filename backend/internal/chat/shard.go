@@ -0,0 +1,93 @@
+package chat
+
+// shardBuffer is the number of pending operations a shard accepts before
+// the hub waits for it.
+const shardBuffer = 1024
+
+// shard owns the send side of a subset of the hub's clients. Broadcast
+// frames are encoded once by the hub and handed to every shard, so a slow
+// fan-out in one shard does not hold up the others or the hub itself.
+type shard struct {
+	clients map[*Client]bool
+	ops     chan shardOp
+	policy  SlowConsumerPolicy
+	stats   *slowConsumerCounters
+	// dropped receives clients the shard disconnected on its own, so the
+	// hub unregisters them.
+	dropped chan<- *Client
+}
+
+// shardOp is a single ordered operation on a shard. Keeping joins, leaves
+// and frames on one channel guarantees a client sees every frame sent after
+// it joined.
+type shardOp struct {
+	join   *Client
+	leave  *Client
+	to     *Client // frame goes to this client only
+	frame  *frame
+	direct bool // frame goes to join or leave only
+
+	// closeMessage is the close frame sent to leave after frame.
+	closeMessage []byte
+}
+
+func newShard(policy SlowConsumerPolicy, stats *slowConsumerCounters, dropped chan<- *Client) *shard {
+	return &shard{
+		clients: make(map[*Client]bool),
+		ops:     make(chan shardOp, shardBuffer),
+		policy:  policy,
+		stats:   stats,
+		dropped: dropped,
+	}
+}
+
+func (s *shard) run() {
+	for op := range s.ops {
+		switch {
+		case op.join != nil:
+			s.clients[op.join] = true
+			if op.direct {
+				s.send(op.join, op.frame)
+			}
+		case op.to != nil:
+			if s.clients[op.to] {
+				s.send(op.to, op.frame)
+			}
+		case op.leave != nil:
+			if s.clients[op.leave] {
+				delete(s.clients, op.leave)
+				if op.direct {
+					select {
+					case op.leave.send <- op.frame:
+					default:
+					}
+				}
+				op.leave.closeMessage = op.closeMessage
+				close(op.leave.send)
+			}
+		default:
+			for c := range s.clients {
+				s.send(c, op.frame)
+			}
+		}
+	}
+}
+
+// send queues a frame for c, applying the slow-consumer policy when its
+// buffer is full.
+func (s *shard) send(c *Client, f *frame) {
+	select {
+	case c.send <- f:
+		return
+	default:
+	}
+	if relieve(s.policy, s.stats, c, f) {
+		return
+	}
+	s.stats.Disconnected.Add(1)
+	c.closeMessage = closeSlowConsumer
+	close(c.send)
+	delete(s.clients, c)
+	// Not from this goroutine: Run may be waiting for room in ops.
+	go func() { s.dropped <- c }()
+}
@@ -0,0 +1,161 @@
+package chat
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// SlowConsumerPolicy decides what happens when a client's send buffer is
+// full.
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerDisconnect closes the connection with
+	// websocket.ClosePolicyViolation and reason "slow consumer".
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+	// SlowConsumerDrop discards the oldest queued non-critical frame
+	// (presence and typing) to make room, and disconnects when there is
+	// none.
+	SlowConsumerDrop SlowConsumerPolicy = "drop"
+	// SlowConsumerCoalesce keeps only the newest queued online_count
+	// frame, and disconnects when that frees no room.
+	SlowConsumerCoalesce SlowConsumerPolicy = "coalesce"
+)
+
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(strings.ToLower(s)); policy {
+	case "":
+		return SlowConsumerDisconnect, nil
+	case SlowConsumerDisconnect, SlowConsumerDrop, SlowConsumerCoalesce:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown slow consumer policy %q", s)
+}
+
+const slowConsumerReason = "slow consumer"
+
+// slowConsumerCounters counts how often clients fell behind.
+type slowConsumerCounters struct {
+	Disconnected      atomic.Int64
+	FramesDropped     atomic.Int64
+	PresenceCoalesced atomic.Int64
+}
+
+func (s *slowConsumerCounters) snapshot() map[string]int64 {
+	return map[string]int64{
+		"disconnected":       s.Disconnected.Load(),
+		"frames_dropped":     s.FramesDropped.Load(),
+		"presence_coalesced": s.PresenceCoalesced.Load(),
+	}
+}
+
+func framePrefix(frameType string) []byte {
+	return []byte(`{"type":"` + frameType + `"`)
+}
+
+var (
+	countPrefix = framePrefix("online_count")
+
+	// nonCriticalPrefixes are frames a client can miss without losing
+	// messages; the next presence update supersedes them.
+	nonCriticalPrefixes = [][]byte{
+		countPrefix,
+		framePrefix("user_joined"),
+		framePrefix("user_left"),
+		framePrefix("typing"),
+	}
+)
+
+func isNonCritical(data []byte) bool {
+	for _, prefix := range nonCriticalPrefixes {
+		if bytes.HasPrefix(data, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// relieve applies the policy to a client whose buffer is full, queueing
+// frame if it makes room. It reports whether the client can stay connected.
+// Only the client's shard writes to its buffer (frames for one client go
+// through the shard too), so there is room for the frames put back.
+func relieve(policy SlowConsumerPolicy, stats *slowConsumerCounters, c *Client, f *frame) bool {
+	if policy == SlowConsumerDisconnect {
+		return false
+	}
+
+	var queued []*frame
+	for len(c.send) > 0 {
+		select {
+		case q := <-c.send:
+			queued = append(queued, q)
+		default:
+		}
+	}
+	queued = append(queued, f)
+
+	var kept []*frame
+	switch policy {
+	case SlowConsumerDrop:
+		kept = dropOldest(queued)
+		if kept != nil {
+			stats.FramesDropped.Add(1)
+		}
+	case SlowConsumerCoalesce:
+		kept = coalesceCounts(queued)
+		if kept != nil {
+			stats.PresenceCoalesced.Add(int64(len(queued) - len(kept)))
+		}
+	}
+	stays := kept != nil
+	if !stays {
+		// Nothing could be dropped; put back what fits and disconnect.
+		kept = queued[:len(queued)-1]
+	}
+	for _, k := range kept {
+		select {
+		case c.send <- k:
+		default:
+		}
+	}
+	return stays
+}
+
+// dropOldest removes the oldest non-critical frame, or returns nil if there
+// is none.
+func dropOldest(frames []*frame) []*frame {
+	for i, f := range frames {
+		if isNonCritical(f.data) {
+			return append(frames[:i:i], frames[i+1:]...)
+		}
+	}
+	return nil
+}
+
+// coalesceCounts removes every online_count frame but the newest, or
+// returns nil if there is at most one.
+func coalesceCounts(frames []*frame) []*frame {
+	last := -1
+	for i, f := range frames {
+		if bytes.HasPrefix(f.data, countPrefix) {
+			last = i
+		}
+	}
+	kept := make([]*frame, 0, len(frames))
+	for i, f := range frames {
+		if i == last || !bytes.HasPrefix(f.data, countPrefix) {
+			kept = append(kept, f)
+		}
+	}
+	if len(kept) == len(frames) {
+		return nil
+	}
+	return kept
+}
+
+// closeSlowConsumer is the close frame sent to disconnected slow clients.
+var closeSlowConsumer = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, slowConsumerReason)
@@ -0,0 +1,151 @@
+package chat
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseSlowConsumerPolicy(t *testing.T) {
+	for s, want := range map[string]SlowConsumerPolicy{
+		"":           SlowConsumerDisconnect,
+		"disconnect": SlowConsumerDisconnect,
+		"Drop":       SlowConsumerDrop,
+		"COALESCE":   SlowConsumerCoalesce,
+	} {
+		if got, err := ParseSlowConsumerPolicy(s); err != nil || got != want {
+			t.Errorf("%q: got %q, %v; want %q", s, got, err, want)
+		}
+	}
+	if _, err := ParseSlowConsumerPolicy("ignore"); err == nil {
+		t.Error("accepted an unknown policy")
+	}
+}
+
+func TestSlowConsumerPolicies(t *testing.T) {
+	chat := func(content string) *frame { return newFrame("chat_message", Message{Content: content}) }
+	count := func(n int) *frame { return newFrame("online_count", n) }
+	joined := newFrame("user_joined", UserJoined{Username: "bob"})
+
+	tests := []struct {
+		name   string
+		policy SlowConsumerPolicy
+		queued []*frame
+		// want is what the client has queued afterwards, or nil when it is
+		// disconnected.
+		want  []*frame
+		stats map[string]int64
+	}{
+		{
+			name:   "disconnect",
+			policy: SlowConsumerDisconnect,
+			queued: []*frame{joined, count(1), count(2)},
+			stats:  map[string]int64{"disconnected": 1},
+		},
+		{
+			name:   "drop the oldest presence frame",
+			policy: SlowConsumerDrop,
+			queued: []*frame{chat("a"), joined, count(1)},
+			want:   []*frame{chat("a"), count(1), chat("new")},
+			stats:  map[string]int64{"frames_dropped": 1},
+		},
+		{
+			name:   "drop with only messages queued",
+			policy: SlowConsumerDrop,
+			queued: []*frame{chat("a"), chat("b"), chat("c")},
+			stats:  map[string]int64{"disconnected": 1},
+		},
+		{
+			name:   "coalesce online counts",
+			policy: SlowConsumerCoalesce,
+			queued: []*frame{count(1), chat("a"), count(2)},
+			want:   []*frame{chat("a"), count(2), chat("new")},
+			stats:  map[string]int64{"presence_coalesced": 1},
+		},
+		{
+			name:   "coalesce with one online count",
+			policy: SlowConsumerCoalesce,
+			queued: []*frame{joined, chat("a"), count(1)},
+			stats:  map[string]int64{"disconnected": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats slowConsumerCounters
+			dropped := make(chan *Client, 1)
+			s := newShard(tt.policy, &stats, dropped)
+			c := &Client{send: make(chan *frame, len(tt.queued))}
+			s.clients[c] = true
+			for _, f := range tt.queued {
+				c.send <- f
+			}
+
+			s.send(c, chat("new"))
+
+			got := drain(c)
+			if tt.want == nil {
+				if !bytes.Equal(c.closeMessage, closeSlowConsumer) || s.clients[c] {
+					t.Error("slow client was not disconnected")
+				}
+				select {
+				case d := <-dropped:
+					if d != c {
+						t.Error("another client was reported as dropped")
+					}
+				case <-time.After(time.Second):
+					t.Error("the hub was not told about the disconnect")
+				}
+			} else if len(got) != len(tt.want) {
+				t.Errorf("queued %d frames, want %d", len(got), len(tt.want))
+			} else {
+				for i := range got {
+					if !bytes.Equal(got[i].data, tt.want[i].data) {
+						t.Errorf("frame %d is %s, want %s", i, got[i].data, tt.want[i].data)
+					}
+				}
+			}
+			for key, value := range stats.snapshot() {
+				if value != tt.stats[key] {
+					t.Errorf("%s = %d, want %d", key, value, tt.stats[key])
+				}
+			}
+		})
+	}
+}
+
+// drain returns the frames queued for c, stopping when the queue is empty
+// or closed.
+func drain(c *Client) []*frame {
+	var frames []*frame
+	for {
+		select {
+		case f, ok := <-c.send:
+			if !ok {
+				return frames
+			}
+			frames = append(frames, f)
+		default:
+			return frames
+		}
+	}
+}
+
+func TestSlowConsumerLeavesTheHub(t *testing.T) {
+	mem, _ := startTestHub(t)
+	bob := addUser(t, mem, "bob")
+
+	// bob never reads, so the second broadcast overflows his buffer.
+	c := &Client{hub: hub, send: make(chan *frame, 1), userID: bob.ID, username: bob.Username}
+	hub.register <- c
+	for i := 0; i < 3; i++ {
+		hub.broadcast <- newFrame("chat_message", Message{Content: "hi"})
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(UserConnections(bob.ID)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("slow client is still registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,124 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamHeartbeat is how often an idle event stream gets a comment line, so
+// proxies don't close it.
+const streamHeartbeat = 15 * time.Second
+
+// resyncFrame tells a resuming client that events were missed and its
+// history should be reloaded.
+var resyncFrame = newFrame("resync", Resync{
+	Message: "Some events were missed, reload the message history",
+})
+
+// StreamHandler sends the frames a WebSocket client would receive as
+// Server-Sent Events, for clients behind proxies that break WebSockets.
+// Messages are sent with POST /api/chat/messages. Broadcast frames carry an
+// id; a reconnecting client that sends it back in Last-Event-ID (or the
+// last_event_id query parameter) first receives the frames it missed.
+func StreamHandler(c *gin.Context) {
+	client := admit(c)
+	if client == nil {
+		return
+	}
+	client.transport = TransportStream
+
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	c.Writer.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(c.Writer)
+	w := bufio.NewWriter(c.Writer)
+
+	hub.pumps.Add(1)
+	defer hub.pumps.Done()
+	hub.register <- client
+	log.Printf("Event stream established for user %s (ID: %d)", client.username, client.userID)
+
+	// Frames delivered after registration are already queued; the journal
+	// covers the ones before it, and seen skips the overlap.
+	var seen uint64
+	rc.SetWriteDeadline(time.Now().Add(writeWait))
+	w.WriteString("retry: " + strconv.FormatInt(reconnectAfter.Milliseconds(), 10) + "\n\n")
+	if lastID != "" {
+		seq, ok := hub.journal.parseID(lastID)
+		var missed []*frame
+		if ok {
+			missed, ok = hub.journal.since(seq)
+		}
+		if !ok {
+			writeEvent(w, resyncFrame)
+		}
+		for _, f := range missed {
+			writeEvent(w, f)
+			seen = f.seq
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		if w.Flush() != nil || rc.Flush() != nil {
+			hub.unregister <- client
+			return
+		}
+
+		var f *frame
+		var open bool
+		select {
+		case f, open = <-client.send:
+		case <-heartbeat.C:
+			open = true
+		case <-c.Request.Context().Done():
+			hub.unregister <- client
+			return
+		}
+		rc.SetWriteDeadline(time.Now().Add(writeWait))
+
+		switch {
+		case !open:
+			// The hub closed the stream. After a restart, spread the
+			// reconnects like WebSocket clients do.
+			if bytes.Equal(client.closeMessage, closeServiceRestart) {
+				delay := reconnectAfter + time.Duration(rand.Int63n(int64(reconnectJitter)))
+				w.WriteString("retry: " + strconv.FormatInt(delay.Milliseconds(), 10) + "\n\n")
+			}
+			w.Flush()
+			rc.Flush()
+			return
+		case f == nil:
+			w.WriteString(": ping\n\n")
+		case f.seq == 0 || f.seq > seen:
+			writeEvent(w, f)
+			seen = max(seen, f.seq)
+		}
+	}
+}
+
+// writeEvent writes f as one event. Encoded frames never contain newlines,
+// so each fits on a single data line.
+func writeEvent(w *bufio.Writer, f *frame) {
+	if f.seq != 0 {
+		w.WriteString("id: " + hub.journal.id(f) + "\n")
+	}
+	w.WriteString("data: ")
+	w.Write(f.data)
+	w.WriteString("\n\n")
+}
@@ -0,0 +1,148 @@
+package chat
+
+import (
+	"backend/internal/models"
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// event is one Server-Sent Event with a data line.
+type event struct {
+	id        string
+	frameType string
+	content   string
+}
+
+// openStream opens the chat event stream as user, resuming after lastID if
+// it is set. The stream is closed by cancel or at the end of the test.
+func openStream(t *testing.T, srv *httptest.Server, user *models.User, lastID string) (events <-chan event, cancel func()) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/chat/stream?user="+strconv.Itoa(user.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+
+	ch := make(chan event, 64)
+	go func() {
+		defer resp.Body.Close()
+		defer close(ch)
+		r := bufio.NewReader(resp.Body)
+		var e event
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				e.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				var frame struct {
+					Type    string `json:"type"`
+					Payload struct {
+						Content string `json:"content"`
+					} `json:"payload"`
+				}
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &frame)
+				e.frameType, e.content = frame.Type, frame.Payload.Content
+			case line == "":
+				if e.frameType != "" {
+					ch <- e
+				}
+				e = event{}
+			}
+		}
+	}()
+	return ch, cancel
+}
+
+// nextOfType returns the next event of the given type.
+func nextOfType(t *testing.T, events <-chan event, frameType string) event {
+	t.Helper()
+	for e := range events {
+		if e.frameType == frameType {
+			return e
+		}
+	}
+	t.Fatalf("stream ended before a %s event", frameType)
+	return event{}
+}
+
+// postMessage sends a chat message over HTTP as user.
+func postMessage(t *testing.T, srv *httptest.Server, user *models.User, content string) {
+	t.Helper()
+	body := strings.NewReader(`{"content":"` + content + `"}`)
+	resp, err := http.Post(srv.URL+"/api/chat/messages?user="+strconv.Itoa(user.ID), "application/json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("posting %q: HTTP %d", content, resp.StatusCode)
+	}
+}
+
+func TestStreamResumesAfterLastEventID(t *testing.T) {
+	mem, srv := startTestHub(t)
+	alice, bob := addUser(t, mem, "alice"), addUser(t, mem, "bob")
+
+	events, cancel := openStream(t, srv, alice, "")
+	nextOfType(t, events, "online_count")
+	postMessage(t, srv, bob, "before")
+	seen := nextOfType(t, events, "chat_message")
+	if seen.id == "" || seen.content != "before" {
+		t.Fatalf("first message event %+v", seen)
+	}
+	cancel()
+
+	postMessage(t, srv, bob, "missed")
+	events, _ = openStream(t, srv, alice, seen.id)
+	postMessage(t, srv, bob, "after")
+
+	// The missed message comes first, once, even though the stream was
+	// registered before the journal was read.
+	var contents []string
+	for len(contents) == 0 || contents[len(contents)-1] != "after" {
+		e := nextOfType(t, events, "chat_message")
+		if e.id == "" {
+			t.Errorf("%q event has no id", e.content)
+		}
+		contents = append(contents, e.content)
+	}
+	if got := strings.Join(contents, ","); got != "missed,after" {
+		t.Errorf("resumed stream sent %s, want missed,after", got)
+	}
+}
+
+func TestStreamAsksUnknownIDsToResync(t *testing.T) {
+	mem, srv := startTestHub(t)
+	alice := addUser(t, mem, "alice")
+
+	for _, lastID := range []string{"nonsense", hub.journal.epoch + "-999999", "0-1"} {
+		events, cancel := openStream(t, srv, alice, lastID)
+		if e := <-events; e.frameType != "resync" {
+			t.Errorf("Last-Event-ID %q: first event is %+v, want resync", lastID, e)
+		}
+		cancel()
+	}
+}
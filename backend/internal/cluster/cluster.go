@@ -0,0 +1,237 @@
+// Package cluster relays hub events between server instances so that users
+// connected to different instances share one chat.
+package cluster
+
+import (
+	"backend/internal/database"
+	"backend/internal/models"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+type Kind string
+
+const (
+	// KindBroadcast carries a frame for every connected client.
+	KindBroadcast Kind = "broadcast"
+	// KindKick closes every connection of UserID.
+	KindKick Kind = "kick"
+	// KindMute sets the mute deadline (UnixNano in Until, zero to unmute)
+	// on every connection of UserID.
+	KindMute Kind = "mute"
+	// KindPresence reports the number of connections held by Origin.
+	KindPresence Kind = "presence"
+	// KindMessages carries Messages saved on Origin, so other instances
+	// can update their history caches.
+	KindMessages Kind = "messages"
+	// KindResync means events may have been missed, so caches should be
+	// reloaded and presence republished. It is generated locally after the
+	// broker reconnects, and sent by an instance that had to drop events.
+	KindResync Kind = "resync"
+)
+
+type Event struct {
+	Origin      string           `json:"origin"`
+	Kind        Kind             `json:"kind"`
+	Frame       json.RawMessage  `json:"frame,omitempty"`
+	UserID      int              `json:"user_id,omitempty"`
+	Until       int64            `json:"until,omitempty"`
+	Connections int              `json:"connections,omitempty"`
+	Messages    []models.Message `json:"messages,omitempty"`
+}
+
+// Broker carries hub events between instances.
+type Broker interface {
+	// Origin identifies this instance; events it published are not
+	// delivered back to it.
+	Origin() string
+	// Publish sends an event to every other instance. It must not block
+	// the hub; brokers that fall behind drop events, and once they catch up
+	// send a KindResync event and the latest presence they dropped.
+	Publish(event Event)
+	// Events returns events published by other instances.
+	Events() <-chan Event
+	Close() error
+}
+
+// Setup returns the broker selected by HUB_CLUSTER. Without one the hub
+// runs alone on an in-process broker. HUB_INSTANCE_ID names this instance
+// in presence events and defaults to a random id.
+func Setup() Broker {
+	origin := instanceID()
+
+	var broker Broker
+	var err error
+	switch mode := strings.ToLower(os.Getenv("HUB_CLUSTER")); mode {
+	case "", "local":
+		return NewLocal().Join(origin)
+	case "postgres":
+		if database.Driver != database.DriverPostgres {
+			log.Fatal("HUB_CLUSTER=postgres requires STORAGE_DRIVER=postgres")
+		}
+		broker, err = NewPostgres(database.DB, database.PostgresDSN(), origin)
+	case "redis":
+		broker, err = NewRedis(envOr("REDIS_URL", "redis://localhost:6379/0"), origin)
+	case "nats":
+		broker, err = NewNATS(envOr("NATS_URL", "nats://localhost:4222"), origin)
+	default:
+		log.Fatalf("Unknown HUB_CLUSTER %q", mode)
+	}
+	if err != nil {
+		log.Fatal("Failed to start hub cluster broker:", err)
+	}
+	log.Printf("Hub cluster: %s (instance %s)", os.Getenv("HUB_CLUSTER"), origin)
+	return broker
+}
+
+func instanceID() string {
+	if id := os.Getenv("HUB_INSTANCE_ID"); id != "" {
+		return id
+	}
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// relayBuffer is the capacity of a relay's outbox and inbox.
+const relayBuffer = 1024
+
+// relay is the plumbing shared by the network brokers: a non-blocking
+// outbox drained by one goroutine and a buffered inbox of events from
+// other instances.
+type relay struct {
+	origin   string
+	outbox   chan Event
+	events   chan Event
+	done     chan struct{}
+	overflow *overflow
+}
+
+// overflow records what Publish dropped while the outbox was full.
+type overflow struct {
+	mu      sync.Mutex
+	dropped int
+	// presence is the newest presence event dropped; it supersedes the
+	// older ones.
+	presence *Event
+}
+
+func newRelay(origin string) relay {
+	return relay{
+		origin:   origin,
+		outbox:   make(chan Event, relayBuffer),
+		events:   make(chan Event, relayBuffer),
+		done:     make(chan struct{}),
+		overflow: &overflow{},
+	}
+}
+
+func (r *relay) Origin() string {
+	return r.origin
+}
+
+func (r *relay) Events() <-chan Event {
+	return r.events
+}
+
+func (r *relay) Publish(event Event) {
+	event.Origin = r.origin
+	select {
+	case r.outbox <- event:
+		return
+	default:
+	}
+
+	o := r.overflow
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.dropped == 0 {
+		log.Printf("Hub cluster: outbox full, dropping events until it drains")
+	}
+	o.dropped++
+	if event.Kind == KindPresence {
+		o.presence = &event
+	}
+}
+
+// catchUp returns the events that tell other instances what they missed
+// while events were being dropped: a resync, then the latest dropped
+// presence. It returns nil if nothing was dropped.
+func (r *relay) catchUp() []Event {
+	o := r.overflow
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.dropped == 0 {
+		return nil
+	}
+	log.Printf("Hub cluster: outbox drained after dropping %d events, asking other instances to resync", o.dropped)
+	events := []Event{{Origin: r.origin, Kind: KindResync}}
+	if o.presence != nil {
+		events = append(events, *o.presence)
+	}
+	o.dropped, o.presence = 0, nil
+	return events
+}
+
+// drain sends queued events with publish until the relay is stopped. Once
+// the outbox is empty it reports any events Publish had to drop.
+func (r *relay) drain(publish func(payload []byte) error) {
+	send := func(event Event) {
+		payload, err := json.Marshal(event)
+		if err == nil {
+			err = publish(payload)
+		}
+		if err != nil {
+			log.Printf("Hub cluster: failed to publish %s event: %v", event.Kind, err)
+		}
+	}
+	for {
+		select {
+		case event := <-r.outbox:
+			send(event)
+			if len(r.outbox) == 0 {
+				for _, e := range r.catchUp() {
+					send(e)
+				}
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// handle decodes a received payload and delivers it unless this instance
+// published it.
+func (r *relay) handle(payload []byte) {
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		log.Printf("Hub cluster: dropping event: %v", err)
+		return
+	}
+	if event.Origin != r.origin {
+		r.deliver(event)
+	}
+}
+
+func (r *relay) deliver(event Event) {
+	select {
+	case r.events <- event:
+	case <-r.done:
+	}
+}
+
+func (r *relay) stop() {
+	close(r.done)
+}
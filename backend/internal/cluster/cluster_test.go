@@ -0,0 +1,176 @@
+package cluster
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestRelayHandleFiltersOwnEvents(t *testing.T) {
+	r := newRelay("a")
+	defer r.stop()
+
+	for _, payload := range []string{
+		`{"origin":"a","kind":"kick","user_id":1}`,
+		`not json`,
+		`{"origin":"b","kind":"kick","user_id":2}`,
+	} {
+		r.handle([]byte(payload))
+	}
+
+	event := receive(t, &r)
+	if event.Origin != "b" || event.UserID != 2 {
+		t.Errorf("received %+v, want the kick from b", event)
+	}
+	expectNothing(t, &r)
+}
+
+func TestRelayDrainPublishesWithOrigin(t *testing.T) {
+	r := newRelay("a")
+	published := make(chan []byte, 1)
+	go r.drain(func(payload []byte) error {
+		published <- payload
+		return nil
+	})
+	defer r.stop()
+
+	r.Publish(Event{Origin: "spoofed", Kind: KindPresence, Connections: 2})
+	select {
+	case payload := <-published:
+		var event Event
+		if err := json.Unmarshal(payload, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Origin != "a" || event.Kind != KindPresence || event.Connections != 2 {
+			t.Errorf("published %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing published")
+	}
+}
+
+func TestRelayRecoversFromOverflow(t *testing.T) {
+	r := newRelay("a")
+	defer r.stop()
+
+	// Nothing drains the outbox yet, so it fills up and Publish drops.
+	for i := 0; i < relayBuffer; i++ {
+		r.Publish(Event{Kind: KindBroadcast, Frame: json.RawMessage(`{}`)})
+	}
+	r.Publish(Event{Kind: KindPresence, Connections: 5})
+	r.Publish(Event{Kind: KindBroadcast, Frame: json.RawMessage(`{}`)})
+	r.Publish(Event{Kind: KindPresence, Connections: 7})
+
+	published := make(chan Event, relayBuffer+10)
+	go r.drain(func(payload []byte) error {
+		var event Event
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return err
+		}
+		published <- event
+		return nil
+	})
+
+	var tail []Event
+	for i := 0; i < relayBuffer+2; i++ {
+		select {
+		case event := <-published:
+			if i >= relayBuffer {
+				tail = append(tail, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d events published, want %d", i, relayBuffer+2)
+		}
+	}
+	if tail[0].Kind != KindResync || tail[0].Origin != "a" {
+		t.Errorf("after the queued events got %+v, want a resync from a", tail[0])
+	}
+	if tail[1].Kind != KindPresence || tail[1].Connections != 7 {
+		t.Errorf("then got %+v, want the latest presence", tail[1])
+	}
+
+	// Once recovered, events flow as usual.
+	r.Publish(Event{Kind: KindKick, UserID: 3})
+	select {
+	case event := <-published:
+		if event.Kind != KindKick {
+			t.Errorf("got %+v, want the kick", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing published")
+	}
+}
+
+func TestPostgresFetchResolvesSpilledEvents(t *testing.T) {
+	// fetch only reads hub_events, which any SQL database can stand in for.
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE hub_events (id INTEGER PRIMARY KEY, payload TEXT NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	big := `{"origin":"b","kind":"messages","messages":[]}`
+	if _, err := db.Exec(`INSERT INTO hub_events (id, payload) VALUES (7, $1)`, big); err != nil {
+		t.Fatal(err)
+	}
+	p := &Postgres{relay: newRelay("a"), db: db}
+	defer p.stop()
+
+	tests := []struct {
+		notification string
+		want         string
+	}{
+		{`{"origin":"b","kind":"kick","user_id":2}`, `{"origin":"b","kind":"kick","user_id":2}`},
+		{`{"origin":"b","ref":7}`, big},
+		{`{"origin":"a","ref":7}`, ""},
+		{`{"origin":"a","kind":"kick","user_id":2}`, ""},
+	}
+	for _, tt := range tests {
+		payload, err := p.fetch(tt.notification)
+		if err != nil || string(payload) != tt.want {
+			t.Errorf("fetch(%s) = %q, %v; want %q", tt.notification, payload, err, tt.want)
+		}
+	}
+	for _, notification := range []string{`not json`, `{"origin":"b","ref":8}`} {
+		if _, err := p.fetch(notification); err == nil {
+			t.Errorf("fetch(%s) succeeded", notification)
+		}
+	}
+}
+
+// awaitKind returns the next event of kind for b, skipping others, or fails
+// after timeout.
+func awaitKind(t *testing.T, b subscriber, kind Kind, timeout time.Duration) Event {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case event := <-b.Events():
+			if event.Kind == kind {
+				return event
+			}
+		case <-deadline:
+			t.Fatalf("%s received no %s event", b.Origin(), kind)
+			return Event{}
+		}
+	}
+}
+
+// exchange checks that events published by a reach b with a's origin, and
+// are not delivered back to a.
+func exchange(t *testing.T, a, b Broker, userID int) {
+	t.Helper()
+	a.Publish(Event{Origin: "spoofed", Kind: KindKick, UserID: userID})
+	event := awaitKind(t, b, KindKick, 5*time.Second)
+	if event.Origin != a.Origin() || event.UserID != userID {
+		t.Errorf("%s received %+v, want the kick of %d from %s", b.Origin(), event, userID, a.Origin())
+	}
+	// Give an echo of the event time to arrive before checking for one.
+	time.Sleep(50 * time.Millisecond)
+	expectNothing(t, a)
+}
@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"log"
+	"sync"
+)
+
+// Local connects hubs running in the same process. A bus with a single hub
+// is the default single-instance setup; several hubs on one bus stand in
+// for a multi-instance deployment without any broker process.
+type Local struct {
+	mu      sync.RWMutex
+	members map[*localBroker]bool
+}
+
+func NewLocal() *Local {
+	return &Local{members: make(map[*localBroker]bool)}
+}
+
+// Join adds a broker for the instance named origin to the bus.
+func (l *Local) Join(origin string) Broker {
+	b := &localBroker{bus: l, origin: origin, events: make(chan Event, 1024)}
+	l.mu.Lock()
+	l.members[b] = true
+	l.mu.Unlock()
+	return b
+}
+
+type localBroker struct {
+	bus    *Local
+	origin string
+	events chan Event
+}
+
+func (b *localBroker) Origin() string {
+	return b.origin
+}
+
+func (b *localBroker) Events() <-chan Event {
+	return b.events
+}
+
+func (b *localBroker) Publish(event Event) {
+	event.Origin = b.origin
+	b.bus.mu.RLock()
+	defer b.bus.mu.RUnlock()
+	for m := range b.bus.members {
+		if m == b {
+			continue
+		}
+		select {
+		case m.events <- event:
+		default:
+			log.Printf("Hub cluster: %s is not keeping up, dropping %s event", m.origin, event.Kind)
+		}
+	}
+}
+
+func (b *localBroker) Close() error {
+	b.bus.mu.Lock()
+	delete(b.bus.members, b)
+	b.bus.mu.Unlock()
+	return nil
+}
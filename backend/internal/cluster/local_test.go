@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+// subscriber is the receiving side of a Broker.
+type subscriber interface {
+	Origin() string
+	Events() <-chan Event
+}
+
+// receive returns the next event for b, or fails after a second.
+func receive(t *testing.T, b subscriber) Event {
+	t.Helper()
+	select {
+	case event := <-b.Events():
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("%s received nothing", b.Origin())
+		return Event{}
+	}
+}
+
+// expectNothing fails if b has an event waiting.
+func expectNothing(t *testing.T, b subscriber) {
+	t.Helper()
+	select {
+	case event := <-b.Events():
+		t.Errorf("%s received %+v", b.Origin(), event)
+	default:
+	}
+}
+
+func TestLocalDeliversToOtherMembers(t *testing.T) {
+	bus := NewLocal()
+	a, b, c := bus.Join("a"), bus.Join("b"), bus.Join("c")
+
+	a.Publish(Event{Origin: "spoofed", Kind: KindPresence, Connections: 3})
+	for _, member := range []Broker{b, c} {
+		event := receive(t, member)
+		if event.Origin != "a" || event.Kind != KindPresence || event.Connections != 3 {
+			t.Errorf("%s received %+v", member.Origin(), event)
+		}
+	}
+	expectNothing(t, a)
+}
+
+func TestLocalClose(t *testing.T) {
+	bus := NewLocal()
+	a, b := bus.Join("a"), bus.Join("b")
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	a.Publish(Event{Kind: KindKick, UserID: 7})
+	expectNothing(t, b)
+
+	// A lone member hears nothing, as in a single-instance setup.
+	a.Publish(Event{Kind: KindKick, UserID: 7})
+	expectNothing(t, a)
+}
+
+func TestLocalDropsWhenMemberFallsBehind(t *testing.T) {
+	bus := NewLocal()
+	a, b := bus.Join("a"), bus.Join("b")
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 2000; i++ {
+			a.Publish(Event{Kind: KindBroadcast})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a member that is not reading")
+	}
+	if n := len(b.Events()); n != cap(b.(*localBroker).events) {
+		t.Errorf("%d events queued, want a full buffer", n)
+	}
+}
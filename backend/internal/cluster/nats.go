@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"github.com/nats-io/nats.go"
+)
+
+const natsSubject = "inboxly.hub"
+
+// NATS relays events over a NATS subject.
+type NATS struct {
+	relay
+	conn *nats.Conn
+}
+
+// NewNATS connects to a nats:// URL, e.g. nats://localhost:4222. Several
+// comma separated server URLs may be given.
+func NewNATS(url, origin string) (*NATS, error) {
+	n := &NATS{relay: newRelay(origin)}
+	conn, err := nats.Connect(url,
+		nats.Name("inboxly "+origin),
+		nats.MaxReconnects(-1),
+		nats.ReconnectHandler(func(*nats.Conn) {
+			n.deliver(Event{Kind: KindResync})
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	n.conn = conn
+
+	_, err = conn.Subscribe(natsSubject, func(m *nats.Msg) {
+		n.handle(m.Data)
+	})
+	if err == nil {
+		// Make sure the server has registered the subscription.
+		err = conn.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go n.drain(func(payload []byte) error {
+		return conn.Publish(natsSubject, payload)
+	})
+	return n, nil
+}
+
+func (n *NATS) Close() error {
+	n.stop()
+	n.conn.Close()
+	return nil
+}
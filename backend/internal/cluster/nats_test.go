@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+)
+
+// runNATS starts an embedded NATS server on port, or on a free one when
+// port is -1.
+func runNATS(t *testing.T, port int) *server.Server {
+	t.Helper()
+	opts := natstest.DefaultTestOptions
+	opts.Port = port
+	s := natstest.RunServer(&opts)
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func TestNATS(t *testing.T) {
+	s := runNATS(t, -1)
+	url := s.ClientURL()
+	a, err := NewNATS(url, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewNATS(url, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	exchange(t, a, b, 1)
+	exchange(t, b, a, 2)
+
+	// After the server restarts, both reconnect and ask their hubs to
+	// resync, then carry on.
+	port := s.Addr().(*net.TCPAddr).Port
+	s.Shutdown()
+	runNATS(t, port)
+	awaitKind(t, a, KindResync, 10*time.Second)
+	awaitKind(t, b, KindResync, 10*time.Second)
+	// The server may see a's publish before b's resubscription; a round trip
+	// makes sure it has the subscription.
+	if err := b.conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	exchange(t, a, b, 3)
+}
+
+func TestNewNATSFailsWithoutServer(t *testing.T) {
+	s := runNATS(t, -1)
+	url := s.ClientURL()
+	s.Shutdown()
+	if _, err := NewNATS(url, "a"); err == nil {
+		t.Error("NewNATS succeeded without a server")
+	}
+}
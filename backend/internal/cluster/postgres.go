@@ -0,0 +1,133 @@
+package cluster
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	notifyChannel = "inboxly_hub"
+
+	// maxNotifyPayload keeps payloads under Postgres' 8000 byte NOTIFY
+	// limit; larger events go through the hub_events table.
+	maxNotifyPayload = 7900
+
+	// hubEventRetention is how long spilled events are kept for listeners
+	// to fetch.
+	hubEventRetention = 5 * time.Minute
+)
+
+// Postgres relays events with LISTEN/NOTIFY on a dedicated connection.
+type Postgres struct {
+	relay
+	db       *sql.DB
+	listener *pq.Listener
+}
+
+// spilled is the notification sent for events stored in hub_events.
+type spilled struct {
+	Origin string `json:"origin"`
+	Ref    int64  `json:"ref"`
+}
+
+func NewPostgres(db *sql.DB, dsn, origin string) (*Postgres, error) {
+	p := &Postgres{relay: newRelay(origin), db: db}
+	p.listener = pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Hub cluster listener: %v", err)
+		}
+	})
+	if err := p.listener.Listen(notifyChannel); err != nil {
+		p.listener.Close()
+		return nil, err
+	}
+	go p.receive()
+	go p.drain(p.notify)
+	go p.cleanup()
+	return p, nil
+}
+
+func (p *Postgres) Close() error {
+	p.stop()
+	return p.listener.Close()
+}
+
+func (p *Postgres) notify(payload []byte) error {
+	if len(payload) > maxNotifyPayload {
+		var ref int64
+		err := p.db.QueryRow(`INSERT INTO hub_events (payload) VALUES ($1) RETURNING id`, string(payload)).Scan(&ref)
+		if err != nil {
+			return err
+		}
+		payload, _ = json.Marshal(spilled{Origin: p.origin, Ref: ref})
+	}
+	_, err := p.db.Exec(`SELECT pg_notify($1, $2)`, notifyChannel, string(payload))
+	return err
+}
+
+func (p *Postgres) receive() {
+	for {
+		select {
+		case n, ok := <-p.listener.Notify:
+			if !ok {
+				return
+			}
+			// pq sends nil after re-establishing a lost connection.
+			if n == nil {
+				p.deliver(Event{Kind: KindResync})
+				continue
+			}
+			payload, err := p.fetch(n.Extra)
+			if err != nil {
+				log.Printf("Hub cluster: dropping event: %v", err)
+				continue
+			}
+			if payload != nil {
+				p.handle(payload)
+			}
+		case <-time.After(90 * time.Second):
+			go p.listener.Ping()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// fetch resolves a notification to the event payload, reading spilled
+// events from hub_events. It returns nil for events this instance
+// published.
+func (p *Postgres) fetch(notification string) ([]byte, error) {
+	var ref spilled
+	if err := json.Unmarshal([]byte(notification), &ref); err != nil {
+		return nil, err
+	}
+	if ref.Origin == p.origin {
+		return nil, nil
+	}
+	if ref.Ref == 0 {
+		return []byte(notification), nil
+	}
+	var payload string
+	err := p.db.QueryRow(`SELECT payload FROM hub_events WHERE id = $1`, ref.Ref).Scan(&payload)
+	return []byte(payload), err
+}
+
+func (p *Postgres) cleanup() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, err := p.db.Exec(`DELETE FROM hub_events WHERE created_at < $1`, time.Now().Add(-hubEventRetention))
+			if err != nil {
+				log.Printf("Hub cluster: failed to clean up events: %v", err)
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisChannel = "inboxly:hub"
+
+// Redis relays events over Redis pub/sub.
+type Redis struct {
+	relay
+	client *redis.Client
+	pubsub *redis.PubSub
+}
+
+// NewRedis connects to a redis:// URL, e.g. redis://localhost:6379/0.
+func NewRedis(url, origin string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+
+	ctx := context.Background()
+	pubsub := client.Subscribe(ctx, redisChannel)
+	// Wait for the subscription so events published right after startup
+	// are not missed.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		client.Close()
+		return nil, err
+	}
+
+	r := &Redis{relay: newRelay(origin), client: client, pubsub: pubsub}
+	go r.receive()
+	go r.drain(func(payload []byte) error {
+		return client.Publish(ctx, redisChannel, payload).Err()
+	})
+	return r, nil
+}
+
+func (r *Redis) Close() error {
+	r.stop()
+	r.pubsub.Close()
+	return r.client.Close()
+}
+
+func (r *Redis) receive() {
+	for msg := range r.pubsub.ChannelWithSubscriptions() {
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			// The client resubscribes after reconnecting.
+			if m.Kind == "subscribe" {
+				r.deliver(Event{Kind: KindResync})
+			}
+		case *redis.Message:
+			r.handle([]byte(m.Payload))
+		}
+	}
+}
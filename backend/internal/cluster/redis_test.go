@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	url := "redis://" + server.Addr() + "/0"
+	a, err := NewRedis(url, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewRedis(url, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	exchange(t, a, b, 1)
+	exchange(t, b, a, 2)
+
+	// After the connection drops, both resubscribe and ask their hubs to
+	// resync, then carry on.
+	server.Close()
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	awaitKind(t, a, KindResync, 10*time.Second)
+	awaitKind(t, b, KindResync, 10*time.Second)
+	exchange(t, a, b, 3)
+}
+
+func TestNewRedisFailsWithoutServer(t *testing.T) {
+	server := miniredis.RunT(t)
+	url := "redis://" + server.Addr() + "/0"
+	server.Close()
+	if _, err := NewRedis(url, "a"); err == nil {
+		t.Error("NewRedis succeeded without a server")
+	}
+	if _, err := NewRedis("http://localhost", "a"); err == nil {
+		t.Error("NewRedis accepted a URL that is not redis://")
+	}
+}
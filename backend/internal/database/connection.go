@@ -5,65 +5,97 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
 )
 
 var DB *sql.DB
 
-func Connect() {
-	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWORD")
-	dbname := os.Getenv("DB_NAME")
-	sslmode := os.Getenv("DB_SSLMODE")
+// Driver is the SQL dialect of DB, set by Open.
+var Driver string
 
-	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		host, port, user, password, dbname, sslmode)
+// Connect opens the database and applies pending migrations unless
+// DB_AUTO_MIGRATE is "false".
+func Connect() {
+	Open()
 
-	var err error
-	DB, err = sql.Open("postgres", psqlInfo)
+	if os.Getenv("DB_AUTO_MIGRATE") == "false" {
+		return
+	}
+	applied, err := MigrateUp()
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		log.Fatal("Failed to migrate database:", err)
+	}
+	log.Printf("Database schema up to date (%d migrations applied)", applied)
+}
+
+// Open connects to the database selected by STORAGE_DRIVER ("postgres" by
+// default, or "sqlite") without touching the schema.
+func Open() {
+	switch driver := strings.ToLower(os.Getenv("STORAGE_DRIVER")); driver {
+	case "", DriverPostgres:
+		openPostgres()
+	case DriverSQLite:
+		openSQLite()
+	default:
+		log.Fatalf("STORAGE_DRIVER %q has no database", driver)
 	}
 
-	if err = DB.Ping(); err != nil {
+	if err := DB.Ping(); err != nil {
 		log.Fatal("Failed to ping database:", err)
 	}
 
 	log.Println("Successfully connected to database")
-	createTables()
 }
 
-func createTables() {
-	userTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		username VARCHAR(50) UNIQUE NOT NULL,
-		email VARCHAR(100) UNIQUE NOT NULL,
-		password_hash VARCHAR(255) NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	messageTable := `
-	CREATE TABLE IF NOT EXISTS messages (
-		id SERIAL PRIMARY KEY,
-		user_id INTEGER REFERENCES users(id),
-		username VARCHAR(50) NOT NULL,
-		content TEXT NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	if _, err := DB.Exec(userTable); err != nil {
-		log.Fatal("Failed to create users table:", err)
+// PostgresDSN builds the connection string from the DB_* variables.
+func PostgresDSN() string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"), os.Getenv("DB_SSLMODE"))
+}
+
+func openPostgres() {
+	var err error
+	DB, err = sql.Open("postgres", PostgresDSN())
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
 	}
+	Driver = DriverPostgres
+}
 
-	if _, err := DB.Exec(messageTable); err != nil {
-		log.Fatal("Failed to create messages table:", err)
+// openSQLite opens SQLITE_PATH (default inboxly.db). WAL mode lets readers
+// proceed while a write is in progress, and the busy timeout makes
+// concurrent writers wait for each other instead of failing.
+func openSQLite() {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		path = "inboxly.db"
 	}
+	dsn := "file:" + path + "?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
 
-	log.Println("Database tables created successfully")
+	var err error
+	DB, err = sql.Open("sqlite3", dsn)
+	if err != nil {
+		log.Fatal("Failed to open database:", err)
+	}
+	Driver = DriverSQLite
+
+	var fts5 bool
+	err = DB.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5)
+	if err != nil {
+		log.Fatal("Failed to open database:", err)
+	}
+	if !fts5 {
+		log.Fatal("SQLite was built without FTS5; build with -tags sqlite_fts5")
+	}
 }
 
 func Close() {
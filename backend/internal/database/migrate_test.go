@@ -0,0 +1,139 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// useSQLite points the package at a fresh, empty SQLite database.
+func useSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	var fts5 bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		t.Fatal(err)
+	}
+	if !fts5 {
+		t.Skip("SQLite built without FTS5; run with -tags sqlite_fts5")
+	}
+
+	previousDB, previousDriver := DB, Driver
+	DB, Driver = db, DriverSQLite
+	t.Cleanup(func() { DB, Driver = previousDB, previousDriver })
+	return db
+}
+
+func TestRebind(t *testing.T) {
+	const query = `SELECT id FROM users WHERE email = $1 OR LOWER(username) = $1 LIMIT $12`
+	if got := Rebind(DriverPostgres, query); got != query {
+		t.Errorf("postgres: got %q", got)
+	}
+	want := `SELECT id FROM users WHERE email = ?1 OR LOWER(username) = ?1 LIMIT ?12`
+	if got := Rebind(DriverSQLite, query); got != want {
+		t.Errorf("sqlite: got %q, want %q", got, want)
+	}
+}
+
+func TestMigrationsMatchAcrossDrivers(t *testing.T) {
+	defer func(driver string) { Driver = driver }(Driver)
+
+	names := make(map[string][]string)
+	for _, driver := range []string{DriverPostgres, DriverSQLite} {
+		Driver = driver
+		migrations, err := loadMigrations()
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		for _, mig := range migrations {
+			names[driver] = append(names[driver], fmt.Sprintf("%04d_%s", mig.Version, mig.Name))
+		}
+	}
+
+	postgres, sqlite := names[DriverPostgres], names[DriverSQLite]
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("postgres has %d migrations, sqlite %d", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i] != sqlite[i] {
+			t.Errorf("migration %d: postgres %s, sqlite %s", i, postgres[i], sqlite[i])
+		}
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	db := useSQLite(t)
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := MigrateUp()
+	if err != nil || applied != len(migrations) {
+		t.Fatalf("up: applied %d, %v; want %d", applied, err, len(migrations))
+	}
+	if applied, err := MigrateUp(); err != nil || applied != 0 {
+		t.Errorf("second up: applied %d, %v; want 0", applied, err)
+	}
+
+	statuses, err := MigrationStatuses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != len(migrations) {
+		t.Fatalf("%d statuses, want %d", len(statuses), len(migrations))
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("%04d_%s is not applied", status.Version, status.Name)
+		}
+	}
+
+	if rolledBack, err := MigrateDown(2); err != nil || rolledBack != 2 {
+		t.Fatalf("down 2: rolled back %d, %v", rolledBack, err)
+	}
+	statuses, err = MigrationStatuses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, status := range statuses {
+		if pending := i >= len(statuses)-2; pending != (status.AppliedAt == nil) {
+			t.Errorf("after down 2, %04d_%s applied at %v", status.Version, status.Name, status.AppliedAt)
+		}
+	}
+	if applied, err := MigrateUp(); err != nil || applied != 2 {
+		t.Errorf("up after down 2: applied %d, %v; want 2", applied, err)
+	}
+
+	// Rolling everything back leaves only the bookkeeping table, so every
+	// down file undoes its up file.
+	if rolledBack, err := MigrateDown(len(migrations) + 1); err != nil || rolledBack != len(migrations) {
+		t.Fatalf("down all: rolled back %d, %v; want %d", rolledBack, err, len(migrations))
+	}
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		if name != "schema_migrations" {
+			t.Errorf("table %s left after rolling back every migration", name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := MigrateUp(); err != nil || applied != len(migrations) {
+		t.Errorf("up after down all: applied %d, %v; want %d", applied, err, len(migrations))
+	}
+}
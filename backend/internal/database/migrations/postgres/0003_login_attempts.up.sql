@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+	id SERIAL PRIMARY KEY,
+	username VARCHAR(100) NOT NULL,
+	ip_address VARCHAR(64) NOT NULL,
+	success BOOLEAN NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_username ON login_attempts (username, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts (ip_address, created_at);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name VARCHAR(100) NOT NULL,
+	token_prefix VARCHAR(16) NOT NULL,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	scopes TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP,
+	last_used_at TIMESTAMP,
+	revoked_at TIMESTAMP
+);
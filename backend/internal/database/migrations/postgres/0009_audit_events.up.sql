@@ -0,0 +1,25 @@
+-- Audit events are append-only; the trigger rejects updates and deletes.
+CREATE TABLE IF NOT EXISTS audit_events (
+	id BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	action VARCHAR(64) NOT NULL,
+	actor_id INTEGER,
+	actor_username VARCHAR(50) NOT NULL DEFAULT '',
+	target_type VARCHAR(32) NOT NULL DEFAULT '',
+	target_id VARCHAR(64) NOT NULL DEFAULT '',
+	ip_address VARCHAR(64) NOT NULL DEFAULT '',
+	metadata JSONB NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events (actor_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events (target_id, created_at);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+	FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,9 @@
+-- Holds hub events too large for a NOTIFY payload; the notification only
+-- carries the row id.
+CREATE TABLE IF NOT EXISTS hub_events (
+	id BIGSERIAL PRIMARY KEY,
+	payload TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_hub_events_created_at ON hub_events (created_at);
@@ -0,0 +1,4 @@
+-- An idempotency key assigned when a message is accepted, so a batch
+-- replayed from the spool does not save its messages twice.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_key TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_key ON messages (client_key);
@@ -0,0 +1,3 @@
+-- When the password was last set; sessions issued before then are no
+-- longer accepted.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMP;
@@ -0,0 +1,5 @@
+-- Usernames and emails are unique regardless of case. Emails are stored
+-- lowercased; usernames keep their display casing.
+UPDATE users SET email = LOWER(TRIM(email)) WHERE email <> LOWER(TRIM(email));
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_lower ON users (LOWER(username));
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (LOWER(email));
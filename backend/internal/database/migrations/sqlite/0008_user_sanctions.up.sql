@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS user_sanctions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	kind VARCHAR(16) NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP,
+	lifted_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_user_sanctions_user ON user_sanctions (user_id, kind);
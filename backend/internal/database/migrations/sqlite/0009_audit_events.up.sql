@@ -0,0 +1,25 @@
+-- Audit events are append-only; the triggers reject updates and deletes.
+CREATE TABLE IF NOT EXISTS audit_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	action VARCHAR(64) NOT NULL,
+	actor_id INTEGER,
+	actor_username VARCHAR(50) NOT NULL DEFAULT '',
+	target_type VARCHAR(32) NOT NULL DEFAULT '',
+	target_id VARCHAR(64) NOT NULL DEFAULT '',
+	ip_address VARCHAR(64) NOT NULL DEFAULT '',
+	metadata TEXT NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events (actor_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events (target_id, created_at);
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
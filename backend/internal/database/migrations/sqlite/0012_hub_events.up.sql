@@ -0,0 +1,9 @@
+-- Only used by the Postgres cluster relay; kept so both drivers share
+-- migration versions.
+CREATE TABLE IF NOT EXISTS hub_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	payload TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_hub_events_created_at ON hub_events (created_at);
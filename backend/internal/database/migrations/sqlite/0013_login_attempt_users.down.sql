@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_login_attempts_user_id;
+ALTER TABLE login_attempts DROP COLUMN user_id;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_client_key;
+ALTER TABLE messages DROP COLUMN client_key;
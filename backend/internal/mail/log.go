@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogSender writes messages to a file (or the standard logger when Path is
+// empty) instead of delivering them.
+type LogSender struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *LogSender) Send(msg Message) error {
+	entry := fmt.Sprintf("[%s] To: %s\nSubject: %s\n\n%s\n---\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+
+	if s.Path == "" {
+		log.Print("Mail (not sent):\n" + entry)
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(entry)
+	return err
+}
@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers outgoing email. Implementations must be safe for concurrent use.
+type Sender interface {
+	Send(msg Message) error
+}
+
+var DefaultSender Sender
+
+// Setup configures DefaultSender from the environment. MAIL_DRIVER selects
+// "smtp" or "log" (the default, suitable for local development and tests).
+func Setup() {
+	switch strings.ToLower(os.Getenv("MAIL_DRIVER")) {
+	case "smtp":
+		DefaultSender = &SMTPSender{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+		log.Println("Mail: using SMTP sender")
+	default:
+		DefaultSender = &LogSender{Path: os.Getenv("MAIL_LOG_FILE")}
+		log.Println("Mail: using log sender")
+	}
+}
+
+func Send(msg Message) error {
+	if DefaultSender == nil {
+		return fmt.Errorf("mail sender not configured")
+	}
+	return DefaultSender.Send(msg)
+}
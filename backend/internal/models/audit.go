@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type AuditEvent struct {
+	ID            int                    `json:"id"`
+	CreatedAt     time.Time              `json:"created_at"`
+	Action        string                 `json:"action"`
+	ActorID       int                    `json:"actor_id,omitempty"`
+	ActorUsername string                 `json:"actor_username,omitempty"`
+	TargetType    string                 `json:"target_type,omitempty"`
+	TargetID      string                 `json:"target_id,omitempty"`
+	IP            string                 `json:"ip,omitempty"`
+	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+}
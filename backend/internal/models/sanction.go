@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+type Sanction struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	Kind      string     `json:"kind"`
+	Reason    string     `json:"reason"`
+	CreatedBy int        `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type ModerationRequest struct {
+	UserID int    `json:"user_id" binding:"required"`
+	Reason string `json:"reason" binding:"max=500"`
+	// DurationSeconds applies to mutes (required) and bans (0 = permanent).
+	DurationSeconds int `json:"duration_seconds" binding:"min=0"`
+}
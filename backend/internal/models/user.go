@@ -3,14 +3,21 @@ package models
 import "time"
 
 type User struct {
-	ID        int       `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"` // Never send password in JSON
-	CreatedAt time.Time `json:"created_at"`
+	ID            int        `json:"id"`
+	Username      string     `json:"username"`
+	Email         string     `json:"email"`
+	Password      string     `json:"-"` // Never send password in JSON
+	EmailVerified bool       `json:"email_verified"`
+	Role          string     `json:"role"`
+	DisabledAt    *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	// PasswordChangedAt is when the password was last set; sessions
+	// issued before then are rejected.
+	PasswordChangedAt *time.Time `json:"-"`
 }
 
 type LoginRequest struct {
+	// Username accepts either the username or the email address.
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 }
@@ -18,7 +25,24 @@ type LoginRequest struct {
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=50"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type UpdateRoleRequest struct {
+	Role string `json:"role" binding:"required"`
 }
 
 type LoginResponse struct {
@@ -32,4 +56,7 @@ type Message struct {
 	Username  string    `json:"username"`
 	Content   string    `json:"content"`
 	CreatedAt time.Time `json:"created_at"`
+	// ClientKey is the idempotency key the message writer assigns when it
+	// accepts a message; saving the same key again is a no-op.
+	ClientKey string `json:"-"`
 }
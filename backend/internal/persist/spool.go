@@ -0,0 +1,165 @@
+package persist
+
+import (
+	"backend/internal/models"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// spool is the write-ahead log of accepted messages. Every message is
+// appended with a sequence number before it is queued, and a commit marker
+// is appended once a batch is in the database. Once everything written has
+// been committed the file is truncated, so it only grows while the
+// database is behind.
+//
+// A crash between a commit and its marker replays that batch on the next
+// start; the messages' client keys keep the replay from saving them twice.
+type spool struct {
+	mu        sync.Mutex
+	file      *os.File
+	w         *bufio.Writer
+	seq       uint64
+	committed uint64
+}
+
+type spoolRecord struct {
+	Seq     uint64          `json:"seq,omitempty"`
+	Message *models.Message `json:"message,omitempty"`
+	// Key is the message's client key, which its JSON leaves out.
+	Key       string `json:"key,omitempty"`
+	Committed uint64 `json:"committed,omitempty"`
+}
+
+// openSpool opens or creates the spool at path and returns the messages
+// that were written but never committed.
+func openSpool(path string) (*spool, []pending, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var records []pending
+	var seq, committed uint64
+	dec := json.NewDecoder(file)
+	for {
+		var r spoolRecord
+		err := dec.Decode(&r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A torn final line from a crash; everything before it is
+			// intact.
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			file.Close()
+			return nil, nil, fmt.Errorf("reading message spool %s: %w", path, err)
+		}
+		switch {
+		case r.Message != nil:
+			r.Message.ClientKey = r.Key
+			records = append(records, pending{seq: r.Seq, msg: *r.Message})
+			seq = max(seq, r.Seq)
+		case r.Committed != 0:
+			committed = max(committed, r.Committed)
+		}
+	}
+
+	var uncommitted []pending
+	for _, r := range records {
+		if r.seq > committed {
+			uncommitted = append(uncommitted, r)
+		}
+	}
+
+	// Start the file over with only the uncommitted messages.
+	s := &spool{file: file, w: bufio.NewWriter(file), seq: seq, committed: seq - uint64(len(uncommitted))}
+	if err := s.reset(); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	for _, p := range uncommitted {
+		if err := s.write(spoolRecord{Seq: p.seq, Message: &p.msg, Key: p.msg.ClientKey}); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	}
+	if err := s.sync(); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return s, uncommitted, nil
+}
+
+// append logs msg and returns its sequence number.
+func (s *spool) append(msg models.Message) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	if err := s.write(spoolRecord{Seq: s.seq, Message: &msg, Key: msg.ClientKey}); err != nil {
+		s.seq--
+		return 0, err
+	}
+	// Hand the line to the OS so it survives the process; fsync is left
+	// to commit, once per batch.
+	return s.seq, s.w.Flush()
+}
+
+// commit records that every message up to seq is in the database.
+func (s *spool) commit(seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.committed = seq
+	if s.committed == s.seq {
+		return s.reset()
+	}
+	if err := s.write(spoolRecord{Committed: seq}); err != nil {
+		return err
+	}
+	return s.sync()
+}
+
+func (s *spool) write(r spoolRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+func (s *spool) sync() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// reset empties the file; the caller holds mu or owns s exclusively.
+func (s *spool) reset() error {
+	s.w.Reset(s.file)
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := s.file.Seek(0, io.SeekStart)
+	return err
+}
+
+func (s *spool) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,107 @@
+package persist
+
+import (
+	"backend/internal/models"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSpool writes records to a new spool file, followed by tail.
+func writeSpool(t *testing.T, tail string, records ...spoolRecord) string {
+	t.Helper()
+	var data []byte
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+	path := filepath.Join(t.TempDir(), "messages.spool")
+	if err := os.WriteFile(path, append(data, tail...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func spooled(seq uint64, content, key string) spoolRecord {
+	msg := models.Message{Username: "alice", Content: content, CreatedAt: time.Now(), ClientKey: key}
+	return spoolRecord{Seq: seq, Message: &msg, Key: key}
+}
+
+func TestSpoolReplaysUncommittedMessages(t *testing.T) {
+	s := newFlakyStore()
+	// "two" was saved but the crash came before its commit marker.
+	saved := spooled(2, "two", "k2").Message
+	if err := s.SaveMessage(saved); err != nil {
+		t.Fatal(err)
+	}
+	path := writeSpool(t, "",
+		spooled(1, "one", "k1"),
+		spoolRecord{Committed: 1},
+		spooled(2, "two", "k2"),
+		spooled(3, "three", "k3"),
+	)
+
+	w, err := New(s, Options{SpoolPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got := contents(t, s)
+	if len(got) != 2 || got[0] != "two" || got[1] != "three" {
+		t.Errorf("saved %q, want two once and three", got)
+	}
+	if size := spoolSize(t, path); size != 0 {
+		t.Errorf("spool has %d bytes left after the replay", size)
+	}
+}
+
+func TestSpoolIgnoresTornLastLine(t *testing.T) {
+	path := writeSpool(t, `{"seq":2,"message":{"username":"ali`, spooled(1, "one", "k1"))
+
+	sp, recovered, err := openSpool(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.close()
+	if len(recovered) != 1 || recovered[0].msg.Content != "one" || recovered[0].msg.ClientKey != "k1" {
+		t.Fatalf("recovered %+v, want only the intact message with its key", recovered)
+	}
+
+	// The torn line is gone and new messages follow the intact one.
+	seq, err := sp.append(models.Message{Content: "two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 2 {
+		t.Errorf("next sequence number %d, want 2", seq)
+	}
+	if err := sp.sync(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var r spoolRecord
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("spool is not intact after recovery: %v\n%s", err, data)
+		}
+	}
+}
+
+func TestSpoolRejectsCorruption(t *testing.T) {
+	path := writeSpool(t, "not json\n", spooled(1, "one", "k1"))
+	if _, _, err := openSpool(path); err == nil {
+		t.Error("opened a spool with a corrupt line")
+	}
+}
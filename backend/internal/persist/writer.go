@@ -0,0 +1,295 @@
+// Package persist saves chat messages asynchronously in batches, keeping a
+// write-ahead spool so messages accepted while the database is unreachable
+// are saved once it is back, even across a restart.
+package persist
+
+import (
+	"backend/internal/models"
+	"backend/internal/store"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// flushInterval bounds how long a message waits for its batch to fill.
+	flushInterval = 50 * time.Millisecond
+
+	minRetryDelay = 100 * time.Millisecond
+	maxRetryDelay = 5 * time.Second
+)
+
+var ErrClosed = errors.New("message writer closed")
+
+type Options struct {
+	// BatchSize is the most messages written in one statement.
+	BatchSize int
+	// QueueSize is how many messages may wait to be written; Save blocks
+	// when the queue is full.
+	QueueSize int
+	// SpoolPath is the write-ahead spool file; empty disables it.
+	SpoolPath string
+}
+
+// Writer batches messages into the store from a single goroutine.
+type Writer struct {
+	store store.MessageStore
+	opts  Options
+	spool *spool
+	queue chan pending
+
+	// mu keeps queue order equal to spool order.
+	mu        sync.Mutex
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	saved   atomic.Int64
+	failed  atomic.Int64
+	retries atomic.Int64
+}
+
+type pending struct {
+	seq uint64
+	msg models.Message
+}
+
+// New starts a writer. Messages left in the spool by a previous run are
+// queued ahead of new ones.
+func New(s store.MessageStore, opts Options) (*Writer, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 10000
+	}
+
+	var sp *spool
+	var recovered []pending
+	if opts.SpoolPath != "" {
+		var err error
+		sp, recovered, err = openSpool(opts.SpoolPath)
+		if err != nil {
+			return nil, err
+		}
+		if len(recovered) > 0 {
+			log.Printf("Recovered %d unsaved messages from %s", len(recovered), opts.SpoolPath)
+		}
+	}
+
+	w := &Writer{
+		store: s,
+		opts:  opts,
+		spool: sp,
+		queue: make(chan pending, opts.QueueSize+len(recovered)),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for _, p := range recovered {
+		w.queue <- p
+	}
+	go w.run()
+	return w, nil
+}
+
+// Setup creates the writer for the server from MESSAGE_BATCH_SIZE,
+// MESSAGE_QUEUE_SIZE and MESSAGE_SPOOL (default messages.spool, "off" to
+// disable). The spool is always off with in-memory storage.
+func Setup(s store.MessageStore) *Writer {
+	opts := Options{SpoolPath: os.Getenv("MESSAGE_SPOOL")}
+	opts.BatchSize, _ = strconv.Atoi(os.Getenv("MESSAGE_BATCH_SIZE"))
+	opts.QueueSize, _ = strconv.Atoi(os.Getenv("MESSAGE_QUEUE_SIZE"))
+	switch {
+	case strings.ToLower(os.Getenv("STORAGE_DRIVER")) == "memory", opts.SpoolPath == "off":
+		opts.SpoolPath = ""
+	case opts.SpoolPath == "":
+		opts.SpoolPath = "messages.spool"
+	}
+
+	w, err := New(s, opts)
+	if err != nil {
+		log.Fatal("Failed to start message writer:", err)
+	}
+	return w
+}
+
+// Save accepts msg for writing. Once it returns nil the message is in the
+// spool (when enabled) and will be saved even if the database is down.
+// Unless msg already has a client key it gets a new one, so a batch that is
+// written again after a crash or a failed commit is not saved twice.
+func (w *Writer) Save(msg models.Message) error {
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+	if msg.ClientKey == "" {
+		key, err := newClientKey()
+		if err != nil {
+			return err
+		}
+		msg.ClientKey = key
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.stop:
+		return ErrClosed
+	default:
+	}
+
+	p := pending{msg: msg}
+	if w.spool != nil {
+		seq, err := w.spool.append(msg)
+		if err != nil {
+			return err
+		}
+		p.seq = seq
+	}
+	select {
+	case w.queue <- p:
+		return nil
+	case <-w.stop:
+		if w.spool != nil {
+			// Saved on the next start.
+			return nil
+		}
+		return ErrClosed
+	}
+}
+
+func newClientKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Stats reports messages saved, messages given up on and batch retries
+// since startup, plus the current queue length.
+func (w *Writer) Stats() map[string]int64 {
+	return map[string]int64{
+		"saved":   w.saved.Load(),
+		"failed":  w.failed.Load(),
+		"retries": w.retries.Load(),
+		"queued":  int64(len(w.queue)),
+	}
+}
+
+// Close stops accepting messages and writes what is queued. If the
+// database is still unreachable it stops retrying; those messages stay in
+// the spool for the next start.
+func (w *Writer) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+		if w.spool != nil {
+			err = w.spool.close()
+		}
+	})
+	return err
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]pending, 0, w.opts.BatchSize)
+	for {
+		select {
+		case p := <-w.queue:
+			batch = append(batch, p)
+			if len(batch) < w.opts.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-w.stop:
+			// Wait for a Save in progress; later ones see stop and return.
+			w.mu.Lock()
+			w.mu.Unlock()
+			for {
+				for len(batch) < w.opts.BatchSize && len(w.queue) > 0 {
+					batch = append(batch, <-w.queue)
+				}
+				if len(batch) == 0 || !w.flush(batch) {
+					return
+				}
+				batch = batch[:0]
+			}
+		}
+		if !w.flush(batch) {
+			return
+		}
+		batch = batch[:0]
+	}
+}
+
+// flush writes a batch, retrying transient errors until it succeeds or the
+// writer is closed. It returns false when it gave up because of Close.
+func (w *Writer) flush(batch []pending) bool {
+	msgs := make([]models.Message, len(batch))
+	for i, p := range batch {
+		msgs[i] = p.msg
+	}
+
+	delay := minRetryDelay
+	for {
+		err := w.store.SaveMessages(msgs)
+		if err == nil {
+			w.saved.Add(int64(len(msgs)))
+			break
+		}
+		if !store.IsTransient(err) {
+			// Something in the batch is bad (e.g. its user was deleted);
+			// save what can be saved one by one.
+			log.Printf("Message batch failed, saving individually: %v", err)
+			w.saveEach(msgs)
+			break
+		}
+
+		w.retries.Add(1)
+		log.Printf("Saving %d messages failed, retrying in %s: %v", len(msgs), delay, err)
+		select {
+		case <-time.After(delay):
+		case <-w.stop:
+			if w.spool != nil {
+				log.Printf("Leaving %d unsaved messages in the spool", len(msgs)+len(w.queue))
+			} else {
+				log.Printf("Dropping %d unsaved messages", len(msgs)+len(w.queue))
+				w.failed.Add(int64(len(msgs) + len(w.queue)))
+			}
+			return false
+		}
+		delay = min(2*delay, maxRetryDelay)
+	}
+
+	if w.spool != nil {
+		if err := w.spool.commit(batch[len(batch)-1].seq); err != nil {
+			log.Printf("Error updating message spool: %v", err)
+		}
+	}
+	return true
+}
+
+func (w *Writer) saveEach(msgs []models.Message) {
+	for i := range msgs {
+		if err := w.store.SaveMessage(&msgs[i]); err != nil {
+			log.Printf("Error saving message: %v", err)
+			w.failed.Add(1)
+			continue
+		}
+		w.saved.Add(1)
+	}
+}
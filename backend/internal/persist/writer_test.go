@@ -0,0 +1,168 @@
+package persist
+
+import (
+	"backend/internal/models"
+	"backend/internal/store"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyStore is an in-memory store whose batch writes fail with a
+// transient error while the database is down.
+type flakyStore struct {
+	*store.Memory
+
+	mu      sync.Mutex
+	down    bool
+	batches int
+}
+
+func newFlakyStore() *flakyStore {
+	return &flakyStore{Memory: store.NewMemory()}
+}
+
+func (s *flakyStore) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *flakyStore) SaveMessages(msgs []models.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches++
+	if s.down {
+		return driver.ErrBadConn
+	}
+	return s.Memory.SaveMessages(msgs)
+}
+
+func (s *flakyStore) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.batches
+}
+
+func contents(t *testing.T, s store.MessageStore) []string {
+	t.Helper()
+	messages, err := s.RecentMessages(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, msg := range messages {
+		contents = append(contents, msg.Content)
+	}
+	return contents
+}
+
+func spoolSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestWriterSavesMessages(t *testing.T) {
+	s := newFlakyStore()
+	path := filepath.Join(t.TempDir(), "messages.spool")
+	w, err := New(s, Options{BatchSize: 2, SpoolPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"one", "two", "three"} {
+		if err := w.Save(models.Message{Username: "alice", Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := contents(t, s); len(got) != 3 {
+		t.Errorf("saved %q, want three messages", got)
+	}
+	if size := spoolSize(t, path); size != 0 {
+		t.Errorf("spool has %d bytes left after everything was saved", size)
+	}
+	if err := w.Save(models.Message{Content: "late"}); err != ErrClosed {
+		t.Errorf("Save after Close: got %v, want ErrClosed", err)
+	}
+}
+
+func TestWriterCloseWhileDatabaseDown(t *testing.T) {
+	s := newFlakyStore()
+	s.setDown(true)
+	path := filepath.Join(t.TempDir(), "messages.spool")
+	w, err := New(s, Options{SpoolPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"one", "two", "three"} {
+		if err := w.Save(models.Message{Username: "alice", Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for s.attempts() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan error)
+	go func() { closed <- w.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close kept retrying while the database was down")
+	}
+	if got := contents(t, s); len(got) != 0 {
+		t.Fatalf("saved %q while the database was down", got)
+	}
+	if stats := w.Stats(); stats["failed"] != 0 || stats["retries"] == 0 {
+		t.Errorf("stats %v, want retries and no failures", stats)
+	}
+
+	// The next start saves what the spool kept.
+	s.setDown(false)
+	w, err = New(s, Options{SpoolPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(t, s); len(got) != 3 {
+		t.Errorf("saved %q after restarting, want three messages", got)
+	}
+}
+
+func TestWriterCloseWhileDatabaseDownWithoutSpool(t *testing.T) {
+	s := newFlakyStore()
+	s.setDown(true)
+	w, err := New(s, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"one", "two"} {
+		if err := w.Save(models.Message{Username: "alice", Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for s.attempts() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if failed := w.Stats()["failed"]; failed != 2 {
+		t.Errorf("failed = %d, want the 2 dropped messages", failed)
+	}
+}
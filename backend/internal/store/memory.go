@@ -0,0 +1,794 @@
+package store
+
+import (
+	"backend/internal/models"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Memory implements Store in process memory. Nothing survives a restart, so
+// it is meant for development, demos and tests rather than production.
+type Memory struct {
+	mu sync.Mutex
+
+	users      map[int]*memoryUser
+	identities map[memoryIdentity]int
+	authTokens map[string]*memoryAuthToken
+	attempts   []memoryLoginAttempt
+	apiTokens  map[int]*memoryAPIToken
+	sanctions  []*memorySanction
+	audit      []models.AuditEvent
+	messages   []models.Message // ordered by CreatedAt, then ID
+	clientKeys map[string]int   // message ID by client key
+
+	lastUserID, lastAttemptID, lastAPITokenID, lastSanctionID, lastAuditID, lastMessageID int
+}
+
+type memoryUser struct {
+	user         models.User
+	passwordHash string
+}
+
+type memoryIdentity struct {
+	issuer, subject string
+}
+
+type memoryAuthToken struct {
+	userID    int
+	purpose   string
+	expiresAt time.Time
+	used      bool
+}
+
+type memoryLoginAttempt struct {
+	id, userID   int
+	username, ip string
+	success      bool
+	at           time.Time
+}
+
+type memoryAPIToken struct {
+	token   models.APIToken
+	hash    string
+	revoked bool
+}
+
+type memorySanction struct {
+	sanction models.Sanction
+	lifted   bool
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:      make(map[int]*memoryUser),
+		identities: make(map[memoryIdentity]int),
+		authTokens: make(map[string]*memoryAuthToken),
+		apiTokens:  make(map[int]*memoryAPIToken),
+		clientKeys: make(map[string]int),
+	}
+}
+
+var _ Store = (*Memory)(nil)
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) CreateUser(user *models.User, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if strings.EqualFold(u.user.Username, user.Username) || strings.EqualFold(u.user.Email, user.Email) {
+			return ErrDuplicate
+		}
+	}
+
+	m.lastUserID++
+	user.ID = m.lastUserID
+	if user.Role == "" {
+		user.Role = "member"
+	}
+	user.CreatedAt = time.Now()
+	m.users[user.ID] = &memoryUser{user: *user, passwordHash: passwordHash}
+	return nil
+}
+
+func (m *Memory) GetUser(userID int) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user := u.user
+	return &user, nil
+}
+
+func (m *Memory) GetUserByLogin(login string) (*models.User, string, error) {
+	login = strings.TrimSpace(login)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// An email match wins over a username match, as in the SQL stores.
+	var match *memoryUser
+	for _, u := range m.users {
+		if strings.EqualFold(u.user.Email, login) {
+			match = u
+			break
+		}
+		if strings.EqualFold(u.user.Username, login) {
+			match = u
+		}
+	}
+	if match == nil {
+		return nil, "", ErrNotFound
+	}
+	user := match.user
+	return &user, match.passwordHash, nil
+}
+
+func (m *Memory) GetUserByEmail(email string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.user.Email == email {
+			user := u.user
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) GetUserByIdentity(issuer, subject string) (*models.User, error) {
+	m.mu.Lock()
+	userID, ok := m.identities[memoryIdentity{issuer, subject}]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m.GetUser(userID)
+}
+
+func (m *Memory) LinkIdentity(userID int, issuer, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memoryIdentity{issuer, subject}
+	if _, ok := m.identities[key]; !ok {
+		m.identities[key] = userID
+	}
+	return nil
+}
+
+func (m *Memory) ListUsers(f UserFilter) ([]models.User, int, error) {
+	query := strings.ToLower(f.Query)
+	m.mu.Lock()
+	var matches []models.User
+	for _, u := range m.users {
+		if query != "" && !strings.Contains(strings.ToLower(u.user.Username), query) && !strings.Contains(u.user.Email, query) {
+			continue
+		}
+		if f.Role != "" && u.user.Role != f.Role {
+			continue
+		}
+		if f.Status == "active" && u.user.DisabledAt != nil || f.Status == "disabled" && u.user.DisabledAt == nil {
+			continue
+		}
+		matches = append(matches, u.user)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+	total := len(matches)
+	users := []models.User{}
+	if f.Offset < total {
+		users = append(users, matches[f.Offset:]...)
+	}
+	if f.Limit > 0 && len(users) > f.Limit {
+		users = users[:f.Limit]
+	}
+	return users, total, nil
+}
+
+// updateUser applies fn to a stored user under the lock.
+func (m *Memory) updateUser(userID int, fn func(u *memoryUser)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	fn(u)
+	return nil
+}
+
+func (m *Memory) SetPasswordHash(userID int, hash string) error {
+	now := time.Now()
+	return m.updateUser(userID, func(u *memoryUser) {
+		u.passwordHash = hash
+		u.user.PasswordChangedAt = &now
+	})
+}
+
+func (m *Memory) ReplacePasswordHash(userID int, oldHash, newHash string) error {
+	err := m.updateUser(userID, func(u *memoryUser) {
+		if u.passwordHash == oldHash {
+			u.passwordHash = newHash
+		}
+	})
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (m *Memory) SetEmailVerified(userID int) error {
+	return m.updateUser(userID, func(u *memoryUser) { u.user.EmailVerified = true })
+}
+
+func (m *Memory) SetRole(userID int, role string) error {
+	return m.updateUser(userID, func(u *memoryUser) { u.user.Role = role })
+}
+
+func (m *Memory) SetDisabled(userID int, disabled bool) error {
+	return m.updateUser(userID, func(u *memoryUser) {
+		switch {
+		case !disabled:
+			u.user.DisabledAt = nil
+		case u.user.DisabledAt == nil:
+			now := time.Now()
+			u.user.DisabledAt = &now
+		}
+	})
+}
+
+func (m *Memory) CreateAuthToken(userID int, purpose, tokenHash string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.authTokens[tokenHash]; ok {
+		return ErrDuplicate
+	}
+	m.authTokens[tokenHash] = &memoryAuthToken{userID: userID, purpose: purpose, expiresAt: expiresAt}
+	return nil
+}
+
+// validAuthToken must be called with the lock held.
+func (m *Memory) validAuthToken(tokenHash, purpose string) (*memoryAuthToken, error) {
+	t, ok := m.authTokens[tokenHash]
+	if !ok || t.purpose != purpose || t.used || !t.expiresAt.After(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return t, nil
+}
+
+func (m *Memory) FindAuthToken(tokenHash, purpose string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.validAuthToken(tokenHash, purpose)
+	if err != nil {
+		return 0, err
+	}
+	return t.userID, nil
+}
+
+func (m *Memory) ConsumeAuthToken(tokenHash, purpose string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.validAuthToken(tokenHash, purpose)
+	if err != nil {
+		return 0, err
+	}
+	t.used = true
+	return t.userID, nil
+}
+
+func (m *Memory) InvalidateAuthTokens(userID int, purpose string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.authTokens {
+		if t.userID == userID && t.purpose == purpose {
+			t.used = true
+		}
+	}
+	return nil
+}
+
+func (m *Memory) ReserveLoginAttempt(username string, userID int, ip string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.lastAttemptID++
+	m.attempts = append(m.attempts, memoryLoginAttempt{
+		id: m.lastAttemptID, userID: userID, username: username, ip: ip, at: now,
+	})
+
+	// Nothing older than a day matters to the throttle; don't grow forever.
+	cutoff := now.Add(-24 * time.Hour)
+	i := sort.Search(len(m.attempts), func(i int) bool { return m.attempts[i].at.After(cutoff) })
+	m.attempts = m.attempts[i:]
+	return m.lastAttemptID, nil
+}
+
+func (m *Memory) RecentLoginFailures(field LoginField, value string, beforeID int, window time.Duration) (int, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	valueOf := func(a memoryLoginAttempt) string { return a.username }
+	switch field {
+	case LoginByUsername:
+	case LoginByUserID:
+		valueOf = func(a memoryLoginAttempt) string {
+			if a.userID == 0 {
+				return ""
+			}
+			return strconv.Itoa(a.userID)
+		}
+	case LoginByIP:
+		valueOf = func(a memoryLoginAttempt) string { return a.ip }
+	default:
+		return 0, 0, fmt.Errorf("unknown login field %q", field)
+	}
+
+	now := time.Now()
+	since := now.Add(-window)
+	count := 0
+	var latest time.Time
+	// Attempts are in time order, so walking backwards stops at the last
+	// success or the edge of the window.
+	for i := len(m.attempts) - 1; i >= 0; i-- {
+		a := m.attempts[i]
+		if a.id >= beforeID {
+			continue
+		}
+		if !a.at.After(since) {
+			break
+		}
+		if valueOf(a) != value {
+			continue
+		}
+		if a.success {
+			break
+		}
+		if count == 0 {
+			latest = a.at
+		}
+		count++
+	}
+
+	if count == 0 {
+		return 0, 0, nil
+	}
+	return count, now.Sub(latest), nil
+}
+
+// findAttempt returns the index of attempt id, or -1. Callers hold m.mu.
+func (m *Memory) findAttempt(id int) int {
+	i := sort.Search(len(m.attempts), func(i int) bool { return m.attempts[i].id >= id })
+	if i < len(m.attempts) && m.attempts[i].id == id {
+		return i
+	}
+	return -1
+}
+
+func (m *Memory) SetLoginAttemptSucceeded(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i := m.findAttempt(id); i >= 0 {
+		m.attempts[i].success = true
+	}
+	return nil
+}
+
+func (m *Memory) DeleteLoginAttempt(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i := m.findAttempt(id); i >= 0 {
+		m.attempts = append(m.attempts[:i], m.attempts[i+1:]...)
+	}
+	return nil
+}
+
+func (m *Memory) CreateAPIToken(token *models.APIToken, tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastAPITokenID++
+	token.ID = m.lastAPITokenID
+	token.CreatedAt = time.Now()
+	stored := *token
+	stored.Scopes = append([]string(nil), token.Scopes...)
+	m.apiTokens[token.ID] = &memoryAPIToken{token: stored, hash: tokenHash}
+	return nil
+}
+
+func (m *Memory) ListAPITokens(userID int) ([]models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tokens := []models.APIToken{}
+	for _, t := range m.apiTokens {
+		if t.token.UserID == userID && !t.revoked {
+			tokens = append(tokens, copyAPIToken(t.token))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID > tokens[j].ID })
+	return tokens, nil
+}
+
+func copyAPIToken(t models.APIToken) models.APIToken {
+	t.Scopes = append([]string(nil), t.Scopes...)
+	return t
+}
+
+func (m *Memory) RevokeAPIToken(userID, tokenID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.apiTokens[tokenID]
+	if !ok || t.token.UserID != userID || t.revoked {
+		return false, nil
+	}
+	t.revoked = true
+	return true, nil
+}
+
+func (m *Memory) RevokeAPITokens(userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revoked := 0
+	for _, t := range m.apiTokens {
+		if t.token.UserID == userID && !t.revoked {
+			t.revoked = true
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (m *Memory) UseAPIToken(tokenHash string) (*models.APIToken, *models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, t := range m.apiTokens {
+		if t.hash != tokenHash {
+			continue
+		}
+		if t.revoked || t.token.ExpiresAt != nil && !t.token.ExpiresAt.After(now) {
+			break
+		}
+		owner, ok := m.users[t.token.UserID]
+		if !ok || owner.user.DisabledAt != nil {
+			break
+		}
+		if t.token.LastUsedAt == nil || now.Sub(*t.token.LastUsedAt) > time.Minute {
+			t.token.LastUsedAt = &now
+		}
+		token := copyAPIToken(t.token)
+		user := owner.user
+		return &token, &user, nil
+	}
+	return nil, nil, ErrNotFound
+}
+
+func (m *Memory) CreateSanction(s *models.Sanction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastSanctionID++
+	s.ID = m.lastSanctionID
+	s.CreatedAt = time.Now()
+	m.sanctions = append(m.sanctions, &memorySanction{sanction: *s})
+	return nil
+}
+
+// activeSanctions must be called with the lock held.
+func (m *Memory) activeSanctions(userID int, kind string) []*memorySanction {
+	now := time.Now()
+	var active []*memorySanction
+	for _, s := range m.sanctions {
+		if s.sanction.UserID != userID || s.sanction.Kind != kind || s.lifted {
+			continue
+		}
+		if s.sanction.ExpiresAt != nil && !s.sanction.ExpiresAt.After(now) {
+			continue
+		}
+		active = append(active, s)
+	}
+	return active
+}
+
+func (m *Memory) ActiveSanction(userID int, kind string) (*models.Sanction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var longest *models.Sanction
+	for _, s := range m.activeSanctions(userID, kind) {
+		switch {
+		case longest == nil, s.sanction.ExpiresAt == nil && longest.ExpiresAt != nil:
+		case longest.ExpiresAt != nil && s.sanction.ExpiresAt != nil && s.sanction.ExpiresAt.After(*longest.ExpiresAt):
+		default:
+			continue
+		}
+		longest = &s.sanction
+	}
+	if longest == nil {
+		return nil, nil
+	}
+	s := *longest
+	return &s, nil
+}
+
+func (m *Memory) LiftSanctions(userID int, kind string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	active := m.activeSanctions(userID, kind)
+	for _, s := range active {
+		s.lifted = true
+	}
+	return len(active) > 0, nil
+}
+
+func (m *Memory) AppendAuditEvent(e *models.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastAuditID++
+	e.ID = m.lastAuditID
+	e.CreatedAt = time.Now()
+	m.audit = append(m.audit, *e)
+	return nil
+}
+
+func (m *Memory) QueryAuditEvents(f AuditFilter) ([]models.AuditEvent, error) {
+	limit := f.Limit
+	if limit <= 0 || limit > maxAuditQueryLimit {
+		limit = maxAuditQueryLimit
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := []models.AuditEvent{}
+	for i := len(m.audit) - 1; i >= 0 && len(events) < limit; i-- {
+		e := m.audit[i]
+		switch {
+		case strings.HasSuffix(f.Action, ".") && !strings.HasPrefix(e.Action, f.Action),
+			f.Action != "" && !strings.HasSuffix(f.Action, ".") && e.Action != f.Action,
+			f.ActorID != 0 && e.ActorID != f.ActorID,
+			f.TargetID != "" && e.TargetID != f.TargetID,
+			f.IP != "" && e.IP != f.IP,
+			!f.Since.IsZero() && e.CreatedAt.Before(f.Since),
+			!f.Until.IsZero() && !e.CreatedAt.Before(f.Until),
+			f.BeforeID != 0 && e.ID >= f.BeforeID:
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// insertMessage keeps m.messages ordered, and gives a message whose client
+// key is already saved that message's ID instead. It must be called with
+// the lock held.
+func (m *Memory) insertMessage(msg *models.Message) {
+	if id, ok := m.clientKeys[msg.ClientKey]; ok && msg.ClientKey != "" {
+		msg.ID = id
+		return
+	}
+	m.lastMessageID++
+	msg.ID = m.lastMessageID
+	if msg.ClientKey != "" {
+		m.clientKeys[msg.ClientKey] = msg.ID
+	}
+	i := sort.Search(len(m.messages), func(i int) bool { return m.messages[i].CreatedAt.After(msg.CreatedAt) })
+	m.messages = append(m.messages, models.Message{})
+	copy(m.messages[i+1:], m.messages[i:])
+	m.messages[i] = *msg
+}
+
+func (m *Memory) SaveMessage(msg *models.Message) error {
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.insertMessage(msg)
+	return nil
+}
+
+func (m *Memory) SaveMessages(msgs []models.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range msgs {
+		msg := msgs[i]
+		if msg.CreatedAt.IsZero() {
+			msg.CreatedAt = time.Now()
+		}
+		m.insertMessage(&msg)
+		msgs[i].ID = msg.ID
+	}
+	return nil
+}
+
+// messageIndex returns the position of a message in m.messages, or -1. It
+// must be called with the lock held.
+func (m *Memory) messageIndex(id int) int {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Memory) GetMessage(id int) (*models.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.messageIndex(id)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	msg := m.messages[i]
+	return &msg, nil
+}
+
+func (m *Memory) RecentMessages(limit int) ([]models.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start := len(m.messages) - limit
+	if start < 0 {
+		start = 0
+	}
+	return append([]models.Message{}, m.messages[start:]...), nil
+}
+
+func (m *Memory) MessagesBefore(before, limit int) ([]models.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	end := m.messageIndex(before)
+	if end < 0 {
+		return nil, ErrNotFound
+	}
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+	return append([]models.Message{}, m.messages[start:end]...), nil
+}
+
+func (m *Memory) SearchMessages(query string, limit int) ([]models.Message, error) {
+	terms := searchWords(query)
+	if len(terms) == 0 {
+		return []models.Message{}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := []models.Message{}
+	for i := len(m.messages) - 1; i >= 0 && len(messages) < limit; i-- {
+		words := make(map[string]bool)
+		for _, w := range searchWords(m.messages[i].Content) {
+			words[w] = true
+		}
+		matched := true
+		for _, t := range terms {
+			if !words[t] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			messages = append(messages, m.messages[i])
+		}
+	}
+	return messages, nil
+}
+
+// searchWords splits text into lowercase words the way the database full
+// text indexes do.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func (m *Memory) EachMessage(since time.Time, fn func(models.Message) error) error {
+	m.mu.Lock()
+	i := sort.Search(len(m.messages), func(i int) bool { return !m.messages[i].CreatedAt.Before(since) })
+	messages := append([]models.Message(nil), m.messages[i:]...)
+	m.mu.Unlock()
+
+	for _, msg := range messages {
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) ImportMessages(next func() (*models.Message, error)) (int, error) {
+	// Read everything first so a bad record leaves the store untouched, like
+	// the transaction in the Postgres implementation.
+	var batch []*models.Message
+	for {
+		msg, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("message %d: %w", len(batch)+1, err)
+		}
+		batch = append(batch, msg)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, msg := range batch {
+		if _, ok := m.clientKeys[msg.ClientKey]; ok && msg.ClientKey != "" {
+			continue
+		}
+		if _, ok := m.users[msg.UserID]; !ok {
+			msg.UserID = 0
+		}
+		if msg.CreatedAt.IsZero() {
+			msg.CreatedAt = time.Now()
+		}
+		m.insertMessage(msg)
+		count++
+	}
+	return count, nil
+}
+
+func (m *Memory) Stats() (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := map[string]int64{
+		"users":        int64(len(m.users)),
+		"messages":     int64(len(m.messages)),
+		"audit_events": int64(len(m.audit)),
+	}
+	for _, u := range m.users {
+		if u.user.DisabledAt != nil {
+			stats["disabled_users"]++
+		}
+	}
+	dayAgo := time.Now().Add(-24 * time.Hour)
+	for _, msg := range m.messages {
+		if msg.CreatedAt.After(dayAgo) {
+			stats["messages_24h"]++
+		}
+	}
+	for _, t := range m.apiTokens {
+		if !t.revoked {
+			stats["active_api_tokens"]++
+		}
+	}
+	return stats, nil
+}
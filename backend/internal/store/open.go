@@ -0,0 +1,28 @@
+package store
+
+import (
+	"backend/internal/database"
+	"log"
+	"os"
+	"strings"
+)
+
+// Open returns the store selected by STORAGE_DRIVER. "postgres" (default)
+// and "sqlite" connect to and migrate the database; "memory" keeps
+// everything in process.
+func Open() Store {
+	if strings.ToLower(os.Getenv("STORAGE_DRIVER")) == "memory" {
+		log.Println("Using in-memory storage; all data is lost on restart")
+		return NewMemory()
+	}
+	database.Connect()
+	return ForDatabase()
+}
+
+// ForDatabase wraps the already open database.DB.
+func ForDatabase() Store {
+	if database.Driver == database.DriverSQLite {
+		return NewSQLite(database.DB)
+	}
+	return NewPostgres(database.DB)
+}
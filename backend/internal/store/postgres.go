@@ -0,0 +1,111 @@
+package store
+
+import (
+	"backend/internal/models"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// Postgres implements Store on top of a migrated Postgres database.
+type Postgres struct {
+	sqlStore
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{sqlStore{db: db}}
+}
+
+var _ Store = (*Postgres)(nil)
+
+// SaveMessages streams msgs with COPY, which is much cheaper than a
+// multi-row INSERT for large batches. COPY can't return ids, so they are
+// taken from the sequence up front, and it can't skip conflicts, so the
+// rows go through a temporary table first; messages whose client key is
+// already saved get the saved message's id.
+func (p *Postgres) SaveMessages(msgs []models.Message) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT nextval('messages_id_seq') FROM generate_series(1, $1)`, len(msgs))
+	if err != nil {
+		return err
+	}
+	for i := 0; rows.Next(); i++ {
+		if err := rows.Scan(&msgs[i].ID); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		CREATE TEMPORARY TABLE message_batch (
+			id INTEGER, user_id INTEGER, username VARCHAR(50), content TEXT, created_at TIMESTAMP, client_key TEXT
+		) ON COMMIT DROP`); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(pq.CopyIn("message_batch", "id", "user_id", "username", "content", "created_at", "client_key"))
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		if _, err := stmt.Exec(msg.ID, nullableInt(msg.UserID), msg.Username, msg.Content, msg.CreatedAt, nullableString(msg.ClientKey)); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO messages (id, user_id, username, content, created_at, client_key)
+		SELECT id, user_id, username, content, created_at, client_key FROM message_batch
+		ON CONFLICT (client_key) DO NOTHING`); err != nil {
+		return err
+	}
+	saved, err := tx.Query(`
+		SELECT b.id, m.id
+		FROM message_batch b
+		JOIN messages m ON m.client_key = b.client_key
+		WHERE m.id <> b.id`)
+	if err != nil {
+		return err
+	}
+	defer saved.Close()
+	index := make(map[int]int, len(msgs))
+	for i, msg := range msgs {
+		index[msg.ID] = i
+	}
+	for saved.Next() {
+		var batchID, savedID int
+		if err := saved.Scan(&batchID, &savedID); err != nil {
+			return err
+		}
+		msgs[index[batchID]].ID = savedID
+	}
+	if err := saved.Err(); err != nil {
+		return err
+	}
+	saved.Close()
+	return tx.Commit()
+}
+
+func (p *Postgres) SearchMessages(query string, limit int) ([]models.Message, error) {
+	return p.queryMessages(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE to_tsvector('simple', content) @@ plainto_tsquery('simple', $1)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2`, query, limit)
+}
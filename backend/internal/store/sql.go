@@ -0,0 +1,320 @@
+package store
+
+import (
+	"backend/internal/database"
+	"backend/internal/models"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqlStore implements the queries shared by Postgres and SQLite. They are
+// written for Postgres; the few spots where the dialects differ go through
+// ago, secondsSince and databaseSize.
+type sqlStore struct {
+	db     *sql.DB
+	sqlite bool
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// rebind rewrites the query's $N placeholders for the store's driver.
+func (s *sqlStore) rebind(query string) string {
+	if !s.sqlite {
+		return query
+	}
+	return database.Rebind(database.DriverSQLite, query)
+}
+
+// bindArgs stores times in UTC on SQLite, which compares them as text.
+func (s *sqlStore) bindArgs(args []interface{}) []interface{} {
+	if !s.sqlite {
+		return args
+	}
+	for i, arg := range args {
+		switch t := arg.(type) {
+		case time.Time:
+			args[i] = t.UTC()
+		case *time.Time:
+			if t != nil {
+				args[i] = t.UTC()
+			}
+		}
+	}
+	return args
+}
+
+func (s *sqlStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.rebind(query), s.bindArgs(args)...)
+}
+
+func (s *sqlStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.rebind(query), s.bindArgs(args)...)
+}
+
+// rowQuerier is a *sql.DB or a *sql.Tx.
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (s *sqlStore) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.rebind(query), s.bindArgs(args)...)
+}
+
+// ago is the current time minus the given number of seconds.
+func (s *sqlStore) ago(seconds string) string {
+	if s.sqlite {
+		return fmt.Sprintf("datetime('now', '-' || (%s) || ' seconds')", seconds)
+	}
+	return fmt.Sprintf("CURRENT_TIMESTAMP - make_interval(secs => %s)", seconds)
+}
+
+// secondsSince is the number of seconds between a timestamp and now.
+func (s *sqlStore) secondsSince(timestamp string) string {
+	if s.sqlite {
+		return fmt.Sprintf("(julianday('now') - julianday(%s)) * 86400", timestamp)
+	}
+	return fmt.Sprintf("EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - %s))", timestamp)
+}
+
+func (s *sqlStore) databaseSize() string {
+	if s.sqlite {
+		return `SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`
+	}
+	return `SELECT pg_database_size(current_database())`
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// IsTransient reports whether err is likely to go away on retry: a lost or
+// refused connection, a serialization failure or deadlock, the server
+// shutting down or out of connections, or a locked SQLite database.
+func IsTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", "40", "53", "57":
+			return true
+		}
+		return false
+	}
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// expectOne turns an UPDATE that matched no rows into ErrNotFound.
+func expectOne(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const userColumns = `id, username, email, email_verified, role, disabled_at, created_at, password_changed_at`
+
+func scanUser(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*models.User, error) {
+	var u models.User
+	dest := append([]interface{}{&u.ID, &u.Username, &u.Email, &u.EmailVerified, &u.Role, &u.DisabledAt, &u.CreatedAt, &u.PasswordChangedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, notFound(err)
+	}
+	return &u, nil
+}
+
+func (s *sqlStore) CreateUser(user *models.User, passwordHash string) error {
+	role := user.Role
+	if role == "" {
+		role = "member"
+	}
+	query := `
+		INSERT INTO users (username, email, password_hash, email_verified, role)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, role, created_at`
+	err := s.queryRow(query, user.Username, user.Email, passwordHash, user.EmailVerified, role).
+		Scan(&user.ID, &user.Role, &user.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (s *sqlStore) GetUser(userID int) (*models.User, error) {
+	return scanUser(s.queryRow(`SELECT `+userColumns+` FROM users WHERE id = $1`, userID))
+}
+
+func (s *sqlStore) GetUserByLogin(login string) (*models.User, string, error) {
+	var hash string
+	// Accounts created before usernames were barred from containing "@"
+	// may have a username that is another account's email; the email wins.
+	user, err := scanUser(s.queryRow(`
+		SELECT `+userColumns+`, password_hash FROM users
+		WHERE LOWER(username) = $1 OR email = $1
+		ORDER BY CASE WHEN email = $1 THEN 0 ELSE 1 END
+		LIMIT 1`,
+		strings.ToLower(strings.TrimSpace(login))), &hash)
+	return user, hash, err
+}
+
+func (s *sqlStore) GetUserByEmail(email string) (*models.User, error) {
+	return scanUser(s.queryRow(`SELECT `+userColumns+` FROM users WHERE email = $1`, email))
+}
+
+func (s *sqlStore) GetUserByIdentity(issuer, subject string) (*models.User, error) {
+	query := `
+		SELECT ` + prefixed("u.", userColumns) + `
+		FROM user_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.issuer = $1 AND i.subject = $2`
+	return scanUser(s.queryRow(query, issuer, subject))
+}
+
+func prefixed(prefix, columns string) string {
+	parts := strings.Split(columns, ", ")
+	for i := range parts {
+		parts[i] = prefix + parts[i]
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (s *sqlStore) LinkIdentity(userID int, issuer, subject string) error {
+	query := `INSERT INTO user_identities (user_id, issuer, subject) VALUES ($1, $2, $3) ON CONFLICT (issuer, subject) DO NOTHING`
+	_, err := s.exec(query, userID, issuer, subject)
+	return err
+}
+
+func (s *sqlStore) ListUsers(f UserFilter) ([]models.User, int, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.Query != "" {
+		add("(LOWER(username) LIKE $%[1]d OR email LIKE $%[1]d)", "%"+strings.ToLower(f.Query)+"%")
+	}
+	if f.Role != "" {
+		add("role = $%d", f.Role)
+	}
+	switch f.Status {
+	case "active":
+		conditions = append(conditions, "disabled_at IS NULL")
+	case "disabled":
+		conditions = append(conditions, "disabled_at IS NOT NULL")
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.queryRow("SELECT COUNT(*) FROM users"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = math.MaxInt32
+	}
+	query := fmt.Sprintf("SELECT %s FROM users%s ORDER BY id LIMIT %d OFFSET %d", userColumns, where, limit, f.Offset)
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *u)
+	}
+	return users, total, rows.Err()
+}
+
+func (s *sqlStore) SetPasswordHash(userID int, hash string) error {
+	query := `UPDATE users SET password_hash = $1, password_changed_at = $2 WHERE id = $3`
+	return expectOne(s.exec(query, hash, time.Now().UTC(), userID))
+}
+
+func (s *sqlStore) ReplacePasswordHash(userID int, oldHash, newHash string) error {
+	_, err := s.exec(`UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3`, newHash, userID, oldHash)
+	return err
+}
+
+func (s *sqlStore) SetEmailVerified(userID int) error {
+	return expectOne(s.exec(`UPDATE users SET email_verified = TRUE WHERE id = $1`, userID))
+}
+
+func (s *sqlStore) SetRole(userID int, role string) error {
+	return expectOne(s.exec(`UPDATE users SET role = $1 WHERE id = $2`, role, userID))
+}
+
+func (s *sqlStore) SetDisabled(userID int, disabled bool) error {
+	query := `UPDATE users SET disabled_at = NULL WHERE id = $1`
+	if disabled {
+		query = `UPDATE users SET disabled_at = COALESCE(disabled_at, CURRENT_TIMESTAMP) WHERE id = $1`
+	}
+	return expectOne(s.exec(query, userID))
+}
+
+func (s *sqlStore) Stats() (map[string]int64, error) {
+	counts := map[string]string{
+		"users":             `SELECT COUNT(*) FROM users`,
+		"disabled_users":    `SELECT COUNT(*) FROM users WHERE disabled_at IS NOT NULL`,
+		"messages":          `SELECT COUNT(*) FROM messages`,
+		"messages_24h":      `SELECT COUNT(*) FROM messages WHERE created_at > ` + s.ago("86400"),
+		"active_api_tokens": `SELECT COUNT(*) FROM api_tokens WHERE revoked_at IS NULL`,
+		"audit_events":      `SELECT COUNT(*) FROM audit_events`,
+		"database_bytes":    s.databaseSize(),
+	}
+	stats := make(map[string]int64, len(counts))
+	for name, query := range counts {
+		var n int64
+		if err := s.queryRow(query).Scan(&n); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		stats[name] = n
+	}
+	return stats, nil
+}
@@ -0,0 +1,222 @@
+package store
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (s *sqlStore) CreateAuthToken(userID int, purpose, tokenHash string, expiresAt time.Time) error {
+	query := `INSERT INTO auth_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err := s.exec(query, userID, purpose, tokenHash, expiresAt)
+	return err
+}
+
+func (s *sqlStore) FindAuthToken(tokenHash, purpose string) (int, error) {
+	query := `
+		SELECT user_id FROM auth_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3`
+	var userID int
+	err := s.queryRow(query, tokenHash, purpose, time.Now()).Scan(&userID)
+	return userID, notFound(err)
+}
+
+func (s *sqlStore) ConsumeAuthToken(tokenHash, purpose string) (int, error) {
+	query := `
+		UPDATE auth_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+		RETURNING user_id`
+	var userID int
+	err := s.queryRow(query, tokenHash, purpose, time.Now()).Scan(&userID)
+	return userID, notFound(err)
+}
+
+func (s *sqlStore) InvalidateAuthTokens(userID int, purpose string) error {
+	query := `UPDATE auth_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+	_, err := s.exec(query, userID, purpose)
+	return err
+}
+
+func (s *sqlStore) ReserveLoginAttempt(username string, userID int, ip string) (int, error) {
+	var owner interface{}
+	if userID != 0 {
+		owner = userID
+	}
+	query := `INSERT INTO login_attempts (username, user_id, ip_address, success) VALUES ($1, $2, $3, FALSE) RETURNING id`
+	var id int
+	err := s.queryRow(query, username, owner, ip).Scan(&id)
+	return id, err
+}
+
+func (s *sqlStore) RecentLoginFailures(field LoginField, value string, beforeID int, window time.Duration) (int, time.Duration, error) {
+	if field != LoginByUsername && field != LoginByUserID && field != LoginByIP {
+		return 0, 0, fmt.Errorf("unknown login field %q", field)
+	}
+	// Only failures after the most recent success count. IDs order the
+	// attempts, since several can share a timestamp.
+	query := fmt.Sprintf(`
+		SELECT COUNT(*), COALESCE(%[2]s, 0)
+		FROM login_attempts f
+		WHERE %[1]s = $1 AND success = FALSE AND id < $2
+			AND created_at > %[3]s
+			AND NOT EXISTS (
+				SELECT 1 FROM login_attempts s
+				WHERE s.%[1]s = $1 AND s.success = TRUE AND s.id > f.id AND s.id < $2)`,
+		field, s.secondsSince("MAX(created_at)"), s.ago("$3"))
+
+	var count int
+	var elapsed float64
+	err := s.queryRow(query, value, beforeID, window.Seconds()).Scan(&count, &elapsed)
+	return count, time.Duration(elapsed * float64(time.Second)), err
+}
+
+func (s *sqlStore) SetLoginAttemptSucceeded(id int) error {
+	_, err := s.exec(`UPDATE login_attempts SET success = TRUE WHERE id = $1`, id)
+	return err
+}
+
+func (s *sqlStore) DeleteLoginAttempt(id int) error {
+	_, err := s.exec(`DELETE FROM login_attempts WHERE id = $1`, id)
+	return err
+}
+
+func (s *sqlStore) CreateAPIToken(token *models.APIToken, tokenHash string) error {
+	query := `
+		INSERT INTO api_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+	return s.queryRow(query, token.UserID, token.Name, token.Prefix, tokenHash,
+		strings.Join(token.Scopes, ","), token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+}
+
+func (s *sqlStore) ListAPITokens(userID int) ([]models.APIToken, error) {
+	query := `
+		SELECT id, user_id, name, token_prefix, scopes, created_at, expires_at, last_used_at
+		FROM api_tokens WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC`
+	rows, err := s.query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		var t models.APIToken
+		var scopes string
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt); err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *sqlStore) RevokeAPIToken(userID, tokenID int) (bool, error) {
+	query := `UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	err := expectOne(s.exec(query, tokenID, userID))
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *sqlStore) RevokeAPITokens(userID int) (int, error) {
+	result, err := s.exec(`UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (s *sqlStore) UseAPIToken(tokenHash string) (*models.APIToken, *models.User, error) {
+	query := `
+		SELECT t.id, t.user_id, t.name, t.token_prefix, t.scopes, t.created_at, t.expires_at, t.last_used_at,
+			` + prefixed("u.", userColumns) + `
+		FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND t.revoked_at IS NULL AND u.disabled_at IS NULL
+			AND (t.expires_at IS NULL OR t.expires_at > $2)`
+	var t models.APIToken
+	var scopes string
+	user, err := scanTokenOwner(s.queryRow(query, tokenHash, time.Now()), &t, &scopes)
+	if err != nil {
+		return nil, nil, err
+	}
+	t.Scopes = strings.Split(scopes, ",")
+
+	// Only write when the recorded value is stale to avoid a write per request.
+	_, err = s.exec(`
+		UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < `+s.ago("60")+`)`, t.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &t, user, nil
+}
+
+func scanTokenOwner(row *sql.Row, t *models.APIToken, scopes *string) (*models.User, error) {
+	var u models.User
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, scopes, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt,
+		&u.ID, &u.Username, &u.Email, &u.EmailVerified, &u.Role, &u.DisabledAt, &u.CreatedAt, &u.PasswordChangedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &u, nil
+}
+
+func (s *sqlStore) CreateSanction(sanction *models.Sanction) error {
+	query := `
+		INSERT INTO user_sanctions (user_id, kind, reason, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	return s.queryRow(query, sanction.UserID, sanction.Kind, sanction.Reason, nullableInt(sanction.CreatedBy),
+		sanction.ExpiresAt).Scan(&sanction.ID, &sanction.CreatedAt)
+}
+
+func (s *sqlStore) ActiveSanction(userID int, kind string) (*models.Sanction, error) {
+	query := `
+		SELECT id, user_id, kind, reason, COALESCE(created_by, 0), created_at, expires_at
+		FROM user_sanctions
+		WHERE user_id = $1 AND kind = $2 AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > $3)
+		ORDER BY expires_at DESC NULLS FIRST
+		LIMIT 1`
+	var sanction models.Sanction
+	err := s.queryRow(query, userID, kind, time.Now()).Scan(
+		&sanction.ID, &sanction.UserID, &sanction.Kind, &sanction.Reason, &sanction.CreatedBy, &sanction.CreatedAt, &sanction.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sanction, nil
+}
+
+func (s *sqlStore) LiftSanctions(userID int, kind string) (bool, error) {
+	query := `
+		UPDATE user_sanctions SET lifted_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND kind = $2 AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > $3)`
+	result, err := s.exec(query, userID, kind, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func nullableInt(v int) interface{} {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
+
+func nullableString(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"backend/internal/models"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLoginAttempts(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		alice := createUser(t, s, "alice", "alice@example.com")
+		userID := strconv.Itoa(alice.ID)
+
+		reserve := func(username string, userID int, ip string) int {
+			t.Helper()
+			id, err := s.ReserveLoginAttempt(username, userID, ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+		failures := func(field LoginField, value string, beforeID int) int {
+			t.Helper()
+			count, _, err := s.RecentLoginFailures(field, value, beforeID, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return count
+		}
+
+		reserve("alice", alice.ID, "10.0.0.1")
+		reserve("alice@example.com", alice.ID, "10.0.0.2")
+		reserve("nobody", 0, "10.0.0.1")
+		pending := reserve("alice", alice.ID, "10.0.0.1")
+
+		// Only attempts reserved before the given one count.
+		if got := failures(LoginByUsername, "alice", pending); got != 1 {
+			t.Errorf("username failures = %d, want 1", got)
+		}
+		if got := failures(LoginByUserID, userID, pending); got != 2 {
+			t.Errorf("user failures = %d, want 2", got)
+		}
+		if got := failures(LoginByIP, "10.0.0.1", pending); got != 2 {
+			t.Errorf("IP failures = %d, want 2", got)
+		}
+
+		// A success resets the count for attempts after it.
+		if err := s.SetLoginAttemptSucceeded(pending); err != nil {
+			t.Fatal(err)
+		}
+		next := reserve("alice", alice.ID, "10.0.0.1")
+		if got := failures(LoginByUserID, userID, next); got != 0 {
+			t.Errorf("user failures after a success = %d, want 0", got)
+		}
+
+		// Deleted attempts are gone.
+		if err := s.DeleteLoginAttempt(next); err != nil {
+			t.Fatal(err)
+		}
+		later := reserve("alice", alice.ID, "10.0.0.1")
+		if got := failures(LoginByUserID, userID, later); got != 0 {
+			t.Errorf("user failures after a deleted attempt = %d, want 0", got)
+		}
+
+		if _, _, err := s.RecentLoginFailures("password", "x", later, time.Hour); err == nil {
+			t.Error("unknown field accepted")
+		}
+	})
+}
+
+func TestUseAPIToken(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		alice := createUser(t, s, "alice", "alice@example.com")
+		expired := time.Now().Add(-time.Minute)
+		tokens := map[string]*models.APIToken{
+			"active":  {UserID: alice.ID, Name: "active", Prefix: "inbx_a", Scopes: []string{"messages:read"}},
+			"expired": {UserID: alice.ID, Name: "expired", Prefix: "inbx_e", Scopes: []string{"messages:read"}, ExpiresAt: &expired},
+			"revoked": {UserID: alice.ID, Name: "revoked", Prefix: "inbx_r", Scopes: []string{"messages:read"}},
+		}
+		for hash, token := range tokens {
+			if err := s.CreateAPIToken(token, hash); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := s.RevokeAPIToken(alice.ID, tokens["revoked"].ID); err != nil {
+			t.Fatal(err)
+		}
+
+		token, owner, err := s.UseAPIToken("active")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.ID != tokens["active"].ID || owner.ID != alice.ID {
+			t.Errorf("got token %+v of user %d", token, owner.ID)
+		}
+		for _, hash := range []string{"expired", "revoked", "unknown"} {
+			if _, _, err := s.UseAPIToken(hash); err != ErrNotFound {
+				t.Errorf("%s: got %v, want ErrNotFound", hash, err)
+			}
+		}
+
+		if err := s.SetDisabled(alice.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.UseAPIToken("active"); err != ErrNotFound {
+			t.Errorf("disabled owner: got %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestPasswordChangeRevokesCredentials(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		alice := createUser(t, s, "alice", "alice@example.com")
+		bob := createUser(t, s, "bob", "bob@example.com")
+		if alice.PasswordChangedAt != nil {
+			t.Errorf("new user has password changed at %v", alice.PasswordChangedAt)
+		}
+		for i, owner := range []int{alice.ID, alice.ID, bob.ID} {
+			token := &models.APIToken{UserID: owner, Name: "bot", Prefix: "inbx_" + strconv.Itoa(i), Scopes: []string{"messages:read"}}
+			if err := s.CreateAPIToken(token, "hash"+strconv.Itoa(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		before := time.Now().Add(-time.Second)
+		if err := s.SetPasswordHash(alice.ID, "new"); err != nil {
+			t.Fatal(err)
+		}
+		user, err := s.GetUser(alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.PasswordChangedAt == nil || user.PasswordChangedAt.Before(before) || user.PasswordChangedAt.After(time.Now()) {
+			t.Errorf("password changed at %v, want about now", user.PasswordChangedAt)
+		}
+
+		if n, err := s.RevokeAPITokens(alice.ID); err != nil || n != 2 {
+			t.Errorf("revoked %d, %v; want 2", n, err)
+		}
+		if n, err := s.RevokeAPITokens(alice.ID); err != nil || n != 0 {
+			t.Errorf("revoking again: %d, %v; want 0", n, err)
+		}
+		if _, _, err := s.UseAPIToken("hash0"); err != ErrNotFound {
+			t.Errorf("alice's token: got %v, want ErrNotFound", err)
+		}
+		if _, _, err := s.UseAPIToken("hash2"); err != nil {
+			t.Errorf("bob's token: %v", err)
+		}
+	})
+}
+
+func TestAuthTokens(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		alice := createUser(t, s, "alice", "alice@example.com")
+		bob := createUser(t, s, "bob", "bob@example.com")
+		const verify, reset = "email_verification", "password_reset"
+		later := time.Now().Add(time.Hour)
+		for hash, owner := range map[string]int{"a1": alice.ID, "a2": alice.ID, "b1": bob.ID} {
+			if err := s.CreateAuthToken(owner, verify, hash, later); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.CreateAuthToken(alice.ID, reset, "expired", time.Now().Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.ConsumeAuthToken("a1", reset); err != ErrNotFound {
+			t.Errorf("wrong purpose: got %v, want ErrNotFound", err)
+		}
+		if _, err := s.FindAuthToken("expired", reset); err != ErrNotFound {
+			t.Errorf("expired: got %v, want ErrNotFound", err)
+		}
+		if owner, err := s.FindAuthToken("a1", verify); err != nil || owner != alice.ID {
+			t.Errorf("FindAuthToken = %d, %v; want %d", owner, err, alice.ID)
+		}
+		if owner, err := s.ConsumeAuthToken("a1", verify); err != nil || owner != alice.ID {
+			t.Errorf("ConsumeAuthToken = %d, %v; want %d", owner, err, alice.ID)
+		}
+		if _, err := s.ConsumeAuthToken("a1", verify); err != ErrNotFound {
+			t.Errorf("used: got %v, want ErrNotFound", err)
+		}
+
+		if err := s.InvalidateAuthTokens(alice.ID, verify); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.ConsumeAuthToken("a2", verify); err != ErrNotFound {
+			t.Errorf("invalidated: got %v, want ErrNotFound", err)
+		}
+		if owner, err := s.ConsumeAuthToken("b1", verify); err != nil || owner != bob.ID {
+			t.Errorf("another user's token: %d, %v; want %d", owner, err, bob.ID)
+		}
+	})
+}
+
+func TestSanctions(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		alice := createUser(t, s, "alice", "alice@example.com")
+		mod := createUser(t, s, "mod", "mod@example.com")
+		at := func(d time.Duration) *time.Time {
+			t := time.Now().Add(d)
+			return &t
+		}
+		sanctions := []*models.Sanction{
+			{UserID: alice.ID, Kind: "mute", CreatedBy: mod.ID, ExpiresAt: at(-time.Minute)},
+			{UserID: alice.ID, Kind: "mute", CreatedBy: mod.ID, ExpiresAt: at(time.Hour), Reason: "longest"},
+			{UserID: alice.ID, Kind: "mute", CreatedBy: mod.ID, ExpiresAt: at(time.Minute)},
+			{UserID: alice.ID, Kind: "ban", CreatedBy: mod.ID, ExpiresAt: at(time.Hour)},
+			{UserID: alice.ID, Kind: "ban", CreatedBy: mod.ID, Reason: "permanent"},
+			{UserID: mod.ID, Kind: "ban", CreatedBy: alice.ID, ExpiresAt: at(-time.Minute)},
+		}
+		for _, sanction := range sanctions {
+			if err := s.CreateSanction(sanction); err != nil {
+				t.Fatal(err)
+			}
+			if sanction.ID == 0 || sanction.CreatedAt.IsZero() {
+				t.Fatalf("CreateSanction did not fill in %+v", sanction)
+			}
+		}
+
+		for kind, want := range map[string]string{"mute": "longest", "ban": "permanent"} {
+			active, err := s.ActiveSanction(alice.ID, kind)
+			if err != nil || active == nil || active.Reason != want {
+				t.Errorf("active %s = %+v, %v; want the %s one", kind, active, err, want)
+			}
+		}
+		if active, err := s.ActiveSanction(mod.ID, "ban"); err != nil || active != nil {
+			t.Errorf("expired ban is active: %+v, %v", active, err)
+		}
+
+		if lifted, err := s.LiftSanctions(alice.ID, "mute"); err != nil || !lifted {
+			t.Fatalf("LiftSanctions = %v, %v", lifted, err)
+		}
+		if active, err := s.ActiveSanction(alice.ID, "mute"); err != nil || active != nil {
+			t.Errorf("lifted mute is active: %+v, %v", active, err)
+		}
+		if lifted, err := s.LiftSanctions(alice.ID, "mute"); err != nil || lifted {
+			t.Errorf("lifting again = %v, %v; want false", lifted, err)
+		}
+		if active, err := s.ActiveSanction(alice.ID, "ban"); err != nil || active == nil {
+			t.Errorf("lifting mutes lifted the ban: %+v, %v", active, err)
+		}
+	})
+}
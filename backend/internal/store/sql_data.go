@@ -0,0 +1,276 @@
+package store
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const maxAuditQueryLimit = 10000
+
+func (s *sqlStore) AppendAuditEvent(e *models.AuditEvent) error {
+	metadata := []byte("{}")
+	if len(e.Metadata) > 0 {
+		var err error
+		if metadata, err = json.Marshal(e.Metadata); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO audit_events (action, actor_id, actor_username, target_type, target_id, ip_address, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`
+	return s.queryRow(query, e.Action, nullableInt(e.ActorID), e.ActorUsername,
+		e.TargetType, e.TargetID, e.IP, string(metadata)).Scan(&e.ID, &e.CreatedAt)
+}
+
+func (s *sqlStore) QueryAuditEvents(f AuditFilter) ([]models.AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.Action != "" {
+		if strings.HasSuffix(f.Action, ".") {
+			add("action LIKE $%d", f.Action+"%")
+		} else {
+			add("action = $%d", f.Action)
+		}
+	}
+	if f.ActorID != 0 {
+		add("actor_id = $%d", f.ActorID)
+	}
+	if f.TargetID != "" {
+		add("target_id = $%d", f.TargetID)
+	}
+	if f.IP != "" {
+		add("ip_address = $%d", f.IP)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("created_at < $%d", f.Until)
+	}
+	if f.BeforeID != 0 {
+		add("id < $%d", f.BeforeID)
+	}
+
+	limit := f.Limit
+	if limit <= 0 || limit > maxAuditQueryLimit {
+		limit = maxAuditQueryLimit
+	}
+
+	query := `
+		SELECT id, created_at, action, COALESCE(actor_id, 0), actor_username, target_type, target_id, ip_address, metadata
+		FROM audit_events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT %d", limit)
+
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var e models.AuditEvent
+		var metadata string
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.Action, &e.ActorID, &e.ActorUsername,
+			&e.TargetType, &e.TargetID, &e.IP, &metadata); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(metadata), &e.Metadata); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+const insertMessage = `
+	INSERT INTO messages (user_id, username, content, created_at, client_key) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (client_key) DO NOTHING RETURNING id`
+
+func (s *sqlStore) SaveMessage(msg *models.Message) error {
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+	return s.saveMessage(s.db, msg)
+}
+
+// saveMessage inserts msg unless a message with its client key is already
+// saved, in which case msg gets that message's ID.
+func (s *sqlStore) saveMessage(q rowQuerier, msg *models.Message) error {
+	err := q.QueryRow(s.rebind(insertMessage), s.bindArgs([]interface{}{
+		nullableInt(msg.UserID), msg.Username, msg.Content, msg.CreatedAt, nullableString(msg.ClientKey),
+	})...).Scan(&msg.ID)
+	if err != sql.ErrNoRows {
+		return err
+	}
+	return q.QueryRow(s.rebind(`SELECT id FROM messages WHERE client_key = $1`), msg.ClientKey).Scan(&msg.ID)
+}
+
+// SaveMessages inserts msgs one row at a time in a single transaction,
+// which SQLite does about as fast as a multi-row insert and which leaves
+// each row free to be skipped on a client key conflict.
+func (s *sqlStore) SaveMessages(msgs []models.Message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range msgs {
+		if msgs[i].CreatedAt.IsZero() {
+			msgs[i].CreatedAt = time.Now()
+		}
+		if err := s.saveMessage(tx, &msgs[i]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const messageColumns = `id, user_id, username, content, created_at, client_key`
+
+func scanMessage(row interface{ Scan(...interface{}) error }) (*models.Message, error) {
+	var msg models.Message
+	var userID sql.NullInt64
+	var clientKey sql.NullString
+	if err := row.Scan(&msg.ID, &userID, &msg.Username, &msg.Content, &msg.CreatedAt, &clientKey); err != nil {
+		return nil, notFound(err)
+	}
+	msg.UserID = int(userID.Int64)
+	msg.ClientKey = clientKey.String
+	return &msg, nil
+}
+
+func (s *sqlStore) GetMessage(id int) (*models.Message, error) {
+	return scanMessage(s.queryRow(`SELECT `+messageColumns+` FROM messages WHERE id = $1`, id))
+}
+
+func (s *sqlStore) RecentMessages(limit int) ([]models.Message, error) {
+	messages, err := s.queryMessages(`
+		SELECT `+messageColumns+`
+		FROM messages
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	reverseMessages(messages)
+	return messages, nil
+}
+
+func (s *sqlStore) MessagesBefore(before, limit int) ([]models.Message, error) {
+	cursor, err := s.GetMessage(before)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := s.queryMessages(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE created_at < $1 OR (created_at = $1 AND id < $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3`, cursor.CreatedAt, cursor.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	reverseMessages(messages)
+	return messages, nil
+}
+
+// reverseMessages turns a newest-first query result into chronological order.
+func reverseMessages(messages []models.Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
+
+func (s *sqlStore) queryMessages(query string, args ...interface{}) ([]models.Message, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *msg)
+	}
+	return messages, rows.Err()
+}
+
+func (s *sqlStore) EachMessage(since time.Time, fn func(models.Message) error) error {
+	rows, err := s.query(`
+		SELECT `+messageColumns+`
+		FROM messages WHERE created_at >= $1 ORDER BY created_at, id`, since)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(*msg); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) ImportMessages(next func() (*models.Message, error)) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(s.rebind(`
+		INSERT INTO messages (user_id, username, content, created_at, client_key)
+		VALUES ((SELECT id FROM users WHERE id = $1), $2, $3, $4, $5)
+		ON CONFLICT (client_key) DO NOTHING`))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	read, count := 0, 0
+	for {
+		msg, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("message %d: %w", read+1, err)
+		}
+		result, err := stmt.Exec(s.bindArgs([]interface{}{msg.UserID, msg.Username, msg.Content, msg.CreatedAt, nullableString(msg.ClientKey)})...)
+		if err != nil {
+			return 0, fmt.Errorf("message %d: %w", read+1, err)
+		}
+		read++
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		count += int(inserted)
+	}
+	return count, tx.Commit()
+}
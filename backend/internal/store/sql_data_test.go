@@ -0,0 +1,155 @@
+package store
+
+import (
+	"backend/internal/models"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSaveMessagesSkipsSavedClientKeys(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		alice := createUser(t, s, "alice", "alice@example.com")
+		at := time.Now().Add(-time.Minute).Truncate(time.Second)
+		message := func(content, key string) models.Message {
+			return models.Message{UserID: alice.ID, Username: "alice", Content: content, CreatedAt: at, ClientKey: key}
+		}
+
+		first := message("one", "k1")
+		if err := s.SaveMessage(&first); err != nil {
+			t.Fatal(err)
+		}
+		batch := []models.Message{message("one", "k1"), message("two", "k2"), message("three", ""), message("four", "")}
+		if err := s.SaveMessages(batch); err != nil {
+			t.Fatal(err)
+		}
+		if batch[0].ID != first.ID {
+			t.Errorf("replayed message got id %d, want %d", batch[0].ID, first.ID)
+		}
+		again := message("two", "k2")
+		if err := s.SaveMessage(&again); err != nil {
+			t.Fatal(err)
+		}
+		if again.ID != batch[1].ID {
+			t.Errorf("replayed message got id %d, want %d", again.ID, batch[1].ID)
+		}
+
+		messages, err := s.RecentMessages(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var contents []string
+		for _, msg := range messages {
+			contents = append(contents, msg.Content)
+		}
+		if len(messages) != 4 {
+			t.Fatalf("saved %q, want one, two, three and four once each", contents)
+		}
+		for i, msg := range batch[1:] {
+			if saved, err := s.GetMessage(msg.ID); err != nil || saved.Content != msg.Content {
+				t.Errorf("batch[%d] got id %d: %v, %v", i+1, msg.ID, saved, err)
+			}
+		}
+	})
+}
+
+func TestImportMessagesSkipsSavedClientKeys(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		at := time.Now().Add(-time.Minute).Truncate(time.Second)
+		batch := []models.Message{
+			{Username: "alice", Content: "one", CreatedAt: at, ClientKey: "k1"},
+			{Username: "alice", Content: "two", CreatedAt: at, ClientKey: "k2"},
+		}
+		importBatch := func() (int, error) {
+			i := 0
+			return s.ImportMessages(func() (*models.Message, error) {
+				if i == len(batch) {
+					return nil, io.EOF
+				}
+				msg := batch[i]
+				i++
+				return &msg, nil
+			})
+		}
+
+		if n, err := importBatch(); err != nil || n != 2 {
+			t.Fatalf("first import: %d, %v; want 2", n, err)
+		}
+		batch = append(batch, models.Message{Username: "alice", Content: "three", CreatedAt: at, ClientKey: "k3"})
+		if n, err := importBatch(); err != nil || n != 1 {
+			t.Fatalf("second import: %d, %v; want 1", n, err)
+		}
+		messages, err := s.RecentMessages(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 3 {
+			t.Errorf("%d messages saved, want 3", len(messages))
+		}
+	})
+}
+
+func TestQueryAuditEvents(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		alice := createUser(t, s, "alice", "alice@example.com")
+		events := []models.AuditEvent{
+			{Action: "auth.login_failed", TargetType: "user", TargetID: "alice", IP: "192.0.2.1"},
+			{Action: "auth.login_succeeded", ActorID: alice.ID, ActorUsername: "alice", IP: "192.0.2.1"},
+			{Action: "moderation.kick", ActorID: alice.ID, TargetType: "user", TargetID: "2", IP: "192.0.2.2",
+				Metadata: map[string]interface{}{"reason": "spam", "count": 2.0}},
+			{Action: "moderation.ban", ActorID: alice.ID, TargetType: "user", TargetID: "2", IP: "192.0.2.2"},
+		}
+		for i := range events {
+			if err := s.AppendAuditEvent(&events[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids := func(indexes ...int) []int {
+			out := []int{}
+			for _, i := range indexes {
+				out = append(out, events[i].ID)
+			}
+			return out
+		}
+
+		now := time.Now()
+		tests := []struct {
+			name   string
+			filter AuditFilter
+			want   []int
+		}{
+			{"all, newest first", AuditFilter{}, ids(3, 2, 1, 0)},
+			{"exact action", AuditFilter{Action: "moderation.kick"}, ids(2)},
+			{"action prefix", AuditFilter{Action: "auth."}, ids(1, 0)},
+			{"action is not a prefix without a dot", AuditFilter{Action: "auth"}, ids()},
+			{"actor", AuditFilter{ActorID: alice.ID}, ids(3, 2, 1)},
+			{"target", AuditFilter{TargetID: "2"}, ids(3, 2)},
+			{"ip", AuditFilter{IP: "192.0.2.1"}, ids(1, 0)},
+			{"time window", AuditFilter{Since: now.Add(-time.Hour), Until: now.Add(time.Hour)}, ids(3, 2, 1, 0)},
+			{"future", AuditFilter{Since: now.Add(time.Hour)}, ids()},
+			{"page", AuditFilter{BeforeID: events[2].ID, Limit: 1}, ids(1)},
+		}
+		for _, tt := range tests {
+			got, err := s.QueryAuditEvents(tt.filter)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			gotIDs := []int{}
+			for _, e := range got {
+				gotIDs = append(gotIDs, e.ID)
+			}
+			if !reflect.DeepEqual(gotIDs, tt.want) {
+				t.Errorf("%s: got %v, want %v", tt.name, gotIDs, tt.want)
+			}
+		}
+
+		got, err := s.QueryAuditEvents(AuditFilter{Action: "moderation.kick"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := got[0]; !reflect.DeepEqual(e.Metadata, events[2].Metadata) || e.ActorID != alice.ID || e.TargetType != "user" || e.CreatedAt.IsZero() {
+			t.Errorf("event read back as %+v", e)
+		}
+	})
+}
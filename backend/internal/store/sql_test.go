@@ -0,0 +1,43 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetUserByLogin(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		alice := createUser(t, s, "Alice", "alice@example.com")
+		// Usernames may no longer contain "@", but older accounts can.
+		legacy := createUser(t, s, "alice@example.com.old", "legacy@example.com")
+		squatter := createUser(t, s, "bob@example.com", "squatter@example.com")
+		bob := createUser(t, s, "bob", "bob@example.com")
+
+		tests := []struct {
+			login string
+			want  int
+		}{
+			{"alice", alice.ID},
+			{" ALICE ", alice.ID},
+			{"Alice@Example.com", alice.ID},
+			{"alice@example.com.old", legacy.ID},
+			{"bob@example.com", bob.ID},
+			{"BOB@example.com", bob.ID},
+			{"squatter@example.com", squatter.ID},
+		}
+		for _, tt := range tests {
+			user, hash, err := s.GetUserByLogin(tt.login)
+			if err != nil {
+				t.Errorf("%q: %v", tt.login, err)
+				continue
+			}
+			if user.ID != tt.want || hash != "hash" {
+				t.Errorf("%q: got user %d, want %d", tt.login, user.ID, tt.want)
+			}
+		}
+
+		if _, _, err := s.GetUserByLogin("carol"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("unknown login: got %v, want ErrNotFound", err)
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"strings"
+)
+
+// SQLite implements Store on a single database file, for small deployments
+// that don't want to run Postgres. Search uses an FTS5 index.
+type SQLite struct {
+	sqlStore
+}
+
+func NewSQLite(db *sql.DB) *SQLite {
+	return &SQLite{sqlStore{db: db, sqlite: true}}
+}
+
+var _ Store = (*SQLite)(nil)
+
+func (s *SQLite) SearchMessages(query string, limit int) ([]models.Message, error) {
+	match := ftsQuery(query)
+	if match == "" {
+		return []models.Message{}, nil
+	}
+	return s.queryMessages(`
+		SELECT `+prefixed("m.", messageColumns)+`
+		FROM messages_fts f JOIN messages m ON m.id = f.rowid
+		WHERE messages_fts MATCH $1
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT $2`, match, limit)
+}
+
+// ftsQuery quotes every word of a user's search so FTS5 operators and
+// punctuation are matched literally. Like plainto_tsquery, all words must
+// appear.
+func ftsQuery(query string) string {
+	words := strings.Fields(query)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
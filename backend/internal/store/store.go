@@ -0,0 +1,147 @@
+// Package store defines the persistence interfaces used by the rest of the
+// backend, with Postgres and in-memory implementations.
+package store
+
+import (
+	"backend/internal/models"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrDuplicate = errors.New("already exists")
+)
+
+type UserFilter struct {
+	Query  string // matches username or email, case-insensitive
+	Role   string
+	Status string // "active", "disabled" or "" for all
+	Limit  int
+	Offset int
+}
+
+type UserStore interface {
+	// CreateUser inserts user and fills in its ID, role and creation time.
+	// Returns ErrDuplicate if the username or email is taken (ignoring case).
+	CreateUser(user *models.User, passwordHash string) error
+	GetUser(userID int) (*models.User, error)
+	// GetUserByLogin finds a user by username (case-insensitive) or email and
+	// also returns the stored password hash.
+	GetUserByLogin(login string) (*models.User, string, error)
+	GetUserByEmail(email string) (*models.User, error)
+	GetUserByIdentity(issuer, subject string) (*models.User, error)
+	LinkIdentity(userID int, issuer, subject string) error
+	ListUsers(f UserFilter) ([]models.User, int, error)
+
+	SetPasswordHash(userID int, hash string) error
+	// ReplacePasswordHash only updates the hash if it still equals oldHash.
+	ReplacePasswordHash(userID int, oldHash, newHash string) error
+	SetEmailVerified(userID int) error
+	SetRole(userID int, role string) error
+	SetDisabled(userID int, disabled bool) error
+}
+
+type LoginField string
+
+const (
+	LoginByUsername LoginField = "username"
+	LoginByUserID   LoginField = "user_id"
+	LoginByIP       LoginField = "ip_address"
+)
+
+type CredentialStore interface {
+	CreateAuthToken(userID int, purpose, tokenHash string, expiresAt time.Time) error
+	// FindAuthToken returns the owner of a valid, unused token.
+	FindAuthToken(tokenHash, purpose string) (int, error)
+	// ConsumeAuthToken atomically marks a valid token used and returns its owner.
+	ConsumeAuthToken(tokenHash, purpose string) (int, error)
+	InvalidateAuthTokens(userID int, purpose string) error
+
+	// ReserveLoginAttempt records a failed login attempt before it is
+	// checked and returns its ID. userID is 0 when the login matches no user.
+	ReserveLoginAttempt(username string, userID int, ip string) (int, error)
+	// RecentLoginFailures counts the failures recorded before attempt
+	// beforeID since the last success within window, and reports how long
+	// ago the most recent one happened.
+	RecentLoginFailures(field LoginField, value string, beforeID int, window time.Duration) (int, time.Duration, error)
+	SetLoginAttemptSucceeded(id int) error
+	DeleteLoginAttempt(id int) error
+
+	// CreateAPIToken stores token and fills in its ID and creation time.
+	CreateAPIToken(token *models.APIToken, tokenHash string) error
+	ListAPITokens(userID int) ([]models.APIToken, error)
+	RevokeAPIToken(userID, tokenID int) (bool, error)
+	// RevokeAPITokens revokes every active token of the user and returns
+	// how many there were.
+	RevokeAPITokens(userID int) (int, error)
+	// UseAPIToken returns an active token and its owner, recording the use.
+	// Tokens of disabled users are not active.
+	UseAPIToken(tokenHash string) (*models.APIToken, *models.User, error)
+}
+
+type SanctionStore interface {
+	// CreateSanction stores s and fills in its ID and creation time.
+	CreateSanction(s *models.Sanction) error
+	// ActiveSanction returns the longest-running active sanction of kind, or
+	// nil if there is none.
+	ActiveSanction(userID int, kind string) (*models.Sanction, error)
+	LiftSanctions(userID int, kind string) (bool, error)
+}
+
+type AuditFilter struct {
+	Action   string // exact match, or prefix when it ends with "."
+	ActorID  int
+	TargetID string
+	IP       string
+	Since    time.Time
+	Until    time.Time
+	BeforeID int // for paging: only events with a smaller ID
+	Limit    int
+}
+
+type AuditStore interface {
+	AppendAuditEvent(e *models.AuditEvent) error
+	// QueryAuditEvents returns matching events, newest first.
+	QueryAuditEvents(f AuditFilter) ([]models.AuditEvent, error)
+}
+
+type MessageStore interface {
+	// SaveMessage stores msg and fills in its ID (and CreatedAt if unset).
+	SaveMessage(msg *models.Message) error
+	// SaveMessages stores msgs in a single transaction and fills in their
+	// IDs. It is the bulk path for the asynchronous writer.
+	SaveMessages(msgs []models.Message) error
+	// GetMessage returns ErrNotFound if there is no message with that ID.
+	GetMessage(id int) (*models.Message, error)
+	// RecentMessages returns up to limit of the newest messages, oldest first.
+	RecentMessages(limit int) ([]models.Message, error)
+	// MessagesBefore returns up to limit of the newest messages older than
+	// the message with ID before, oldest first. It returns ErrNotFound if
+	// there is no such message.
+	MessagesBefore(before, limit int) ([]models.Message, error)
+	// SearchMessages returns up to limit messages containing every word of
+	// query, newest first.
+	SearchMessages(query string, limit int) ([]models.Message, error)
+	// EachMessage calls fn for every message created at or after since,
+	// oldest first.
+	EachMessage(since time.Time, fn func(models.Message) error) error
+	// ImportMessages stores messages from next until it returns io.EOF and
+	// returns how many it saved. Users that no longer exist are dropped from
+	// the user reference, and messages whose ClientKey is already saved are
+	// skipped.
+	ImportMessages(next func() (*models.Message, error)) (int, error)
+}
+
+// Store is everything the server persists.
+type Store interface {
+	UserStore
+	CredentialStore
+	SanctionStore
+	AuditStore
+	MessageStore
+
+	// Stats returns row counts and similar figures for operators.
+	Stats() (map[string]int64, error)
+	Close() error
+}
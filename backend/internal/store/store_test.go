@@ -0,0 +1,52 @@
+package store
+
+import (
+	"backend/internal/database"
+	"backend/internal/models"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// eachStore runs fn against the in-memory store and a migrated SQLite
+// database. SQLite is skipped unless the tests are built with
+// -tags sqlite_fts5, which the search migration needs.
+func eachStore(t *testing.T, fn func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) { fn(t, NewMemory()) })
+	t.Run("sqlite", func(t *testing.T) { fn(t, openSQLite(t)) })
+}
+
+func openSQLite(t *testing.T) Store {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_foreign_keys=on&_busy_timeout=5000&_txlock=immediate"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	var fts5 bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		t.Fatal(err)
+	}
+	if !fts5 {
+		t.Skip("SQLite built without FTS5; run with -tags sqlite_fts5")
+	}
+
+	previousDB, previousDriver := database.DB, database.Driver
+	database.DB, database.Driver = db, database.DriverSQLite
+	t.Cleanup(func() { database.DB, database.Driver = previousDB, previousDriver })
+	if _, err := database.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	return NewSQLite(db)
+}
+
+func createUser(t *testing.T, s Store, username, email string) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, Email: email}
+	if err := s.CreateUser(user, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
@@ -29,6 +29,15 @@ func ErrorResponse(c *gin.Context, statusCode int, message string, err string) {
 	})
 }
 
+func ErrorResponseWithData(c *gin.Context, statusCode int, message string, err string, data interface{}) {
+	c.JSON(statusCode, Response{
+		Success: false,
+		Message: message,
+		Data:    data,
+		Error:   err,
+	})
+}
+
 func CreatedResponse(c *gin.Context, message string, data interface{}) {
 	c.JSON(http.StatusCreated, Response{
 		Success: true,